@@ -2,7 +2,9 @@ package logger
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
 	_ "unsafe"
 
 	"go.uber.org/zap"
@@ -16,6 +18,14 @@ const (
 	ZapTopicConfigEnable   = "Enable"
 	ZapTopicConfigEntries  = "Entries"
 	ZapTopicConfigProvider = "Provider"
+	ZapTopicConfigEncoding = "Encoding"
+	// ZapTopicConfigName is answered with the topic prefix instead of a stored value.
+	ZapTopicConfigName = "TopicName"
+)
+
+const (
+	ZapTopicEncodingConsole = "console"
+	ZapTopicEncodingJSON    = "json"
 )
 
 func WordMeansTrue(text string) bool {
@@ -39,6 +49,9 @@ type paramStoreProxy struct {
 }
 
 func (p *paramStoreProxy) Get(key string) (string, bool) {
+	if key == ZapTopicConfigName {
+		return p.prefix, p.prefix != ""
+	}
 	return p.opts.ParamStore.Get(p.wrap(key))
 }
 
@@ -60,10 +73,12 @@ func (p *paramStoreProxy) wrap(key string) string {
 
 func createTopicCore(prefix string, provider string, opts *Options) (core zapcore.Core, closer func(), err error) {
 	var hijacker injector.CoreHijacker
+	var splitter injector.LevelSplitter
 	var writeSyncer zapcore.WriteSyncer
+	var generator topicURLGenerator
 	var infoURL string
+	var argStore = &paramStoreProxy{opts: opts, entry: opts.ParamEntry, prefix: prefix}
 	{
-		var generator topicURLGenerator
 		// todo migrate to generic array filter
 		for _, _generator := range opts.topicHandlers {
 			if strings.EqualFold(_generator.Provider(), provider) {
@@ -73,9 +88,7 @@ func createTopicCore(prefix string, provider string, opts *Options) (core zapcor
 		}
 		if generator == nil {
 			return nil, nil, fmt.Errorf("undefined topic provider `%s`", provider)
-		} else if infoURL, err = generator.Generate(
-			(&paramStoreProxy{opts: opts, entry: opts.ParamEntry, prefix: prefix}).Get,
-		); err != nil {
+		} else if infoURL, err = generator.Generate(argStore.Get); err != nil {
 			return nil, nil, err
 		}
 	}
@@ -86,19 +99,71 @@ func createTopicCore(prefix string, provider string, opts *Options) (core zapcor
 				"cant init logger Topic writeSyncer(prefix: %s, provider: %s): %w", prefix, provider, err,
 			)
 		}
+		if err = injectSink(_syncers[0], infoURL, generator, argStore, opts); err != nil {
+			closer()
+			return nil, nil, fmt.Errorf(
+				"cant init logger Topic writeSyncer(prefix: %s, provider: %s): %w", prefix, provider, err,
+			)
+		}
 		hijacker, _ = (_syncers[0]).(injector.CoreHijacker)
+		splitter, _ = (_syncers[0]).(injector.LevelSplitter)
 		writeSyncer = zap.CombineWriteSyncers(_syncers...)
 	}
 	if hijacker != nil {
 		core = hijacker.HijackCore()
 	} else {
-		core = zapcore.NewCore(readableEncoder(false), writeSyncer,
+		var newEncoder func() zapcore.Encoder
+		if newEncoder, err = topicEncoder(argStore); err != nil {
+			if closer != nil {
+				closer()
+			}
+			return nil, nil, err
+		}
+		core = zapcore.NewCore(newEncoder(), writeSyncer,
 			zap.LevelEnablerFunc(func(lvl zapcore.Level) bool { return true }),
 		)
+		if splitter != nil {
+			var splitEnabler, splitSyncer = splitter.SplitLevel()
+			core = zapcore.NewTee(core, zapcore.NewCore(newEncoder(), splitSyncer, splitEnabler))
+		}
 	}
 	return core, closer, nil
 }
 
+// injectSink hands the sink what cant be passed through its url.
+func injectSink(sink zapcore.WriteSyncer, sinkURL string, generator topicURLGenerator,
+	argStore *paramStoreProxy, opts *Options,
+) error {
+	if acceptor, ok := sink.(injector.ReporterAcceptor); ok && opts.reporter != nil {
+		var schema = sinkURL
+		if parsed, err := url.Parse(sinkURL); err == nil {
+			schema = parsed.Scheme
+		}
+		acceptor.AcceptReporter(zap.New(zapcore.NewSamplerWithOptions(opts.reporter, time.Second, 1, 0)).Named(schema))
+	}
+	if acceptor, ok := sink.(injector.EncoderAcceptor); ok {
+		acceptor.AcceptEncoder(jsonEncoder)
+	}
+	if acceptor, ok := sink.(injector.ConfigAcceptor); ok {
+		return acceptor.AcceptConfig(generator, argStore.Get)
+	}
+	return nil
+}
+
+// topicEncoder selects the encoder of topics writing through a plain WriteSyncer,
+// hijacked cores encode entries themselves and may use `Encoding` for their own purpose.
+func topicEncoder(argStore *paramStoreProxy) (func() zapcore.Encoder, error) {
+	var encoding, _ = argStore.Get(ZapTopicConfigEncoding)
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", ZapTopicEncodingConsole:
+		return func() zapcore.Encoder { return readableEncoder(false) }, nil
+	case ZapTopicEncodingJSON:
+		return jsonEncoder, nil
+	default:
+		return nil, fmt.Errorf("unsupported topic encoding `%s` (%s)", encoding, argStore.wrap(ZapTopicConfigEncoding))
+	}
+}
+
 func topicCoreFactory(opts *Options) (cores []zapcore.Core, closers []func(), err error) {
 	var argStore = &paramStoreProxy{opts: opts, entry: opts.ParamEntry, prefix: ""}
 	if opts.ParamStore == nil {
@@ -0,0 +1,9 @@
+package injector
+
+// ConfigAcceptor is implemented by sinks which need values that cant be encoded
+// into the sink url, like secrets or go objects. Right after the sink is opened,
+// the logger passes the topic url generator which built the url, and the topic
+// config it was built from; an error closes the sink and fails the logger.
+type ConfigAcceptor interface {
+	AcceptConfig(generator interface{}, argStore func(string) (string, bool)) error
+}
@@ -0,0 +1,9 @@
+package injector
+
+import "go.uber.org/zap/zapcore"
+
+// EncoderAcceptor is implemented by hijacked sinks which encode entries as json,
+// newEncoder creates the json encoder the logger uses for its own output.
+type EncoderAcceptor interface {
+	AcceptEncoder(newEncoder func() zapcore.Encoder)
+}
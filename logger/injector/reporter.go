@@ -0,0 +1,10 @@
+package injector
+
+import "go.uber.org/zap"
+
+// ReporterAcceptor is implemented by sinks which report their own failures,
+// e.g. deliveries dropped in background. The reporter writes to the console
+// cores, is named after the sink schema, and keeps one entry per message per second.
+type ReporterAcceptor interface {
+	AcceptReporter(reporter *zap.Logger)
+}
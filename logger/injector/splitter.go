@@ -0,0 +1,7 @@
+package injector
+
+import "go.uber.org/zap/zapcore"
+
+type LevelSplitter interface {
+	SplitLevel() (zapcore.LevelEnabler, zapcore.WriteSyncer)
+}
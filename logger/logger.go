@@ -60,6 +60,7 @@ func New(opts Options) (logger log.Logger, sync func(), err error) {
 		} else {
 			cores = append(cores, consoleCores...)
 			syncers = append(syncers, consoleClosers...)
+			opts.reporter = zapcore.NewTee(consoleCores...)
 		}
 	}
 	{ // topic logger
@@ -70,20 +71,26 @@ func New(opts Options) (logger log.Logger, sync func(), err error) {
 			syncers = append(syncers, topicClosers...)
 		}
 	}
-	var _logger = zap.New(
-		zapcore.NewTee(cores...),
+	var _logger = newZapLogger(zapcore.NewTee(cores...), syncers)
+	return _logger, _logger.syncer, nil
+}
+
+// newZapLogger wraps core with the options shared by New and NewTest,
+// closers run after the logger is synced.
+func newZapLogger(core zapcore.Core, closers []func(), opts ...zap.Option) *zapLogger {
+	var _logger = zap.New(core, append([]zap.Option{
 		zap.AddCaller(),
 		zap.AddStacktrace(
 			zap.LevelEnablerFunc(func(lvl zapcore.Level) bool { return lvl >= zapcore.DPanicLevel }),
 		),
-	)
+	}, opts...)...)
 	var syncer = func() {
 		_ = _logger.Sync()
-		for _, closer := range syncers {
+		for _, closer := range closers {
 			if closer != nil {
 				closer()
 			}
 		}
 	}
-	return &zapLogger{SugaredLogger: _logger.Sugar(), underlying: _logger, syncer: syncer}, syncer, nil
+	return &zapLogger{SugaredLogger: _logger.Sugar(), underlying: _logger, syncer: syncer}
 }
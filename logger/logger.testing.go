@@ -0,0 +1,143 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lipence/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// testEncoderConfig renders entries readable in test output, like the console logger without colors.
+var testEncoderConfig = zapcore.EncoderConfig{
+	TimeKey:        "T",
+	LevelKey:       "L",
+	NameKey:        "N",
+	CallerKey:      "C",
+	MessageKey:     "M",
+	StacktraceKey:  "S",
+	LineEnding:     zapcore.DefaultLineEnding,
+	EncodeLevel:    zapcore.CapitalLevelEncoder,
+	EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+	EncodeDuration: zapcore.StringDurationEncoder,
+	EncodeCaller:   zapcore.ShortCallerEncoder,
+}
+
+type testOptions struct {
+	level  zapcore.LevelEnabler
+	silent bool
+}
+
+type TestOption func(o *testOptions)
+
+// TestLevel sets the minimum level observed and logged, DebugLevel by default.
+func TestLevel(level zapcore.LevelEnabler) TestOption {
+	return func(o *testOptions) {
+		o.level = level
+	}
+}
+
+// TestSilent keeps entries in TestLogs only, without passing them to testing.TB.Log.
+func TestSilent() TestOption {
+	return func(o *testOptions) {
+		o.silent = true
+	}
+}
+
+// testWriter passes encoded entries to testing.TB.Log.
+type testWriter struct {
+	t testing.TB
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(string(bytes.TrimSuffix(p, []byte(zapcore.DefaultLineEnding))))
+	return len(p), nil
+}
+
+func (w testWriter) Sync() error {
+	return nil
+}
+
+// TestLogs are the entries observed by a logger from NewTest,
+// filters return a new TestLogs and can be chained.
+type TestLogs struct {
+	*observer.ObservedLogs
+}
+
+func (l *TestLogs) filter(keep func(e observer.LoggedEntry) bool) *TestLogs {
+	return &TestLogs{ObservedLogs: l.ObservedLogs.Filter(keep)}
+}
+
+// FilterLevel keeps entries enabled by level.
+func (l *TestLogs) FilterLevel(level zapcore.LevelEnabler) *TestLogs {
+	return l.filter(func(e observer.LoggedEntry) bool { return level.Enabled(e.Level) })
+}
+
+func (l *TestLogs) FilterLevelExact(level zapcore.Level) *TestLogs {
+	return l.filter(func(e observer.LoggedEntry) bool { return e.Level == level })
+}
+
+func (l *TestLogs) FilterMessage(msg string) *TestLogs {
+	return l.filter(func(e observer.LoggedEntry) bool { return e.Message == msg })
+}
+
+func (l *TestLogs) FilterMessageSnippet(snippet string) *TestLogs {
+	return l.filter(func(e observer.LoggedEntry) bool { return strings.Contains(e.Message, snippet) })
+}
+
+// FilterLogger keeps entries of the named logger and its children.
+func (l *TestLogs) FilterLogger(name string) *TestLogs {
+	return l.filter(func(e observer.LoggedEntry) bool {
+		return e.LoggerName == name || strings.HasPrefix(e.LoggerName, name+".")
+	})
+}
+
+// FilterField keeps entries having field key, which prints as value,
+// so that `With("n", 1)` matches both 1 and "1".
+func (l *TestLogs) FilterField(key string, value interface{}) *TestLogs {
+	var expect = fmt.Sprint(value)
+	return l.filter(func(e observer.LoggedEntry) bool {
+		var field, exist = e.ContextMap()[key]
+		return exist && fmt.Sprint(field) == expect
+	})
+}
+
+func (l *TestLogs) FilterFieldKey(key string) *TestLogs {
+	return l.filter(func(e observer.LoggedEntry) bool {
+		var _, exist = e.ContextMap()[key]
+		return exist
+	})
+}
+
+// Messages returns the message of every entry, in logging order.
+func (l *TestLogs) Messages() []string {
+	var entries = l.All()
+	var messages = make([]string, 0, len(entries))
+	for _, e := range entries {
+		messages = append(messages, e.Message)
+	}
+	return messages
+}
+
+// NewTest creates a logger for unit tests, it wraps zap the same way as New,
+// but entries go to t.Log and to the returned TestLogs instead of stdout/stderr,
+// the logger is synced when the test finishes.
+func NewTest(t testing.TB, opts ...TestOption) (log.Logger, *TestLogs) {
+	var options = testOptions{level: zapcore.DebugLevel}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	var observerCore, observed = observer.New(options.level)
+	var core = observerCore
+	var writer = testWriter{t: t}
+	if !options.silent {
+		core = zapcore.NewTee(core, zapcore.NewCore(zapcore.NewConsoleEncoder(testEncoderConfig), writer, options.level))
+	}
+	var _logger = newZapLogger(core, nil, zap.ErrorOutput(writer))
+	t.Cleanup(_logger.syncer)
+	return _logger, &TestLogs{ObservedLogs: observed}
+}
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// recorder catches the lines a logger from NewTest passes to t.Log.
+type recorder struct {
+	testing.TB
+	lines []string
+}
+
+func (r *recorder) Log(args ...interface{}) {
+	r.lines = append(r.lines, fmt.Sprint(args...))
+}
+
+func TestNewTestFilters(t *testing.T) {
+	var log, logs = NewTest(t, TestSilent())
+	log.With("n", 1).Debug("starting")
+	log.WithName("db").With("n", "1", "table", "users").Info("query done")
+	log.WithName("db").WithName("pool").With("size", 8).Warn("pool exhausted")
+	log.WithName("dbx").With("table", "orders").Error("query failed")
+
+	for name, c := range map[string]struct {
+		logs *TestLogs
+		want []string
+	}{
+		"level":        {logs.FilterLevel(zapcore.WarnLevel), []string{"pool exhausted", "query failed"}},
+		"levelExact":   {logs.FilterLevelExact(zapcore.InfoLevel), []string{"query done"}},
+		"message":      {logs.FilterMessage("query done"), []string{"query done"}},
+		"snippet":      {logs.FilterMessageSnippet("query"), []string{"query done", "query failed"}},
+		"logger":       {logs.FilterLogger("db"), []string{"query done", "pool exhausted"}},
+		"field":        {logs.FilterField("n", 1), []string{"starting", "query done"}},
+		"fieldKey":     {logs.FilterFieldKey("table"), []string{"query done", "query failed"}},
+		"chained":      {logs.FilterLogger("db").FilterFieldKey("table").FilterLevel(zapcore.InfoLevel), []string{"query done"}},
+		"unmatched":    {logs.FilterMessage("missing"), []string{}},
+		"fieldUnmatch": {logs.FilterField("table", "accounts"), []string{}},
+	} {
+		if got := c.logs.Messages(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %q, want %q", name, got, c.want)
+		}
+	}
+	if logs.Len() != 4 {
+		t.Errorf("filters changed the observed entries: %d", logs.Len())
+	}
+}
+
+func TestNewTestLevel(t *testing.T) {
+	var log, logs = NewTest(t, TestSilent(), TestLevel(zapcore.InfoLevel))
+	log.Debug("dropped")
+	log.Info("kept")
+	if got := logs.Messages(); len(got) != 1 || got[0] != "kept" {
+		t.Errorf("got %q, want only kept", got)
+	}
+}
+
+func TestNewTestOutput(t *testing.T) {
+	var r = &recorder{TB: t}
+	var log, logs = NewTest(r)
+	log.With("k", "v").Warn("printed")
+	if len(r.lines) != 1 || !strings.Contains(r.lines[0], "WARN") || !strings.Contains(r.lines[0], "printed") ||
+		!strings.Contains(r.lines[0], `{"k": "v"}`) {
+		t.Errorf("unexpected output %q", r.lines)
+	}
+	var entries = logs.All()
+	if len(entries) != 1 || !entries[0].Caller.Defined || filepath.Base(entries[0].Caller.File) != "logger.testing_test.go" {
+		t.Errorf("unexpected caller of %v", entries)
+	}
+
+	var silent = &recorder{TB: t}
+	log, _ = NewTest(silent, TestSilent())
+	log.Info("not printed")
+	if len(silent.lines) != 0 {
+		t.Errorf("silent logger printed %q", silent.lines)
+	}
+}
@@ -1,6 +1,10 @@
 package logger
 
-import "fmt"
+import (
+	"fmt"
+
+	"go.uber.org/zap/zapcore"
+)
 
 type Mode uint8
 
@@ -34,6 +38,7 @@ type Options struct {
 	ParamStore    paramStore
 	EncJSONOnProd bool
 	topicHandlers []topicURLGenerator
+	reporter      zapcore.Core
 }
 
 func (o *Options) SelfCheck() error {
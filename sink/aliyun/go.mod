@@ -3,7 +3,9 @@ module github.com/lipence/log-zap/sink/aliyun
 go 1.17
 
 require (
-	github.com/aliyun/aliyun-log-go-sdk v0.1.27
+	github.com/aliyun/aliyun-log-go-sdk v0.1.75
+	github.com/klauspost/compress v1.17.8
+	github.com/pierrec/lz4 v2.6.0+incompatible
 	go.uber.org/zap v1.21.0
 )
 
@@ -11,9 +13,8 @@ require (
 	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
 	github.com/go-kit/kit v0.10.0 // indirect
 	github.com/go-logfmt/logfmt v0.5.0 // indirect
-	github.com/gogo/protobuf v1.3.1 // indirect
+	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/protobuf v1.4.2 // indirect
-	github.com/pierrec/lz4 v2.6.0+incompatible // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
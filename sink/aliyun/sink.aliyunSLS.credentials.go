@@ -0,0 +1,246 @@
+package sink_aliyun
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+)
+
+const (
+	AliyunSLSConfigCredentials     = "Credentials"
+	AliyunSLSConfigCredentialsFile = "CredentialsFile"
+	AliyunSLSCredentialsStatic     = "static"
+	AliyunSLSCredentialsEnv        = "env"
+	AliyunSLSCredentialsFile       = "file"
+	AliyunSLSParamCredentials      = "credentials"
+	AliyunSLSParamCredentialsFile  = "credentialsFile"
+	// aliyunSLSCredentialsAccepted marks credentials which are passed through AcceptConfig only.
+	aliyunSLSCredentialsAccepted = "accepted"
+)
+
+const (
+	EnvAccessKeyID     = "ALIBABA_CLOUD_ACCESS_KEY_ID"
+	EnvAccessKeySecret = "ALIBABA_CLOUD_ACCESS_KEY_SECRET" // #nosec G101
+	EnvSecurityToken   = "ALIBABA_CLOUD_SECURITY_TOKEN"    // #nosec G101
+)
+
+type Credentials struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	SecurityToken   string
+	// Expiration is zero for credentials which never expire.
+	Expiration time.Time
+}
+
+// CredentialsProvider is called when the sink starts, and again before the
+// returned credentials expire, so rotated secrets are picked up without
+// rebuilding the logger.
+type CredentialsProvider interface {
+	Credentials() (Credentials, error)
+}
+
+type StaticCredentials Credentials
+
+func (c StaticCredentials) Credentials() (Credentials, error) {
+	if c.AccessKeyID == "" || c.AccessKeySecret == "" {
+		return Credentials{}, fmt.Errorf("empty static credentials")
+	}
+	return Credentials(c), nil
+}
+
+// EnvCredentials reads credentials from ALIBABA_CLOUD_* environment variables.
+type EnvCredentials struct{}
+
+func (EnvCredentials) Credentials() (Credentials, error) {
+	var c = Credentials{
+		AccessKeyID:     os.Getenv(EnvAccessKeyID),
+		AccessKeySecret: os.Getenv(EnvAccessKeySecret),
+		SecurityToken:   os.Getenv(EnvSecurityToken),
+	}
+	if c.AccessKeyID == "" || c.AccessKeySecret == "" {
+		return Credentials{}, fmt.Errorf("undefined environment variable `%s` or `%s`",
+			EnvAccessKeyID, EnvAccessKeySecret)
+	}
+	return c, nil
+}
+
+// FileCredentials reads a json file in the format of the ECS RAM role metadata
+// (AccessKeyId, AccessKeySecret, SecurityToken, Expiration), on every call.
+type FileCredentials string
+
+func (path FileCredentials) Credentials() (Credentials, error) {
+	var content struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		AccessKeySecret string `json:"AccessKeySecret"`
+		SecurityToken   string `json:"SecurityToken"`
+		Expiration      string `json:"Expiration"`
+	}
+	data, err := os.ReadFile(string(path))
+	if err != nil {
+		return Credentials{}, fmt.Errorf("cant read credentials file: %w", err)
+	}
+	if err = json.Unmarshal(data, &content); err != nil {
+		return Credentials{}, fmt.Errorf("cant parse credentials file: %w", err)
+	}
+	var c = Credentials{
+		AccessKeyID:     content.AccessKeyID,
+		AccessKeySecret: content.AccessKeySecret,
+		SecurityToken:   content.SecurityToken,
+	}
+	if content.Expiration != "" {
+		if c.Expiration, err = time.Parse(time.RFC3339, content.Expiration); err != nil {
+			return Credentials{}, fmt.Errorf("cant parse credentials expiration: %w", err)
+		}
+	}
+	if c.AccessKeyID == "" || c.AccessKeySecret == "" {
+		return Credentials{}, fmt.Errorf("incomplete credentials file `%s`", string(path))
+	}
+	return c, nil
+}
+
+// STSCredentials fetches temporary credentials, e.g. by assuming a RAM role,
+// it is called again before the previous token expires.
+type STSCredentials func() (Credentials, error)
+
+func (f STSCredentials) Credentials() (Credentials, error) {
+	return f()
+}
+
+// CredentialsChain returns the credentials of the first provider which succeeds.
+type CredentialsChain []CredentialsProvider
+
+func (chain CredentialsChain) Credentials() (Credentials, error) {
+	var errs []string
+	for _, provider := range chain {
+		if c, err := provider.Credentials(); err == nil {
+			return c, nil
+		} else {
+			errs = append(errs, err.Error())
+		}
+	}
+	return Credentials{}, fmt.Errorf("no credentials available: %s", strings.Join(errs, "; "))
+}
+
+// generateCredentials resolves the credential provider configured for a topic,
+// the static keys are read here so they never appear in the sink url.
+func generateCredentials(argStore func(string) (string, bool)) (CredentialsProvider, error) {
+	var kind, ok = argStore(AliyunSLSConfigCredentials)
+	if !ok {
+		kind = AliyunSLSCredentialsStatic
+	}
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case AliyunSLSCredentialsStatic:
+		var c StaticCredentials
+		if c.AccessKeyID, ok = argStore(AliyunSLSConfigAccessKeyID); !ok {
+			return nil, fmt.Errorf("`AccessKeyID` not optional")
+		}
+		if c.AccessKeySecret, ok = argStore(AliyunSLSConfigAccessKeySecret); !ok {
+			return nil, fmt.Errorf("`AccessKeySecret` not optional")
+		}
+		return c, nil
+	case AliyunSLSCredentialsEnv:
+		return EnvCredentials{}, nil
+	case AliyunSLSCredentialsFile:
+		var path string
+		if path, ok = argStore(AliyunSLSConfigCredentialsFile); !ok || path == "" {
+			return nil, fmt.Errorf("`CredentialsFile` not optional")
+		}
+		return FileCredentials(path), nil
+	default:
+		return nil, fmt.Errorf("unsupported `%s`: %s", AliyunSLSConfigCredentials, kind)
+	}
+}
+
+// generateCredentialsParams puts where the sink finds its credentials into the url,
+// so it starts when opened without logger; static keys and custom providers are
+// marked as accepted, they are only passed by logger through AcceptConfig.
+func generateCredentialsParams(provider CredentialsProvider, argStore func(string) (string, bool),
+	outputQuery url.Values,
+) (err error) {
+	if provider == nil {
+		if provider, err = generateCredentials(argStore); err != nil {
+			return err
+		}
+	}
+	switch p := provider.(type) {
+	case EnvCredentials:
+		outputQuery.Set(AliyunSLSParamCredentials, AliyunSLSCredentialsEnv)
+	case FileCredentials:
+		outputQuery.Set(AliyunSLSParamCredentials, AliyunSLSCredentialsFile)
+		outputQuery.Set(AliyunSLSParamCredentialsFile, string(p))
+	default:
+		outputQuery.Set(AliyunSLSParamCredentials, aliyunSLSCredentialsAccepted)
+	}
+	return nil
+}
+
+// parseCredentialsParams returns the provider named by the sink url, or nil
+// for credentials accepted from logger.
+func parseCredentialsParams(urlQuery url.Values) (CredentialsProvider, error) {
+	switch kind := urlQuery.Get(AliyunSLSParamCredentials); kind {
+	case "":
+		return nil, fmt.Errorf("undefined arg `%s`", AliyunSLSParamCredentials)
+	case aliyunSLSCredentialsAccepted:
+		return nil, nil
+	case AliyunSLSCredentialsEnv:
+		return EnvCredentials{}, nil
+	case AliyunSLSCredentialsFile:
+		if path := urlQuery.Get(AliyunSLSParamCredentialsFile); path != "" {
+			return FileCredentials(path), nil
+		}
+		return nil, fmt.Errorf("undefined arg `%s`", AliyunSLSParamCredentialsFile)
+	default:
+		return nil, fmt.Errorf("unsupported arg `%s`: %s", AliyunSLSParamCredentials, kind)
+	}
+}
+
+// credentialsRefreshAdvance is how long before their expiration credentials are fetched again.
+const credentialsRefreshAdvance = 5 * time.Minute
+
+// refreshingCredentials hands the producer the credentials last fetched from
+// provider, and fetches new ones once they are about to expire. A failed fetch
+// keeps the previous credentials until they expire.
+type refreshingCredentials struct {
+	mu       sync.Mutex
+	provider CredentialsProvider
+	current  Credentials
+}
+
+func (r *refreshingCredentials) GetCredentials() (sls.Credentials, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.current.Expiration.IsZero() && time.Until(r.current.Expiration) <= credentialsRefreshAdvance {
+		if next, err := r.provider.Credentials(); err == nil {
+			r.current = next
+		} else if !time.Now().Before(r.current.Expiration) {
+			return sls.Credentials{}, fmt.Errorf("cant refresh aliyun-sls credentials: %w", err)
+		}
+	}
+	return sls.Credentials{
+		AccessKeyID:     r.current.AccessKeyID,
+		AccessKeySecret: r.current.AccessKeySecret,
+		SecurityToken:   r.current.SecurityToken,
+	}, nil
+}
+
+// applyCredentials fetches the credentials once, those without expiration are
+// used as they are, the others are fetched again by the producer before they expire.
+func applyCredentials(provider CredentialsProvider, cfg *producer.ProducerConfig) error {
+	c, err := provider.Credentials()
+	if err != nil {
+		return fmt.Errorf("cant load aliyun-sls credentials: %w", err)
+	}
+	if c.Expiration.IsZero() {
+		cfg.CredentialsProvider = sls.NewStaticCredentialsProvider(c.AccessKeyID, c.AccessKeySecret, c.SecurityToken)
+	} else {
+		cfg.CredentialsProvider = &refreshingCredentials{provider: provider, current: c}
+	}
+	return nil
+}
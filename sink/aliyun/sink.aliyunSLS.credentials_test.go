@@ -0,0 +1,95 @@
+package sink_aliyun
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+)
+
+// countedCredentials returns the credentials produced by next and counts the calls.
+type countedCredentials struct {
+	calls int
+	next  func(call int) (Credentials, error)
+}
+
+func (c *countedCredentials) Credentials() (Credentials, error) {
+	c.calls++
+	return c.next(c.calls)
+}
+
+func TestApplyCredentialsFetchesStaticOnce(t *testing.T) {
+	var provider = &countedCredentials{next: func(int) (Credentials, error) {
+		return Credentials{AccessKeyID: "ak", AccessKeySecret: "sk", SecurityToken: "token"}, nil
+	}}
+	var cfg = producer.GetDefaultProducerConfig()
+	if err := applyCredentials(provider, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if c, err := cfg.CredentialsProvider.GetCredentials(); err != nil || c.AccessKeyID != "ak" ||
+			c.AccessKeySecret != "sk" || c.SecurityToken != "token" {
+			t.Errorf("got credentials %+v, %v", c, err)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("credentials without expiration fetched %d times", provider.calls)
+	}
+	if cfg.UpdateStsToken != nil || cfg.StsTokenShutDown != nil {
+		t.Error("deprecated sts token refresh configured")
+	}
+}
+
+func TestApplyCredentialsRefreshes(t *testing.T) {
+	var expiration = time.Now().Add(time.Minute)
+	var provider = &countedCredentials{next: func(call int) (Credentials, error) {
+		switch call {
+		case 1:
+			return Credentials{AccessKeyID: "ak1", AccessKeySecret: "sk1", Expiration: expiration}, nil
+		case 2:
+			return Credentials{}, fmt.Errorf("sts unavailable")
+		default:
+			return Credentials{AccessKeyID: "ak3", AccessKeySecret: "sk3", Expiration: time.Now().Add(time.Hour)}, nil
+		}
+	}}
+	var cfg = producer.GetDefaultProducerConfig()
+	if err := applyCredentials(provider, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if provider.calls != 1 {
+		t.Fatalf("credentials fetched %d times on start", provider.calls)
+	}
+	// about to expire, the failed fetch keeps the previous credentials
+	if c, err := cfg.CredentialsProvider.GetCredentials(); err != nil || c.AccessKeyID != "ak1" {
+		t.Errorf("got credentials %+v, %v after a failed refresh, want ak1", c, err)
+	}
+	for i := 0; i < 2; i++ {
+		if c, err := cfg.CredentialsProvider.GetCredentials(); err != nil || c.AccessKeyID != "ak3" {
+			t.Errorf("got credentials %+v, %v, want ak3", c, err)
+		}
+	}
+	if provider.calls != 3 {
+		t.Errorf("credentials fetched %d times, want 3", provider.calls)
+	}
+
+	var expired = &countedCredentials{next: func(call int) (Credentials, error) {
+		if call == 1 {
+			return Credentials{AccessKeyID: "ak", AccessKeySecret: "sk", Expiration: time.Now().Add(-time.Second)}, nil
+		}
+		return Credentials{}, fmt.Errorf("sts unavailable")
+	}}
+	if err := applyCredentials(expired, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.CredentialsProvider.GetCredentials(); err == nil {
+		t.Error("expired credentials returned after a failed refresh")
+	}
+}
+
+func TestApplyCredentialsFails(t *testing.T) {
+	var cfg = producer.GetDefaultProducerConfig()
+	if err := applyCredentials(StaticCredentials{}, cfg); err == nil || cfg.CredentialsProvider != nil {
+		t.Errorf("empty credentials applied: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package sink_aliyun
+
+import (
+	"sync/atomic"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"go.uber.org/zap"
+)
+
+// Metrics counts the delivery results reported by the producer.
+type Metrics struct {
+	successes uint64
+	failures  uint64
+	retries   uint64
+}
+
+func (m *Metrics) Successes() uint64 {
+	return atomic.LoadUint64(&m.successes)
+}
+
+func (m *Metrics) Failures() uint64 {
+	return atomic.LoadUint64(&m.failures)
+}
+
+func (m *Metrics) Retries() uint64 {
+	return atomic.LoadUint64(&m.retries)
+}
+
+func (m *Metrics) observe(result *producer.Result) {
+	if attempts := len(result.GetReservedAttempts()); attempts > 1 {
+		atomic.AddUint64(&m.retries, uint64(attempts-1))
+	}
+	if result.IsSuccessful() {
+		atomic.AddUint64(&m.successes, 1)
+	} else {
+		atomic.AddUint64(&m.failures, 1)
+	}
+}
+
+// sinkHooks carries the values which cant be encoded into the sink url,
+// received from urlGenerator by aliyunSLSSink.AcceptConfig.
+type sinkHooks struct {
+	callback producer.CallBack
+	metrics  *Metrics
+}
+
+// deliveryCallback records the result of each log sent by the producer,
+// and reports failures through the reporter injected by logger.
+type deliveryCallback struct {
+	sink  *aliyunSLSSink
+	log   *sls.Log
+	topic string
+}
+
+// spool keeps the log on disk for replay, when the sink has a spool.
+func (c deliveryCallback) spool() bool {
+	if c.sink.spool == nil {
+		return false
+	}
+	return c.sink.spool.append(c.topic, c.sink.source, c.log) == nil
+}
+
+func (c deliveryCallback) Success(result *producer.Result) {
+	if c.sink.spool != nil {
+		c.sink.spool.markHealthy(true)
+	}
+	c.sink.hooks.metrics.observe(result)
+	if c.sink.hooks.callback != nil {
+		c.sink.hooks.callback.Success(result)
+	}
+}
+
+func (c deliveryCallback) Fail(result *producer.Result) {
+	if c.sink.spool != nil {
+		c.sink.spool.markHealthy(false)
+	}
+	c.sink.hooks.metrics.observe(result)
+	if c.sink.hooks.callback != nil {
+		c.sink.hooks.callback.Fail(result)
+	}
+	if reporter, ok := c.sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error("aliyun-sls delivery failed",
+			zap.String("project", c.sink.project),
+			zap.String("logStore", c.sink.logStore),
+			zap.String("errorCode", result.GetErrorCode()),
+			zap.String("errorMessage", result.GetErrorMessage()),
+			zap.String("requestId", result.GetRequestId()),
+			zap.Bool("spooled", c.spool()),
+		)
+	} else {
+		c.spool()
+	}
+}
+
+func (sink *aliyunSLSSink) AcceptReporter(reporter *zap.Logger) {
+	sink.reporter.Store(reporter)
+}
@@ -0,0 +1,129 @@
+package sink_aliyun
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	AliyunSLSNestedJSON    = "json"
+	AliyunSLSNestedFlatten = "flatten"
+	defaultFlattenSep      = "."
+)
+
+// fieldEncoder turns the values collected by zapcore.MapObjectEncoder into
+// SLS contents, formatting scalars the same way as the json encoder of logger.
+type fieldEncoder struct {
+	flatten   bool
+	separator string
+}
+
+func newFieldEncoder(strategy string, separator string) (fieldEncoder, error) {
+	var enc = fieldEncoder{separator: separator}
+	switch strategy {
+	case "", AliyunSLSNestedJSON:
+	case AliyunSLSNestedFlatten:
+		enc.flatten = true
+	default:
+		return enc, fmt.Errorf("unsupported nested field strategy `%s`", strategy)
+	}
+	if enc.separator == "" {
+		enc.separator = defaultFlattenSep
+	}
+	return enc, nil
+}
+
+func (enc fieldEncoder) encode(fields map[string]interface{}, data map[string]string) {
+	for key, val := range fields {
+		enc.put(data, key, val)
+	}
+}
+
+func (enc fieldEncoder) put(data map[string]string, key string, val interface{}) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if enc.flatten {
+			for subKey, subVal := range v {
+				enc.put(data, key+enc.separator+subKey, subVal)
+			}
+			return
+		}
+		data[key] = marshalJSON(v)
+	case []interface{}:
+		data[key] = marshalJSON(v)
+	default:
+		if text, ok := scalar(v); ok {
+			data[key] = text
+		} else {
+			data[key] = marshalJSON(v)
+		}
+	}
+}
+
+// scalar formats primitive values, reports false for values that need json encoding.
+func scalar(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case nil:
+		return "null", true
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	case time.Duration:
+		return strconv.FormatFloat(float64(v)/float64(time.Second), 'f', -1, 64), true
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), true
+	case fmt.Stringer:
+		return v.String(), true
+	}
+	switch reflect.ValueOf(val).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Complex64, reflect.Complex128:
+		return fmt.Sprint(val), true
+	default:
+		return "", false
+	}
+}
+
+// normalize rewrites times, durations and byte slices nested in objects and arrays,
+// so they are encoded as the top level ones.
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		var out = make(map[string]interface{}, len(v))
+		for key, subVal := range v {
+			out[key] = normalize(subVal)
+		}
+		return out
+	case []interface{}:
+		var out = make([]interface{}, len(v))
+		for i, subVal := range v {
+			out[i] = normalize(subVal)
+		}
+		return out
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return float64(v) / float64(time.Second)
+	default:
+		return v
+	}
+}
+
+func marshalJSON(val interface{}) string {
+	if data, err := json.Marshal(normalize(val)); err == nil {
+		return string(data)
+	}
+	return fmt.Sprint(val)
+}
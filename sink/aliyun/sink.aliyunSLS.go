@@ -3,6 +3,11 @@ package sink_aliyun
 import (
 	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	sls "github.com/aliyun/aliyun-log-go-sdk"
 	"github.com/aliyun/aliyun-log-go-sdk/producer"
@@ -16,26 +21,48 @@ const (
 	AliyunSLSParamLogStore         = "logStore"
 	AliyunSLSParamSchema           = "schema"
 	AliyunSLSParamSource           = "source"
+	AliyunSLSParamNestedFields     = "nestedFields"
+	AliyunSLSParamFlattenSep       = "flattenSep"
+	AliyunSLSParamLevel            = "level"
+	AliyunSLSParamShipErrors       = "shipErrors"
 	AliyunSLSConfigProject         = "Project"
 	AliyunSLSConfigLogStore        = "LogStore"
 	AliyunSLSConfigEndpoint        = "Endpoint"
 	AliyunSLSConfigAccessKeyID     = "AccessKeyID"
 	AliyunSLSConfigAccessKeySecret = "AccessKeySecret" // #nosec G101
+	AliyunSLSConfigNestedFields    = "NestedFields"
+	AliyunSLSConfigFlattenSep      = "FlattenSeparator"
+	AliyunSLSConfigLevel           = "Level"
+	AliyunSLSConfigShipErrors      = "AlwaysShipErrors"
 )
 
+// aliyunSLSCore keeps the fields added by With pre-encoded, each child owns
+// its context map, which is never modified after With returns.
 type aliyunSLSCore struct {
-	sink   *aliyunSLSSink
-	fields []zapcore.Field
+	sink    *aliyunSLSSink
+	context map[string]string
 }
 
-func (core *aliyunSLSCore) Enabled(zapcore.Level) bool {
-	return true
+func (core *aliyunSLSCore) Enabled(lvl zapcore.Level) bool {
+	return core.sink.enabler.Enabled(lvl)
 }
 
 func (core *aliyunSLSCore) With(f []zapcore.Field) zapcore.Core {
+	if len(f) == 0 {
+		return core
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range f {
+		field.AddTo(enc)
+	}
+	var context = make(map[string]string, len(core.context)+len(enc.Fields))
+	for k, v := range core.context {
+		context[k] = v
+	}
+	core.sink.encoder.encode(enc.Fields, context)
 	return &aliyunSLSCore{
-		sink:   core.sink,
-		fields: append(core.fields, f...),
+		sink:    core.sink,
+		context: context,
 	}
 }
 
@@ -47,40 +74,130 @@ func (core *aliyunSLSCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *z
 }
 
 func (core *aliyunSLSCore) Write(e zapcore.Entry, fields []zapcore.Field) (err error) { // nolint:gocritic
-	fields = append(core.fields, fields...)
-	enc := zapcore.NewMapObjectEncoder()
-	enc.Fields["level"] = e.Level.String()
-	enc.Fields["caller"] = e.Caller.FullPath()
-	enc.Fields["msg"] = e.Message
-	for _, field := range fields {
-		field.AddTo(enc)
+	var data = make(map[string]string, 6+len(core.context)+len(fields))
+	data["level"] = e.Level.String()
+	data["caller"] = e.Caller.FullPath()
+	data["msg"] = e.Message
+	if e.LoggerName != "" {
+		data["logger"] = e.LoggerName
+	}
+	if e.Caller.Defined && e.Caller.Function != "" {
+		data["function"] = e.Caller.Function
 	}
-	var data = make(map[string]string, len(fields))
-	for k, v := range enc.Fields {
-		data[k] = fmt.Sprint(v)
+	if e.Stack != "" {
+		data["stacktrace"] = e.Stack
 	}
-	return core.sink.write(producer.GenerateLog(uint32(e.Time.Unix()), data), e.LoggerName)
+	for k, v := range core.context {
+		data[k] = v
+	}
+	if len(fields) > 0 {
+		enc := zapcore.NewMapObjectEncoder()
+		for _, field := range fields {
+			field.AddTo(enc)
+		}
+		core.sink.encoder.encode(enc.Fields, data)
+	}
+	var topic = core.sink.group.topicOf(data, e.LoggerName)
+	return core.sink.write(withTimeNs(producer.GenerateLog(uint32(e.Time.Unix()), data), e.Time), topic)
 }
 
 func (core *aliyunSLSCore) Sync() error {
 	return nil
 }
 
+// withTimeNs sets the nanosecond part of the log time, GenerateLog only sets the seconds.
+func withTimeNs(l *sls.Log, t time.Time) *sls.Log {
+	var timeNs = uint32(t.Nanosecond())
+	l.TimeNs = &timeNs
+	return l
+}
+
+// parseLevelEnabler accepts either a minimum level (`warn`) or a list of
+// levels (`info,error`), shipErrors enables error and above regardless.
+func parseLevelEnabler(text string, shipErrors bool) (zapcore.LevelEnabler, error) {
+	var enabled [zapcore.FatalLevel - zapcore.DebugLevel + 1]bool
+	if text = strings.TrimSpace(text); text == "" {
+		text = zapcore.DebugLevel.String()
+	}
+	if levels := strings.Split(text, ","); len(levels) > 1 {
+		for _, levelText := range levels {
+			var lvl zapcore.Level
+			if err := lvl.UnmarshalText([]byte(strings.TrimSpace(levelText))); err != nil {
+				return nil, fmt.Errorf("cant parse level `%s`: %w", levelText, err)
+			}
+			enabled[lvl-zapcore.DebugLevel] = true
+		}
+	} else {
+		var minLevel zapcore.Level
+		if err := minLevel.UnmarshalText([]byte(text)); err != nil {
+			return nil, fmt.Errorf("cant parse level `%s`: %w", text, err)
+		}
+		for lvl := minLevel; lvl <= zapcore.FatalLevel; lvl++ {
+			enabled[lvl-zapcore.DebugLevel] = true
+		}
+	}
+	if shipErrors {
+		for lvl := zapcore.ErrorLevel; lvl <= zapcore.FatalLevel; lvl++ {
+			enabled[lvl-zapcore.DebugLevel] = true
+		}
+	}
+	return zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		return lvl >= zapcore.DebugLevel && lvl <= zapcore.FatalLevel && enabled[lvl-zapcore.DebugLevel]
+	}), nil
+}
+
 type aliyunSLSSink struct {
-	source   string
-	project  string
-	logStore string
-	producer *producer.Producer
+	source      string
+	project     string
+	logStore    string
+	group       groupOptions
+	encoder     fieldEncoder
+	enabler     zapcore.LevelEnabler
+	hooks       *sinkHooks
+	spool       *spool
+	reporter    atomic.Value
+	credentials CredentialsProvider
+	config      *producer.ProducerConfig
+	startOnce   sync.Once
+	startErr    error
+	producer    *producer.Producer
 }
 
 func (sink *aliyunSLSSink) HijackCore() zapcore.Core {
 	return &aliyunSLSCore{sink: sink}
 }
+
+// start initializes the producer once, either from AcceptConfig or on the first
+// write of a sink opened without logger, started reports whether this call did it.
+func (sink *aliyunSLSSink) start(credentials CredentialsProvider) (started bool, err error) {
+	sink.startOnce.Do(func() {
+		started = true
+		if credentials == nil {
+			sink.startErr = fmt.Errorf("aliyun-sls producer not started, static credentials are only passed by logger")
+			return
+		}
+		if sink.startErr = applyCredentials(credentials, sink.config); sink.startErr != nil {
+			return
+		}
+		sink.producer = producer.InitProducer(sink.config)
+		if sink.spool != nil {
+			go sink.spool.run(sink.replay, sink.spoolFailed)
+		}
+		sink.producer.Start()
+	})
+	return started, sink.startErr
+}
+
 func (sink *aliyunSLSSink) write(l *sls.Log, topic string) error {
-	if topic == "" {
-		topic = "none"
+	if _, err := sink.start(sink.credentials); err != nil {
+		return err
+	}
+	var callback = deliveryCallback{sink: sink, log: l, topic: topic}
+	if err := sink.producer.SendLogWithCallBack(sink.project, sink.logStore, topic, sink.source, l,
+		callback); err != nil && !callback.spool() {
+		return err
 	}
-	return sink.producer.SendLog(sink.project, sink.logStore, topic, sink.source, l)
+	return nil
 }
 
 func (sink *aliyunSLSSink) Write(_ []byte) (int, error) {
@@ -91,27 +208,105 @@ func (sink *aliyunSLSSink) Sync() error {
 	return nil
 }
 
+// AcceptConfig receives the credentials and callbacks kept out of the sink url,
+// and starts the producer.
+func (sink *aliyunSLSSink) AcceptConfig(generator interface{}, argStore func(string) (string, bool)) (err error) {
+	var credentials = sink.credentials
+	if g, ok := generator.(*urlGenerator); ok {
+		sink.hooks.callback = g.callback
+		if g.metrics != nil {
+			sink.hooks.metrics = g.metrics
+		}
+		if g.credentials != nil {
+			credentials = g.credentials
+		}
+	}
+	if credentials == nil {
+		if credentials, err = generateCredentials(argStore); err != nil {
+			return err
+		}
+	}
+	var started bool
+	if started, err = sink.start(credentials); err == nil && !started {
+		return fmt.Errorf("aliyun-sls producer already started")
+	}
+	return err
+}
+
 func (sink *aliyunSLSSink) Close() error {
+	// a sink closed before its first write never starts
+	sink.startOnce.Do(func() { sink.startErr = fmt.Errorf("aliyun-sls sink closed") })
+	if sink.producer == nil {
+		return nil
+	}
+	if sink.spool == nil {
+		sink.producer.SafeClose()
+		return nil
+	}
+	// pending replays finish once the producer flushes their callbacks,
+	// failed deliveries are still spooled before the active segment is closed.
+	sink.spool.halt()
 	sink.producer.SafeClose()
+	sink.spool.close()
 	return nil
 }
 
 func register(logPath *url.URL) (sink zap.Sink, err error) {
 	producerConfig := producer.GetDefaultProducerConfig()
 	producerConfig.Endpoint = logPath.Host
-	producerConfig.AccessKeyID = logPath.User.Username()
-	producerConfig.AccessKeySecret, _ = logPath.User.Password()
 	var urlQuery = logPath.Query()
 	if schema := urlQuery.Get(AliyunSLSParamSchema); schema != "" {
 		producerConfig.Endpoint = fmt.Sprintf("%s://%s", schema, producerConfig.Endpoint)
 	}
+	if err = applyProducerParams(urlQuery, producerConfig); err != nil {
+		return nil, err
+	}
+	var encoder fieldEncoder
+	if encoder, err = newFieldEncoder(
+		urlQuery.Get(AliyunSLSParamNestedFields), urlQuery.Get(AliyunSLSParamFlattenSep),
+	); err != nil {
+		return nil, err
+	}
+	var enabler zapcore.LevelEnabler
+	if enabler, err = parseLevelEnabler(
+		urlQuery.Get(AliyunSLSParamLevel), urlQuery.Get(AliyunSLSParamShipErrors) == "true",
+	); err != nil {
+		return nil, err
+	}
+	var group groupOptions
+	if group, err = parseGroupOptions(urlQuery); err != nil {
+		return nil, err
+	}
+	producerConfig.LogTags = group.logTags()
+	var spoolDir string
+	var spoolMaxBytes, spoolSegmentBytes int64
+	var spoolInterval time.Duration
+	if spoolDir, spoolMaxBytes, spoolSegmentBytes, spoolInterval, err = parseSpoolParams(urlQuery); err != nil {
+		return nil, err
+	}
+	var credentials CredentialsProvider
+	if credentials, err = parseCredentialsParams(urlQuery); err != nil {
+		return nil, err
+	}
 	var _sink = &aliyunSLSSink{
-		source:   urlQuery.Get(AliyunSLSParamSource),
-		project:  urlQuery.Get(AliyunSLSParamProject),
-		logStore: urlQuery.Get(AliyunSLSParamLogStore),
-		producer: producer.InitProducer(producerConfig),
+		source:      urlQuery.Get(AliyunSLSParamSource),
+		project:     urlQuery.Get(AliyunSLSParamProject),
+		logStore:    urlQuery.Get(AliyunSLSParamLogStore),
+		group:       group,
+		encoder:     encoder,
+		enabler:     enabler,
+		hooks:       &sinkHooks{metrics: &Metrics{}},
+		credentials: credentials,
+		config:      producerConfig,
+	}
+	if spoolDir != "" {
+		if _sink.spool, err = openSpool(spoolDir, spoolMaxBytes, spoolSegmentBytes, spoolInterval); err != nil {
+			return nil, err
+		}
+		// a full producer buffer fails the write at once, which spools the log
+		// instead of blocking the caller while SLS is unreachable
+		producerConfig.MaxBlockSec = 0
 	}
-	_sink.producer.Start()
 	return _sink, nil
 }
 
@@ -122,16 +317,40 @@ func init() {
 }
 
 type urlGenerator struct {
-	topic  string
-	source string
+	topic       string
+	source      string
+	callback    producer.CallBack
+	metrics     *Metrics
+	credentials CredentialsProvider
 }
 
 func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
 	return &urlGenerator{
-		topic: topic,
+		topic:       topic,
+		source:      g.source,
+		callback:    g.callback,
+		metrics:     &Metrics{},
+		credentials: g.credentials,
 	}
 }
 
+// WithCallback sets the callback invoked with the delivery result of each log.
+func (g *urlGenerator) WithCallback(callback producer.CallBack) *urlGenerator {
+	g.callback = callback
+	return g
+}
+
+// WithCredentials overrides the `Credentials` topic config with provider.
+func (g *urlGenerator) WithCredentials(provider CredentialsProvider) *urlGenerator {
+	g.credentials = provider
+	return g
+}
+
+// Metrics returns the delivery counters of sinks generated by g.
+func (g *urlGenerator) Metrics() *Metrics {
+	return g.metrics
+}
+
 func (g *urlGenerator) Provider() string {
 	if g.topic != "" {
 		return g.topic
@@ -157,20 +376,19 @@ func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, e
 	}
 	{
 		var source string
-		if source = g.source; source == "" {
-			return "", fmt.Errorf("`Source` not optional")
+		if source, ok = argStore(AliyunSLSConfigSource); !ok || source == "" {
+			source = g.source
+		}
+		if source == "" {
+			var err error
+			if source, err = defaultSource(); err != nil {
+				return "", err
+			}
 		}
 		outputQuery.Set(AliyunSLSParamSource, source)
 	}
-	{
-		var ak, sk string
-		if ak, ok = argStore(AliyunSLSConfigAccessKeyID); !ok {
-			return "", fmt.Errorf("`AccessKeyID` not optional")
-		}
-		if sk, ok = argStore(AliyunSLSConfigAccessKeySecret); !ok {
-			return "", fmt.Errorf("`AccessKeySecret` not optional")
-		}
-		outputPath.User = url.UserPassword(ak, sk)
+	if err := generateCredentialsParams(g.credentials, argStore, outputQuery); err != nil {
+		return "", err
 	}
 	{
 		var project, logStore string
@@ -184,6 +402,44 @@ func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, e
 		outputQuery.Set(AliyunSLSParamProject, project)
 		outputQuery.Set(AliyunSLSParamLogStore, logStore)
 	}
+	{
+		var nestedFields, flattenSep string
+		if nestedFields, ok = argStore(AliyunSLSConfigNestedFields); ok {
+			nestedFields = strings.ToLower(strings.TrimSpace(nestedFields))
+			outputQuery.Set(AliyunSLSParamNestedFields, nestedFields)
+		}
+		if flattenSep, ok = argStore(AliyunSLSConfigFlattenSep); ok {
+			outputQuery.Set(AliyunSLSParamFlattenSep, flattenSep)
+		}
+		if _, err := newFieldEncoder(nestedFields, flattenSep); err != nil {
+			return "", err
+		}
+	}
+	{
+		var level, shipErrors string
+		if level, ok = argStore(AliyunSLSConfigLevel); ok {
+			outputQuery.Set(AliyunSLSParamLevel, level)
+		}
+		if shipErrors, ok = argStore(AliyunSLSConfigShipErrors); ok && shipErrors != "" {
+			if always, err := strconv.ParseBool(strings.TrimSpace(shipErrors)); err != nil {
+				return "", fmt.Errorf("cant parse `%s`: %w", AliyunSLSConfigShipErrors, err)
+			} else if always {
+				outputQuery.Set(AliyunSLSParamShipErrors, "true")
+			}
+		}
+		if _, err := parseLevelEnabler(level, false); err != nil {
+			return "", err
+		}
+	}
+	if err := generateGroupParams(argStore, outputQuery); err != nil {
+		return "", err
+	}
+	if err := generateSpoolParams(argStore, outputQuery); err != nil {
+		return "", err
+	}
+	if err := generateProducerParams(argStore, outputQuery); err != nil {
+		return "", err
+	}
 	outputPath.RawQuery = outputQuery.Encode()
 	return outputPath.String(), nil
 }
@@ -199,5 +455,5 @@ func (g *urlGenerator) parseEndpoint(endpoint string) (string, string, error) {
 }
 
 func NewURLGenerator(source string) *urlGenerator {
-	return &urlGenerator{source: source}
+	return &urlGenerator{source: source, metrics: &Metrics{}}
 }
@@ -0,0 +1,129 @@
+package sink_aliyun
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+const (
+	AliyunSLSConfigSource     = "Source"
+	AliyunSLSConfigTopic      = "Topic"
+	AliyunSLSConfigTopicField = "TopicField"
+	AliyunSLSConfigLogTags    = "LogTags"
+	AliyunSLSParamTopic       = "topic"
+	AliyunSLSParamTopicField  = "topicField"
+	AliyunSLSParamLogTags     = "logTags"
+	defaultSLSTopic           = "none"
+)
+
+// groupOptions decides the topic, source and tags of the log group each entry goes to.
+type groupOptions struct {
+	topic      string
+	topicField string
+	tags       map[string]string
+}
+
+// topicOf prefers the topic field of the entry, then the static topic, then the logger name.
+func (o groupOptions) topicOf(data map[string]string, loggerName string) string {
+	if o.topicField != "" {
+		if topic := data[o.topicField]; topic != "" {
+			return topic
+		}
+	}
+	if o.topic != "" {
+		return o.topic
+	}
+	if loggerName != "" {
+		return loggerName
+	}
+	return defaultSLSTopic
+}
+
+// logTags converts the static tags into the tags the producer attaches to every log group.
+func (o groupOptions) logTags() []*sls.LogTag {
+	var keys = make([]string, 0, len(o.tags))
+	for key := range o.tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var tags = make([]*sls.LogTag, len(keys))
+	for i, key := range keys {
+		var key, val = key, o.tags[key]
+		tags[i] = &sls.LogTag{Key: &key, Value: &val}
+	}
+	return tags
+}
+
+// parseLogTags parses `key=value` pairs separated by commas.
+func parseLogTags(text string) (map[string]string, error) {
+	var tags = map[string]string{}
+	for _, pair := range strings.Split(text, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		var kv = strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("cant parse log tag `%s`: expect key=value", pair)
+		}
+		tags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return tags, nil
+}
+
+func formatLogTags(tags map[string]string) string {
+	var pairs = make([]string, 0, len(tags))
+	for key, val := range tags {
+		pairs = append(pairs, key+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func parseGroupOptions(urlQuery url.Values) (o groupOptions, err error) {
+	o.topic = urlQuery.Get(AliyunSLSParamTopic)
+	o.topicField = urlQuery.Get(AliyunSLSParamTopicField)
+	if o.tags, err = parseLogTags(urlQuery.Get(AliyunSLSParamLogTags)); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+func generateGroupParams(argStore func(string) (string, bool), outputQuery url.Values) error {
+	if topic, ok := argStore(AliyunSLSConfigTopic); ok && topic != "" {
+		outputQuery.Set(AliyunSLSParamTopic, topic)
+	}
+	if topicField, ok := argStore(AliyunSLSConfigTopicField); ok && topicField != "" {
+		outputQuery.Set(AliyunSLSParamTopicField, topicField)
+	}
+	if logTags, ok := argStore(AliyunSLSConfigLogTags); ok && logTags != "" {
+		tags, err := parseLogTags(logTags)
+		if err != nil {
+			return err
+		}
+		outputQuery.Set(AliyunSLSParamLogTags, formatLogTags(tags))
+	}
+	return nil
+}
+
+// defaultSource returns the hostname, or the first non-loopback ip when hostname is unavailable.
+func defaultSource() (string, error) {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname, nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", fmt.Errorf("cant detect aliyun-sls source: %w", err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			return ipNet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("cant detect aliyun-sls source: no hostname or ip")
+}
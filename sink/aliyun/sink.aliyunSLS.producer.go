@@ -0,0 +1,157 @@
+package sink_aliyun
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+)
+
+const (
+	AliyunSLSConfigLingerMs         = "LingerMs"
+	AliyunSLSConfigMaxBatchSize     = "MaxBatchSize"
+	AliyunSLSConfigMaxBatchCount    = "MaxBatchCount"
+	AliyunSLSConfigTotalSizeLnBytes = "TotalSizeLnBytes"
+	AliyunSLSConfigRetries          = "Retries"
+	AliyunSLSConfigMaxIoWorkerNum   = "MaxIoWorkerNum"
+	AliyunSLSConfigCompressType     = "CompressType"
+	AliyunSLSConfigRegion           = "Region"
+	AliyunSLSConfigSignVersion      = "SignVersion"
+	AliyunSLSParamCompressType      = "compressType"
+	AliyunSLSParamRegion            = "region"
+	AliyunSLSParamSignVersion       = "signVersion"
+)
+
+const (
+	AliyunSLSCompressLZ4  = "lz4"
+	AliyunSLSCompressNone = "none"
+	AliyunSLSCompressZSTD = "zstd"
+	AliyunSLSSignV1       = "v1"
+	AliyunSLSSignV4       = "v4"
+)
+
+var compressTypes = map[string]int{
+	AliyunSLSCompressLZ4:  sls.Compress_LZ4,
+	AliyunSLSCompressNone: sls.Compress_None,
+	AliyunSLSCompressZSTD: sls.Compress_ZSTD,
+}
+
+var signVersions = map[string]sls.AuthVersionType{
+	AliyunSLSSignV1: sls.AuthV1,
+	AliyunSLSSignV4: sls.AuthV4,
+}
+
+// producerTuning maps an integer topic key onto the producer config,
+// bounds follow the corrections made by producer.InitProducer.
+type producerTuning struct {
+	config string
+	param  string
+	min    int64
+	max    int64
+	apply  func(cfg *producer.ProducerConfig, val int64)
+}
+
+var producerTunings = []producerTuning{
+	{
+		config: AliyunSLSConfigLingerMs, param: "lingerMs", min: 100, max: 1<<31 - 1,
+		apply: func(cfg *producer.ProducerConfig, val int64) { cfg.LingerMs = val },
+	},
+	{
+		config: AliyunSLSConfigMaxBatchSize, param: "maxBatchSize", min: 1, max: 5 * 1024 * 1024,
+		apply: func(cfg *producer.ProducerConfig, val int64) { cfg.MaxBatchSize = val },
+	},
+	{
+		config: AliyunSLSConfigMaxBatchCount, param: "maxBatchCount", min: 1, max: 40960,
+		apply: func(cfg *producer.ProducerConfig, val int64) { cfg.MaxBatchCount = int(val) },
+	},
+	{
+		config: AliyunSLSConfigTotalSizeLnBytes, param: "totalSizeLnBytes", min: 1, max: 1<<63 - 1,
+		apply: func(cfg *producer.ProducerConfig, val int64) { cfg.TotalSizeLnBytes = val },
+	},
+	{
+		config: AliyunSLSConfigRetries, param: "retries", min: 0, max: 1<<31 - 1,
+		apply: func(cfg *producer.ProducerConfig, val int64) {
+			cfg.Retries = int(val)
+			if cfg.MaxReservedAttempts <= cfg.Retries {
+				cfg.MaxReservedAttempts = cfg.Retries + 1
+			}
+		},
+	},
+	{
+		config: AliyunSLSConfigMaxIoWorkerNum, param: "maxIoWorkerNum", min: 1, max: 1<<31 - 1,
+		apply: func(cfg *producer.ProducerConfig, val int64) { cfg.MaxIoWorkerCount = val },
+	},
+}
+
+func (t producerTuning) parse(text string) (int64, error) {
+	val, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cant parse `%s`: %w", t.config, err)
+	}
+	if val < t.min || val > t.max {
+		return 0, fmt.Errorf("`%s` out of range [%d, %d]: %d", t.config, t.min, t.max, val)
+	}
+	return val, nil
+}
+
+// generateProducerParams validates the producer tuning keys of a topic.
+func generateProducerParams(argStore func(string) (string, bool), outputQuery url.Values) error {
+	for _, tuning := range producerTunings {
+		if text, ok := argStore(tuning.config); ok {
+			if _, err := tuning.parse(text); err != nil {
+				return err
+			}
+			outputQuery.Set(tuning.param, strings.TrimSpace(text))
+		}
+	}
+	if compressType, ok := argStore(AliyunSLSConfigCompressType); ok && compressType != "" {
+		compressType = strings.ToLower(strings.TrimSpace(compressType))
+		if _, ok = compressTypes[compressType]; !ok {
+			return fmt.Errorf("unsupported `%s`: %s", AliyunSLSConfigCompressType, compressType)
+		}
+		outputQuery.Set(AliyunSLSParamCompressType, compressType)
+	}
+	if signVersion, ok := argStore(AliyunSLSConfigSignVersion); ok && signVersion != "" {
+		signVersion = strings.ToLower(strings.TrimSpace(signVersion))
+		if _, ok = signVersions[signVersion]; !ok {
+			return fmt.Errorf("unsupported `%s`: %s", AliyunSLSConfigSignVersion, signVersion)
+		}
+		outputQuery.Set(AliyunSLSParamSignVersion, signVersion)
+	}
+	if region, ok := argStore(AliyunSLSConfigRegion); ok && region != "" {
+		outputQuery.Set(AliyunSLSParamRegion, strings.TrimSpace(region))
+	}
+	if outputQuery.Get(AliyunSLSParamSignVersion) == AliyunSLSSignV4 && outputQuery.Get(AliyunSLSParamRegion) == "" {
+		return fmt.Errorf("`%s` not optional with v4 `%s`", AliyunSLSConfigRegion, AliyunSLSConfigSignVersion)
+	}
+	return nil
+}
+
+func applyProducerParams(urlQuery url.Values, cfg *producer.ProducerConfig) error {
+	for _, tuning := range producerTunings {
+		if text := urlQuery.Get(tuning.param); text != "" {
+			val, err := tuning.parse(text)
+			if err != nil {
+				return err
+			}
+			tuning.apply(cfg, val)
+		}
+	}
+	if compressType := urlQuery.Get(AliyunSLSParamCompressType); compressType != "" {
+		var ok bool
+		if cfg.CompressType, ok = compressTypes[compressType]; !ok {
+			return fmt.Errorf("unsupported arg `%s`: %s", AliyunSLSParamCompressType, compressType)
+		}
+	}
+	if signVersion := urlQuery.Get(AliyunSLSParamSignVersion); signVersion != "" {
+		var ok bool
+		if cfg.AuthVersion, ok = signVersions[signVersion]; !ok {
+			return fmt.Errorf("unsupported arg `%s`: %s", AliyunSLSParamSignVersion, signVersion)
+		}
+	}
+	cfg.Region = urlQuery.Get(AliyunSLSParamRegion)
+	return nil
+}
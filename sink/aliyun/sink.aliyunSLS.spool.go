@@ -0,0 +1,391 @@
+package sink_aliyun
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"go.uber.org/zap"
+)
+
+const (
+	AliyunSLSConfigSpoolDir            = "SpoolDir"
+	AliyunSLSConfigSpoolMaxBytes       = "SpoolMaxBytes"
+	AliyunSLSConfigSpoolSegmentBytes   = "SpoolSegmentBytes"
+	AliyunSLSConfigSpoolReplayInterval = "SpoolReplayInterval"
+	AliyunSLSParamSpoolDir             = "spoolDir"
+	AliyunSLSParamSpoolMaxBytes        = "spoolMaxBytes"
+	AliyunSLSParamSpoolSegmentBytes    = "spoolSegmentBytes"
+	AliyunSLSParamSpoolReplayInterval  = "spoolReplayInterval"
+)
+
+const (
+	defaultSpoolMaxBytes       = 256 * 1024 * 1024
+	defaultSpoolSegmentBytes   = 8 * 1024 * 1024
+	defaultSpoolReplayInterval = 30 * time.Second
+	spoolSegmentExt            = ".spool"
+	spoolCorruptExt            = ".corrupt"
+	spoolRecordHeader          = 4
+)
+
+// spool keeps the logs the producer failed to deliver in segment files under dir,
+// each record is a length prefixed LogGroup holding one log with its topic and source.
+// Sealed segments are replayed oldest first, and deleted once every record of
+// them is delivered or spooled again, so a restart replays what was left.
+// Segments which cant be read are renamed with a `.corrupt` suffix and kept for inspection.
+type spool struct {
+	mu           sync.Mutex
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	interval     time.Duration
+	active       *os.File
+	activeSeq    uint64
+	activeSize   int64
+	sealed       []spoolSegment
+	totalBytes   int64
+	unhealthy    int32
+	lastReplay   time.Time
+	halted       bool
+	closed       bool
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+type spoolSegment struct {
+	seq  uint64
+	size int64
+}
+
+func (seg spoolSegment) name() string {
+	return fmt.Sprintf("%020d%s", seg.seq, spoolSegmentExt)
+}
+
+func openSpool(dir string, maxBytes, segmentBytes int64, interval time.Duration) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cant create spool directory: %w", err)
+	}
+	var s = &spool{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		interval:     interval,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cant read spool directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), spoolSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(entry.Name(), spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("cant stat spool segment: %w", err)
+		}
+		s.sealed = append(s.sealed, spoolSegment{seq: seq, size: info.Size()})
+		s.totalBytes += info.Size()
+		if seq >= s.activeSeq {
+			s.activeSeq = seq + 1
+		}
+	}
+	sort.Slice(s.sealed, func(i, j int) bool { return s.sealed[i].seq < s.sealed[j].seq })
+	return s, nil
+}
+
+func (s *spool) append(topic, source string, l *sls.Log) error {
+	data, err := (&sls.LogGroup{Topic: &topic, Source: &source, Logs: []*sls.Log{l}}).Marshal()
+	if err != nil {
+		return fmt.Errorf("cant marshal spool record: %w", err)
+	}
+	var record = make([]byte, spoolRecordHeader+len(data))
+	binary.LittleEndian.PutUint32(record, uint32(len(data)))
+	copy(record[spoolRecordHeader:], data)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("spool closed")
+	}
+	if s.active == nil {
+		var seg = spoolSegment{seq: s.activeSeq}
+		if s.active, err = os.OpenFile(filepath.Join(s.dir, seg.name()),
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+			return fmt.Errorf("cant open spool segment: %w", err)
+		}
+		s.activeSize = 0
+	}
+	if _, err = s.active.Write(record); err != nil {
+		return fmt.Errorf("cant write spool segment: %w", err)
+	}
+	s.activeSize += int64(len(record))
+	s.totalBytes += int64(len(record))
+	if s.activeSize >= s.segmentBytes {
+		s.sealActive()
+	}
+	for s.totalBytes > s.maxBytes && len(s.sealed) > 0 {
+		var oldest = s.sealed[0]
+		s.sealed = s.sealed[1:]
+		s.totalBytes -= oldest.size
+		_ = os.Remove(filepath.Join(s.dir, oldest.name()))
+	}
+	return nil
+}
+
+// sealActive closes the active segment and queues it for replay, s.mu must be held.
+func (s *spool) sealActive() {
+	if s.active == nil {
+		return
+	}
+	_ = s.active.Close()
+	s.sealed = append(s.sealed, spoolSegment{seq: s.activeSeq, size: s.activeSize})
+	s.active, s.activeSeq, s.activeSize = nil, s.activeSeq+1, 0
+}
+
+func (s *spool) markHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&s.unhealthy, 0)
+	} else {
+		atomic.StoreInt32(&s.unhealthy, 1)
+	}
+}
+
+// oldest seals the active segment when nothing else is queued, and returns the
+// oldest segment; while deliveries fail, replays are spaced by five intervals.
+func (s *spool) oldest() (spoolSegment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if atomic.LoadInt32(&s.unhealthy) == 1 && time.Since(s.lastReplay) < 5*s.interval {
+		return spoolSegment{}, false
+	}
+	if len(s.sealed) == 0 {
+		s.sealActive()
+	}
+	if len(s.sealed) == 0 {
+		return spoolSegment{}, false
+	}
+	s.lastReplay = time.Now()
+	return s.sealed[0], true
+}
+
+func (s *spool) remove(seg spoolSegment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sealed := range s.sealed {
+		if sealed.seq == seg.seq {
+			s.sealed = append(s.sealed[:i:i], s.sealed[i+1:]...)
+			s.totalBytes -= seg.size
+			break
+		}
+	}
+	_ = os.Remove(filepath.Join(s.dir, seg.name()))
+}
+
+// quarantine renames seg so it is never replayed again, and stops counting it against maxBytes.
+func (s *spool) quarantine(seg spoolSegment) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sealed := range s.sealed {
+		if sealed.seq == seg.seq {
+			s.sealed = append(s.sealed[:i:i], s.sealed[i+1:]...)
+			s.totalBytes -= seg.size
+			break
+		}
+	}
+	var path = filepath.Join(s.dir, seg.name())
+	if err := os.Rename(path, path+spoolCorruptExt); err != nil {
+		return path, err
+	}
+	return path + spoolCorruptExt, nil
+}
+
+func (s *spool) read(seg spoolSegment) ([]*sls.LogGroup, error) {
+	file, err := os.Open(filepath.Join(s.dir, seg.name()))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var groups []*sls.LogGroup
+	var header [spoolRecordHeader]byte
+	for remaining := info.Size(); ; {
+		if _, err = io.ReadFull(file, header[:]); err != nil {
+			// a crash while appending the length leaves a truncated tail
+			return groups, nil
+		}
+		remaining -= spoolRecordHeader
+		var size = int64(binary.LittleEndian.Uint32(header[:]))
+		if size > remaining {
+			return groups, fmt.Errorf("spool record of %d bytes exceeds the %d bytes left in segment", size, remaining)
+		}
+		remaining -= size
+		var data = make([]byte, size)
+		if _, err = io.ReadFull(file, data); err != nil {
+			return groups, err
+		}
+		var group = &sls.LogGroup{}
+		if err = group.Unmarshal(data); err != nil {
+			return groups, fmt.Errorf("cant unmarshal spool record: %w", err)
+		}
+		groups = append(groups, group)
+	}
+}
+
+// run replays one segment per interval through send until close is called,
+// send reports through done, once per log of the group, whether the log was
+// delivered or spooled again. A segment which cant be read is quarantined
+// after the records before the damage are replayed, and passed to fail.
+func (s *spool) run(send func(group *sls.LogGroup, done func()), fail func(path string, err error)) {
+	defer close(s.done)
+	var ticker = time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		seg, ok := s.oldest()
+		if !ok {
+			continue
+		}
+		groups, err := s.read(seg)
+		var wg sync.WaitGroup
+		for _, group := range groups {
+			if logs := len(group.GetLogs()); logs > 0 {
+				wg.Add(logs)
+				send(group, wg.Done)
+			}
+		}
+		wg.Wait()
+		if err != nil {
+			path, renameErr := s.quarantine(seg)
+			if renameErr != nil {
+				err = fmt.Errorf("%v, and cant quarantine it: %w", err, renameErr)
+			}
+			fail(path, err)
+			continue
+		}
+		s.remove(seg)
+	}
+}
+
+// halt stops starting new replays, records are still appended until close.
+func (s *spool) halt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.halted {
+		s.halted = true
+		close(s.stop)
+	}
+}
+
+// close waits for the running replay and closes the active segment.
+func (s *spool) close() {
+	s.halt()
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		_ = s.active.Close()
+		s.active = nil
+	}
+	s.closed = true
+}
+
+func parseSpoolParams(urlQuery url.Values) (dir string, maxBytes, segmentBytes int64, interval time.Duration, err error) {
+	maxBytes, segmentBytes, interval = defaultSpoolMaxBytes, defaultSpoolSegmentBytes, defaultSpoolReplayInterval
+	if dir = urlQuery.Get(AliyunSLSParamSpoolDir); dir == "" {
+		return "", 0, 0, 0, nil
+	}
+	if text := urlQuery.Get(AliyunSLSParamSpoolMaxBytes); text != "" {
+		if maxBytes, err = strconv.ParseInt(text, 10, 64); err != nil || maxBytes <= 0 {
+			return "", 0, 0, 0, fmt.Errorf("invalid arg `%s`: %s", AliyunSLSParamSpoolMaxBytes, text)
+		}
+	}
+	if text := urlQuery.Get(AliyunSLSParamSpoolSegmentBytes); text != "" {
+		if segmentBytes, err = strconv.ParseInt(text, 10, 64); err != nil || segmentBytes <= 0 {
+			return "", 0, 0, 0, fmt.Errorf("invalid arg `%s`: %s", AliyunSLSParamSpoolSegmentBytes, text)
+		}
+	}
+	if text := urlQuery.Get(AliyunSLSParamSpoolReplayInterval); text != "" {
+		if interval, err = time.ParseDuration(text); err != nil || interval <= 0 {
+			return "", 0, 0, 0, fmt.Errorf("invalid arg `%s`: %s", AliyunSLSParamSpoolReplayInterval, text)
+		}
+	}
+	if segmentBytes > maxBytes {
+		segmentBytes = maxBytes
+	}
+	return dir, maxBytes, segmentBytes, interval, nil
+}
+
+func generateSpoolParams(argStore func(string) (string, bool), outputQuery url.Values) error {
+	var dir, ok = argStore(AliyunSLSConfigSpoolDir)
+	if !ok || dir == "" {
+		return nil
+	}
+	outputQuery.Set(AliyunSLSParamSpoolDir, dir)
+	for config, param := range map[string]string{
+		AliyunSLSConfigSpoolMaxBytes:       AliyunSLSParamSpoolMaxBytes,
+		AliyunSLSConfigSpoolSegmentBytes:   AliyunSLSParamSpoolSegmentBytes,
+		AliyunSLSConfigSpoolReplayInterval: AliyunSLSParamSpoolReplayInterval,
+	} {
+		if val, exist := argStore(config); exist {
+			outputQuery.Set(param, strings.TrimSpace(val))
+		}
+	}
+	_, _, _, _, err := parseSpoolParams(outputQuery)
+	return err
+}
+
+// replayCallback marks the end of a replayed log, after the delivery callback handled it.
+type replayCallback struct {
+	deliveryCallback
+	done func()
+}
+
+func (c replayCallback) Success(result *producer.Result) {
+	c.deliveryCallback.Success(result)
+	c.done()
+}
+
+func (c replayCallback) Fail(result *producer.Result) {
+	c.deliveryCallback.Fail(result)
+	c.done()
+}
+
+func (sink *aliyunSLSSink) spoolFailed(path string, err error) {
+	if reporter, ok := sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error("aliyun-sls spool segment quarantined", zap.String("path", path), zap.Error(err))
+	}
+}
+
+func (sink *aliyunSLSSink) replay(group *sls.LogGroup, done func()) {
+	for _, l := range group.GetLogs() {
+		var callback = deliveryCallback{sink: sink, log: l, topic: group.GetTopic()}
+		if err := sink.producer.SendLogWithCallBack(sink.project, sink.logStore, group.GetTopic(), group.GetSource(),
+			l, replayCallback{deliveryCallback: callback, done: done}); err != nil {
+			callback.spool()
+			done()
+		}
+	}
+}
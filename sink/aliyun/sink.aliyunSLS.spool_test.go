@@ -0,0 +1,182 @@
+package sink_aliyun
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+func TestSpoolReplay(t *testing.T) {
+	var dir = t.TempDir()
+	s, err := openSpool(dir, defaultSpoolMaxBytes, defaultSpoolSegmentBytes, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"a", "b"} {
+		if err = s.append("topic", "source", spoolLog(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var replayed = make(chan string, 2)
+	go s.run(func(group *sls.LogGroup, done func()) {
+		replayed <- group.GetLogs()[0].GetContents()[0].GetValue()
+		done()
+	}, func(path string, err error) {
+		t.Errorf("unexpected spool failure of %s: %v", path, err)
+	})
+	for _, want := range []string{"a", "b"} {
+		select {
+		case got := <-replayed:
+			if got != want {
+				t.Errorf("replayed %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q not replayed", want)
+		}
+	}
+	s.close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("replayed segments left in spool directory: %v", entries)
+	}
+}
+
+func TestSpoolQuarantine(t *testing.T) {
+	var dir = t.TempDir()
+	var seg = spoolSegment{seq: 1}
+	var record = make([]byte, spoolRecordHeader+3)
+	binary.LittleEndian.PutUint32(record, 3)
+	copy(record[spoolRecordHeader:], []byte{0xff, 0xff, 0xff})
+	if err := os.WriteFile(filepath.Join(dir, seg.name()), record, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openSpool(dir, defaultSpoolMaxBytes, defaultSpoolSegmentBytes, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed = make(chan string, 1)
+	go s.run(func(group *sls.LogGroup, done func()) {
+		t.Errorf("unexpected replay of corrupt segment: %v", group)
+		done()
+	}, func(path string, err error) {
+		failed <- path
+	})
+	var path string
+	select {
+	case path = <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("corrupt segment not reported")
+	}
+	s.close()
+	if want := filepath.Join(dir, seg.name()) + spoolCorruptExt; path != want {
+		t.Errorf("reported %s, want %s", path, want)
+	}
+	if _, err = os.Stat(path); err != nil {
+		t.Errorf("corrupt segment not kept: %v", err)
+	}
+	if s.totalBytes != 0 || len(s.sealed) != 0 {
+		t.Errorf("corrupt segment still counted: %d bytes, %d segments", s.totalBytes, len(s.sealed))
+	}
+}
+
+func spoolRecord(t *testing.T, group *sls.LogGroup) []byte {
+	t.Helper()
+	data, err := group.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record = make([]byte, spoolRecordHeader+len(data))
+	binary.LittleEndian.PutUint32(record, uint32(len(data)))
+	copy(record[spoolRecordHeader:], data)
+	return record
+}
+
+func spoolLog(msg string) *sls.Log {
+	var key, val = "msg", msg
+	return &sls.Log{Time: new(uint32), Contents: []*sls.LogContent{{Key: &key, Value: &val}}}
+}
+
+// TestSpoolGroupSizes checks that done is expected once per log, for groups
+// without logs and with several of them.
+func TestSpoolGroupSizes(t *testing.T) {
+	var dir = t.TempDir()
+	var segment []byte
+	segment = append(segment, spoolRecord(t, &sls.LogGroup{})...)
+	segment = append(segment, spoolRecord(t, &sls.LogGroup{Logs: []*sls.Log{spoolLog("a"), spoolLog("b")}})...)
+	if err := os.WriteFile(filepath.Join(dir, spoolSegment{seq: 1}.name()), segment, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openSpool(dir, defaultSpoolMaxBytes, defaultSpoolSegmentBytes, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replayed = make(chan int, 2)
+	go s.run(func(group *sls.LogGroup, done func()) {
+		replayed <- len(group.GetLogs())
+		for range group.GetLogs() {
+			done()
+		}
+	}, func(path string, err error) {
+		t.Errorf("unexpected spool failure of %s: %v", path, err)
+	})
+	select {
+	case logs := <-replayed:
+		if logs != 2 {
+			t.Errorf("replayed a group of %d logs, want 2", logs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("group not replayed")
+	}
+	s.close()
+	if len(replayed) != 0 {
+		t.Error("group without logs replayed")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("replayed segments left in spool directory: %v", entries)
+	}
+}
+
+// TestSpoolRecordLength checks that a length pointing past the end of the
+// segment is taken as damage, after the records before it are replayed.
+func TestSpoolRecordLength(t *testing.T) {
+	var dir = t.TempDir()
+	var seg = spoolSegment{seq: 1}
+	var segment = spoolRecord(t, &sls.LogGroup{Logs: []*sls.Log{spoolLog("a")}})
+	var oversized = make([]byte, spoolRecordHeader+3)
+	binary.LittleEndian.PutUint32(oversized, 1<<31)
+	segment = append(segment, oversized...)
+	if err := os.WriteFile(filepath.Join(dir, seg.name()), segment, 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openSpool(dir, defaultSpoolMaxBytes, defaultSpoolSegmentBytes, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replayed = make(chan string, 1)
+	var failed = make(chan error, 1)
+	go s.run(func(group *sls.LogGroup, done func()) {
+		replayed <- group.GetLogs()[0].GetContents()[0].GetValue()
+		done()
+	}, func(path string, err error) {
+		failed <- err
+	})
+	select {
+	case err = <-failed:
+	case <-time.After(time.Second):
+		t.Fatal("oversized record not reported")
+	}
+	s.close()
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("unexpected failure %v", err)
+	}
+	if len(replayed) != 1 || <-replayed != "a" {
+		t.Error("record before the damage not replayed")
+	}
+	if _, err = os.Stat(filepath.Join(dir, seg.name()) + spoolCorruptExt); err != nil {
+		t.Errorf("damaged segment not kept: %v", err)
+	}
+}
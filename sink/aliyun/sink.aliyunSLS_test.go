@@ -0,0 +1,428 @@
+package sink_aliyun
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-log-go-sdk/producer"
+	"github.com/lipence/log-zap/sink/aliyun/slstest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// slsArgs returns the topic config of a sink writing to srv, keys add to or
+// replace the project, credentials and linger every test starts with.
+func slsArgs(srv *slstest.Server, keys map[string]string) func(string) (string, bool) {
+	var args = map[string]string{
+		AliyunSLSConfigEndpoint:        srv.Endpoint(),
+		AliyunSLSConfigAccessKeyID:     "ak",
+		AliyunSLSConfigAccessKeySecret: "sk",
+		AliyunSLSConfigProject:         "proj",
+		AliyunSLSConfigLogStore:        "store",
+		AliyunSLSConfigSource:          "host",
+		AliyunSLSConfigLingerMs:        "100",
+	}
+	for key, val := range keys {
+		args[key] = val
+	}
+	return func(key string) (string, bool) {
+		val, ok := args[key]
+		return val, ok
+	}
+}
+
+// startSink opens the sink url of g and starts its producer, delivery
+// failures are reported to the returned logs.
+func startSink(t *testing.T, srv *slstest.Server, g *urlGenerator, keys map[string]string) (
+	*zap.Logger, *aliyunSLSSink, *observer.ObservedLogs,
+) {
+	t.Helper()
+	var args = slsArgs(srv, keys)
+	rawURL, err := g.Generate(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_sink, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink = _sink.(*aliyunSLSSink)
+	var reporterCore, reports = observer.New(zapcore.DebugLevel)
+	sink.AcceptReporter(zap.New(reporterCore))
+	if err = sink.AcceptConfig(g, args); err != nil {
+		t.Fatal(err)
+	}
+	return zap.New(sink.HijackCore()), sink, reports
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func TestSinkBatching(t *testing.T) {
+	var srv = slstest.NewServer()
+	defer srv.Close()
+	var log, sink, _ = startSink(t, srv, NewURLGenerator(""), map[string]string{
+		AliyunSLSConfigLingerMs:      "2000",
+		AliyunSLSConfigMaxBatchCount: "5",
+		AliyunSLSConfigTopic:         "app",
+		AliyunSLSConfigLogTags:       "zone=a,env=test",
+	})
+	for i := 0; i < 12; i++ {
+		log.Info("hello", zap.Int("i", i))
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var groups = srv.LogGroups()
+	var counts = map[int]int{}
+	for _, group := range groups {
+		counts[len(group.GetLogs())]++
+		if group.Project != "proj" || group.LogStore != "store" {
+			t.Errorf("group sent to %s/%s", group.Project, group.LogStore)
+		}
+		if group.GetTopic() != "app" || group.GetSource() != "host" {
+			t.Errorf("group topic %q, source %q", group.GetTopic(), group.GetSource())
+		}
+		var tags = map[string]string{}
+		for _, tag := range group.GetLogTags() {
+			tags[tag.GetKey()] = tag.GetValue()
+		}
+		if len(tags) != 2 || tags["zone"] != "a" || tags["env"] != "test" {
+			t.Errorf("group tags %v", tags)
+		}
+	}
+	if len(groups) != 3 || counts[5] != 2 || counts[2] != 1 {
+		t.Errorf("got %d groups sized %v, want 5, 5 and 2", len(groups), counts)
+	}
+	var seen = map[string]bool{}
+	for _, contents := range srv.Logs() {
+		if contents["msg"] != "hello" || contents["level"] != "info" {
+			t.Errorf("unexpected contents %v", contents)
+		}
+		seen[contents["i"]] = true
+	}
+	if len(seen) != 12 {
+		t.Errorf("got %d distinct logs, want 12", len(seen))
+	}
+}
+
+func TestSinkNestedFields(t *testing.T) {
+	var nested = zap.Any("req", map[string]interface{}{
+		"method": "GET",
+		"header": map[string]interface{}{"host": "example.com"},
+		"codes":  []interface{}{200, 304},
+	})
+	for _, tc := range []struct {
+		config map[string]string
+		want   map[string]string
+	}{
+		{
+			config: map[string]string{},
+			want: map[string]string{
+				"req": `{"codes":[200,304],"header":{"host":"example.com"},"method":"GET"}`,
+			},
+		},
+		{
+			config: map[string]string{AliyunSLSConfigNestedFields: "flatten", AliyunSLSConfigFlattenSep: "_"},
+			want: map[string]string{
+				"req_method":      "GET",
+				"req_header_host": "example.com",
+				"req_codes":       "[200,304]",
+			},
+		},
+	} {
+		var srv = slstest.NewServer()
+		var log, sink, _ = startSink(t, srv, NewURLGenerator(""), tc.config)
+		log.Named("api").Info("nested", nested, zap.Duration("took", 1500*time.Millisecond), zap.Bool("ok", true))
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var logs = srv.Logs()
+		srv.Close()
+		if len(logs) != 1 {
+			t.Fatalf("got %d logs, want 1", len(logs))
+		}
+		for key, val := range tc.want {
+			if logs[0][key] != val {
+				t.Errorf("%v: content %s = %q, want %q", tc.config, key, logs[0][key], val)
+			}
+		}
+		if logs[0]["took"] != "1.5" || logs[0]["ok"] != "true" || logs[0]["logger"] != "api" {
+			t.Errorf("%v: unexpected scalar contents %v", tc.config, logs[0])
+		}
+		if groups := srv.LogGroups(); groups[0].GetTopic() != "api" {
+			t.Errorf("%v: topic %q, want logger name", tc.config, groups[0].GetTopic())
+		}
+	}
+}
+
+func TestSinkCloseFlushes(t *testing.T) {
+	var srv = slstest.NewServer()
+	defer srv.Close()
+	// the producer wakes up once per linger, so closing takes up to the linger
+	var log, sink, _ = startSink(t, srv, NewURLGenerator(""), map[string]string{AliyunSLSConfigLingerMs: "2000"})
+	log.Info("a")
+	log.Warn("b")
+	log.Debug("c")
+	time.Sleep(200 * time.Millisecond)
+	if requests := srv.Requests(); requests != 0 {
+		t.Fatalf("got %d requests before linger elapsed", requests)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if logs := srv.Logs(); len(logs) != 3 {
+		t.Errorf("got %d logs after close, want 3", len(logs))
+	}
+}
+
+type countingCallback struct {
+	successes int32
+	failures  int32
+}
+
+func (c *countingCallback) Success(_ *producer.Result) {
+	atomic.AddInt32(&c.successes, 1)
+}
+
+func (c *countingCallback) Fail(_ *producer.Result) {
+	atomic.AddInt32(&c.failures, 1)
+}
+
+func TestSinkCallbacksAndMetrics(t *testing.T) {
+	var srv = slstest.NewServer()
+	defer srv.Close()
+	var callback = &countingCallback{}
+	var g = NewURLGenerator("").WithCallback(callback)
+	var log, sink, reports = startSink(t, srv, g, map[string]string{AliyunSLSConfigRetries: "0"})
+	defer sink.Close()
+
+	srv.Fail(&slstest.Failure{Status: 400, Code: "Unauthorized", Message: "denied"})
+	log.Info("rejected")
+	waitFor(t, "failure callback", func() bool { return atomic.LoadInt32(&callback.failures) == 1 })
+	if failures := g.Metrics().Failures(); failures != 1 {
+		t.Errorf("metrics failures = %d, want 1", failures)
+	}
+	var failed = reports.FilterMessage("aliyun-sls delivery failed").AllUntimed()
+	if len(failed) != 1 {
+		t.Fatalf("got %d failure reports, want 1", len(failed))
+	}
+	if fields := failed[0].ContextMap(); fields["errorCode"] != "Unauthorized" || fields["errorMessage"] != "denied" ||
+		fields["spooled"] != false {
+		t.Errorf("unexpected failure report %v", fields)
+	}
+
+	srv.Fail(nil)
+	log.Info("accepted")
+	log.Info("accepted")
+	waitFor(t, "success callbacks", func() bool { return atomic.LoadInt32(&callback.successes) == 2 })
+	if successes := g.Metrics().Successes(); successes != 2 {
+		t.Errorf("metrics successes = %d, want 2", successes)
+	}
+	if failures := atomic.LoadInt32(&callback.failures); failures != 1 {
+		t.Errorf("got %d failure callbacks, want 1", failures)
+	}
+	if logs := srv.Logs(); len(logs) != 2 || logs[0]["msg"] != "accepted" {
+		t.Errorf("unexpected accepted logs %v", logs)
+	}
+}
+
+func TestGeneratorMetricsPerTopic(t *testing.T) {
+	var srv = slstest.NewServer()
+	defer srv.Close()
+	var base = NewURLGenerator("")
+	var g1, g2 = base.WithTopic("one"), base.WithTopic("two")
+	var log1, sink1, _ = startSink(t, srv, g1, map[string]string{})
+	var log2, sink2, _ = startSink(t, srv, g2, map[string]string{})
+	log1.Info("a")
+	log2.Info("b")
+	log2.Info("c")
+	_ = sink1.Close()
+	_ = sink2.Close()
+	if g1.Metrics().Successes() != 1 || g2.Metrics().Successes() != 2 || base.Metrics().Successes() != 0 {
+		t.Errorf("successes: one %d, two %d, base %d",
+			g1.Metrics().Successes(), g2.Metrics().Successes(), base.Metrics().Successes())
+	}
+}
+
+// TestCoreConcurrentWith runs under -race: siblings derived from one parent
+// concurrently must neither race on nor leak into each other's context.
+func TestCoreConcurrentWith(t *testing.T) {
+	encoder, err := newFieldEncoder("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root = &aliyunSLSCore{sink: &aliyunSLSSink{encoder: encoder, enabler: zapcore.DebugLevel}}
+	var parent = root.With([]zapcore.Field{zap.String("parent", "p")}).(*aliyunSLSCore)
+	const children = 32
+	var contexts = make([][2]map[string]string, children)
+	var wg sync.WaitGroup
+	for i := 0; i < children; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var id = strconv.Itoa(i)
+			var child = parent.With([]zapcore.Field{zap.String("child", id), zap.String("child"+id, id)})
+			var grandChild = child.With([]zapcore.Field{zap.Int("grandChild", i)})
+			contexts[i] = [2]map[string]string{child.(*aliyunSLSCore).context, grandChild.(*aliyunSLSCore).context}
+		}(i)
+	}
+	wg.Wait()
+	if len(root.context) != 0 || len(parent.context) != 1 || parent.context["parent"] != "p" {
+		t.Errorf("children changed the context of root %v or parent %v", root.context, parent.context)
+	}
+	for i, pair := range contexts {
+		var id = strconv.Itoa(i)
+		var want = map[string]string{"parent": "p", "child": id, "child" + id: id}
+		if !reflect.DeepEqual(pair[0], want) {
+			t.Errorf("child %d context %v, want %v", i, pair[0], want)
+		}
+		want["grandChild"] = id
+		if !reflect.DeepEqual(pair[1], want) {
+			t.Errorf("grandChild %d context %v, want %v", i, pair[1], want)
+		}
+	}
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func (c fixedClock) NewTicker(d time.Duration) *time.Ticker {
+	return time.NewTicker(d)
+}
+
+func TestSinkTimeNs(t *testing.T) {
+	var srv = slstest.NewServer()
+	defer srv.Close()
+	var log, sink, _ = startSink(t, srv, NewURLGenerator(""), map[string]string{})
+	log.WithOptions(zap.WithClock(fixedClock(time.Unix(1650000000, 123456789)))).Info("timed")
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var groups = srv.LogGroups()
+	if len(groups) != 1 || len(groups[0].GetLogs()) != 1 {
+		t.Fatalf("got %d groups, want one log", len(groups))
+	}
+	if l := groups[0].GetLogs()[0]; l.GetTime() != 1650000000 || l.TimeNs == nil || l.GetTimeNs() != 123456789 {
+		t.Errorf("log time %d.%09d, want 1650000000.123456789", l.GetTime(), l.GetTimeNs())
+	}
+}
+
+// TestSinkOpenedWithoutLogger checks that a sink url carrying where its
+// credentials are found starts the producer on the first write.
+func TestSinkOpenedWithoutLogger(t *testing.T) {
+	var srv = slstest.NewServer()
+	defer srv.Close()
+	t.Setenv(EnvAccessKeyID, "ak")
+	t.Setenv(EnvAccessKeySecret, "sk")
+	rawURL, err := NewURLGenerator("host").Generate(slsArgs(srv, map[string]string{
+		AliyunSLSConfigCredentials: AliyunSLSCredentialsEnv,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer direct.Close()
+	zap.New(direct.(*aliyunSLSSink).HijackCore()).Info("direct")
+	waitFor(t, "direct log", func() bool { return len(srv.Logs()) == 1 })
+
+	if rawURL, err = NewURLGenerator("host").Generate(slsArgs(srv, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(rawURL, "=sk") {
+		t.Errorf("static credentials leaked into %s", rawURL)
+	}
+	if sinkURL, err = url.Parse(rawURL); err != nil {
+		t.Fatal(err)
+	}
+	static, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer static.Close()
+	if err = static.(*aliyunSLSSink).HijackCore().Write(zapcore.Entry{Message: "static"}, nil); err == nil {
+		t.Error("sink without the credentials from logger started")
+	}
+
+	var query = sinkURL.Query()
+	query.Del(AliyunSLSParamCredentials)
+	sinkURL.RawQuery = query.Encode()
+	if _, err = register(sinkURL); err == nil {
+		t.Error("sink url without credentials accepted")
+	}
+}
+
+// TestSinkSpoolsWhileUnreachable checks that logs failing to reach SLS are
+// spooled without blocking the caller, and replayed once SLS recovers.
+func TestSinkSpoolsWhileUnreachable(t *testing.T) {
+	var srv = slstest.NewServer()
+	defer srv.Close()
+	var dir = t.TempDir()
+	var log, sink, reports = startSink(t, srv, NewURLGenerator(""), map[string]string{
+		AliyunSLSConfigRetries:             "0",
+		AliyunSLSConfigSpoolDir:            dir,
+		AliyunSLSConfigSpoolReplayInterval: "20ms",
+	})
+	defer sink.Close()
+	if sink.config.MaxBlockSec != 0 {
+		t.Errorf("producer blocks writes for %ds with a spool", sink.config.MaxBlockSec)
+	}
+
+	// the sdk retries 5xx and network errors within each request for up to its
+	// retry timeout, a rejection fails the delivery the same way without the wait
+	srv.Fail(&slstest.Failure{Status: 400, Code: "Unauthorized", Message: "denied"})
+	log.Info("spooled", zap.Int("i", 1))
+	log.Info("spooled", zap.Int("i", 2))
+	waitFor(t, "spooled failures", func() bool {
+		return reports.FilterMessage("aliyun-sls delivery failed").FilterField(zap.Bool("spooled", true)).Len() == 2
+	})
+	if logs := srv.Logs(); len(logs) != 0 {
+		t.Fatalf("server accepted %d logs while failing", len(logs))
+	}
+
+	srv.Fail(nil)
+	waitFor(t, "replayed logs", func() bool { return len(srv.Logs()) == 2 })
+	var seen = map[string]bool{}
+	for _, contents := range srv.Logs() {
+		if contents["msg"] != "spooled" {
+			t.Errorf("unexpected replayed contents %v", contents)
+		}
+		seen[contents["i"]] = true
+	}
+	if !seen["1"] || !seen["2"] {
+		t.Errorf("replayed %v, want both logs", seen)
+	}
+	waitFor(t, "empty spool", func() bool {
+		var entries, _ = os.ReadDir(dir)
+		return len(entries) == 0
+	})
+}
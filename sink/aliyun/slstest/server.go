@@ -0,0 +1,163 @@
+// Package slstest provides an in-process stand-in of the SLS PutLogs api,
+// the aliyun-sls sink can be pointed at it through the `Endpoint` topic key.
+package slstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	sls "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// LogGroup is a log group received by Server.
+type LogGroup struct {
+	Project  string
+	LogStore string
+	*sls.LogGroup
+}
+
+// Failure is returned to the producer instead of accepting the log group.
+type Failure struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	groups   []LogGroup
+	failure  *Failure
+	requests uint64
+}
+
+// NewServer starts a stand-in listening on a loopback ip, the sdk reaches ip
+// endpoints as an http proxy, so project names are taken from the request host.
+func NewServer() *Server {
+	var s = &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Endpoint returns the value of the `Endpoint` topic key.
+func (s *Server) Endpoint() string {
+	return s.URL
+}
+
+// Fail makes the following requests fail with f, nil restores success.
+func (s *Server) Fail(f *Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure = f
+}
+
+// Requests returns the count of PutLogs requests received, including failed ones.
+func (s *Server) Requests() uint64 {
+	return atomic.LoadUint64(&s.requests)
+}
+
+// LogGroups returns the log groups accepted so far.
+func (s *Server) LogGroups() []LogGroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]LogGroup(nil), s.groups...)
+}
+
+// Logs returns the contents of every accepted log, in arrival order.
+func (s *Server) Logs() []map[string]string {
+	var logs []map[string]string
+	for _, group := range s.LogGroups() {
+		for _, l := range group.GetLogs() {
+			var contents = make(map[string]string, len(l.GetContents()))
+			for _, content := range l.GetContents() {
+				contents[content.GetKey()] = content.GetValue()
+			}
+			logs = append(logs, contents)
+		}
+	}
+	return logs
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	atomic.AddUint64(&s.requests, 1)
+	w.Header().Set(sls.RequestIDHeader, strconv.FormatUint(atomic.LoadUint64(&s.requests), 10))
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/logstores/") {
+		writeError(w, http.StatusNotFound, "RequestNotSupported", r.Method+" "+r.URL.Path)
+		return
+	}
+	s.mu.Lock()
+	var failure = s.failure
+	s.mu.Unlock()
+	if failure != nil {
+		writeError(w, failure.Status, failure.Code, failure.Message)
+		return
+	}
+	group, err := decodeLogGroup(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "PostBodyInvalid", err.Error())
+		return
+	}
+	var project = r.Host
+	if i := strings.IndexByte(project, '.'); i >= 0 {
+		project = project[:i]
+	}
+	s.mu.Lock()
+	s.groups = append(s.groups, LogGroup{
+		Project:  project,
+		LogStore: strings.TrimPrefix(r.URL.Path, "/logstores/"),
+		LogGroup: group,
+	})
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func decodeLogGroup(r *http.Request) (*sls.LogGroup, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch compressType := r.Header.Get("x-log-compresstype"); compressType {
+	case "":
+	case "lz4":
+		rawSize, err := strconv.Atoi(r.Header.Get("x-log-bodyrawsize"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid x-log-bodyrawsize: %w", err)
+		}
+		var raw = make([]byte, rawSize)
+		if _, err = lz4.UncompressBlock(body, raw); err != nil {
+			return nil, fmt.Errorf("cant uncompress lz4 body: %w", err)
+		}
+		body = raw
+	case "zstd":
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		if body, err = decoder.DecodeAll(body, nil); err != nil {
+			return nil, fmt.Errorf("cant uncompress zstd body: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported x-log-compresstype: %s", compressType)
+	}
+	var group = &sls.LogGroup{}
+	if err = group.Unmarshal(body); err != nil {
+		return nil, fmt.Errorf("cant unmarshal log group: %w", err)
+	}
+	return group, nil
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"errorCode": code, "errorMessage": message})
+}
@@ -0,0 +1,131 @@
+// Package estest provides an in-process stand-in of the Elasticsearch _bulk api,
+// the elasticsearch sink can be pointed at it through the `URL` topic key.
+package estest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Document is a document accepted by Server.
+type Document struct {
+	Index  string
+	Source map[string]interface{}
+}
+
+// Rejector returns the status a document is answered with, 2xx accepts it.
+type Rejector func(index string, source map[string]interface{}) int
+
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	docs     []Document
+	status   int
+	rejector Rejector
+	requests uint64
+}
+
+func NewServer() *Server {
+	var s = &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Fail makes the following requests fail as a whole with status, zero restores success.
+func (s *Server) Fail(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// Reject decides the status of every following document, nil accepts all.
+func (s *Server) Reject(rejector Rejector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejector = rejector
+}
+
+// Requests returns the count of bulk requests received, including failed ones.
+func (s *Server) Requests() uint64 {
+	return atomic.LoadUint64(&s.requests)
+}
+
+// Documents returns the documents accepted so far, in arrival order.
+func (s *Server) Documents() []Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Document(nil), s.docs...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	atomic.AddUint64(&s.requests, 1)
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		writeError(w, http.StatusNotFound, r.Method+" "+r.URL.Path)
+		return
+	}
+	s.mu.Lock()
+	var status, rejector = s.status, s.rejector
+	s.mu.Unlock()
+	if status != 0 {
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+	var items []map[string]interface{}
+	var accepted []Document
+	var errors bool
+	var scanner = bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var action map[string]struct {
+			Index string `json:"_index"`
+		}
+		if err := json.Unmarshal(bytes.TrimSpace(scanner.Bytes()), &action); err != nil || len(action) != 1 {
+			writeError(w, http.StatusBadRequest, "malformed action line")
+			return
+		}
+		if !scanner.Scan() {
+			writeError(w, http.StatusBadRequest, "missing document line")
+			return
+		}
+		var doc Document
+		if err := json.Unmarshal(scanner.Bytes(), &doc.Source); err != nil {
+			writeError(w, http.StatusBadRequest, "malformed document: "+err.Error())
+			return
+		}
+		for opType, meta := range action {
+			doc.Index = meta.Index
+			var result = map[string]interface{}{"_index": doc.Index, "status": http.StatusCreated}
+			if rejector != nil {
+				if code := rejector(doc.Index, doc.Source); code >= 300 {
+					errors = true
+					result["status"] = code
+					result["error"] = map[string]string{"type": "rejected", "reason": http.StatusText(code)}
+				}
+			}
+			if result["status"] == http.StatusCreated {
+				accepted = append(accepted, doc)
+			}
+			items = append(items, map[string]interface{}{opType: result})
+		}
+	}
+	s.mu.Lock()
+	s.docs = append(s.docs, accepted...)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": errors, "items": items})
+}
+
+func writeError(w http.ResponseWriter, status int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  map[string]string{"type": "stand_in_exception", "reason": reason},
+		"status": status,
+	})
+}
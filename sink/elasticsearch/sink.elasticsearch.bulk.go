@@ -0,0 +1,134 @@
+package sink_elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	retryBackoffBase    = 500 * time.Millisecond
+	retryBackoffMaximum = 30 * time.Second
+)
+
+type bulkDoc struct {
+	index string
+	body  []byte
+}
+
+type bulkItem struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+type bulkResponse struct {
+	Errors bool                  `json:"errors"`
+	Items  []map[string]bulkItem `json:"items"`
+}
+
+// retryable reports whether the cluster may accept the request later,
+// 429 is returned when write thread pools or circuit breakers reject it.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func (sink *elasticsearchSink) bulkBody(docs []*bulkDoc) []byte {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		buf.WriteString(`{"`)
+		buf.WriteString(sink.opType)
+		buf.WriteString(`":{"_index":`)
+		index, _ := json.Marshal(doc.index)
+		buf.Write(index)
+		buf.WriteString("}}\n")
+		buf.Write(doc.body)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// bulk writes docs, documents rejected with a retryable status are sent again with
+// exponential backoff. The sink goroutine stays in here while backing off, so the
+// queue fills up and the queue policy applies back-pressure to loggers.
+func (sink *elasticsearchSink) bulk(docs []*bulkDoc) {
+	var backoff = retryBackoffBase
+	for attempt := 0; ; attempt++ {
+		var err error
+		if docs, err = sink.send(docs); len(docs) == 0 {
+			return
+		}
+		if attempt >= sink.retries {
+			sink.report("elasticsearch bulk retries exhausted", zap.Int("documents", len(docs)), zap.Error(err))
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-sink.stop:
+			// closing, use the remaining attempts without waiting
+		}
+		if backoff *= 2; backoff > retryBackoffMaximum {
+			backoff = retryBackoffMaximum
+		}
+	}
+}
+
+// send posts docs once, and returns the docs worth retrying.
+func (sink *elasticsearchSink) send(docs []*bulkDoc) (retry []*bulkDoc, err error) {
+	req, err := http.NewRequest(http.MethodPost, sink.target, bytes.NewReader(sink.bulkBody(docs)))
+	if err != nil {
+		sink.report("elasticsearch bulk request invalid", zap.Error(err))
+		return nil, err
+	}
+	for key, values := range sink.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return docs, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("unexpected response status: %s: %s", resp.Status, bytes.TrimSpace(message))
+		if retryable(resp.StatusCode) {
+			return docs, err
+		}
+		sink.report("elasticsearch bulk rejected", zap.Int("documents", len(docs)), zap.Error(err))
+		return nil, err
+	}
+	var result bulkResponse
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		sink.report("elasticsearch bulk response invalid", zap.Error(err))
+		return nil, err
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	var dropped int
+	var reason json.RawMessage
+	for i, item := range result.Items {
+		if i >= len(docs) {
+			break
+		}
+		for _, status := range item {
+			switch {
+			case status.Status < 300:
+			case retryable(status.Status):
+				retry = append(retry, docs[i])
+				err = fmt.Errorf("document rejected with status %d: %s", status.Status, status.Error)
+			default:
+				dropped, reason = dropped+1, status.Error
+			}
+		}
+	}
+	if dropped > 0 {
+		sink.report("elasticsearch documents dropped", zap.Int("documents", dropped), zap.ByteString("reason", reason))
+	}
+	return retry, err
+}
@@ -0,0 +1,103 @@
+package sink_elasticsearch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	ecsVersion       = "1.12.0"
+	ecsKeyTimestamp  = "@timestamp"
+	ecsKeyMessage    = "message"
+	ecsKeyLevel      = "log.level"
+	ecsKeyLogger     = "log.logger"
+	ecsKeyFileName   = "log.origin.file.name"
+	ecsKeyFileLine   = "log.origin.file.line"
+	ecsKeyFunction   = "log.origin.function"
+	ecsKeyStackTrace = "error.stack_trace"
+	ecsKeyErrMessage = "error.message"
+	ecsKeyErrType    = "error.type"
+	ecsKeyVersion    = "ecs.version"
+	ecsKeyService    = "service.name"
+	ecsKeyHost       = "host.name"
+)
+
+// ecsEncoder encodes entries as ECS documents, dotted keys are expanded into objects by Elasticsearch.
+func ecsEncoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		TimeKey:        ecsKeyTimestamp,
+		LevelKey:       ecsKeyLevel,
+		NameKey:        ecsKeyLogger,
+		CallerKey:      zapcore.OmitKey,
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     ecsKeyMessage,
+		StacktraceKey:  ecsKeyStackTrace,
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.TimeEncoderOfLayout(time.RFC3339Nano),
+		EncodeDuration: zapcore.NanosDurationEncoder,
+	})
+}
+
+// ecsFields maps caller info and errors of an entry to ECS fields,
+// other fields are kept as custom fields under their own keys.
+func ecsFields(e zapcore.Entry, fields []zapcore.Field) []zapcore.Field { // nolint:gocritic
+	var mapped = make([]zapcore.Field, 0, len(fields)+3)
+	if e.Caller.Defined {
+		mapped = append(mapped,
+			zapcore.Field{Key: ecsKeyFileName, Type: zapcore.StringType, String: strings.TrimSuffix(e.Caller.TrimmedPath(), ":"+strconv.Itoa(e.Caller.Line))},
+			zapcore.Field{Key: ecsKeyFileLine, Type: zapcore.Int64Type, Integer: int64(e.Caller.Line)},
+		)
+		if e.Caller.Function != "" {
+			mapped = append(mapped, zapcore.Field{Key: ecsKeyFunction, Type: zapcore.StringType, String: e.Caller.Function})
+		}
+	}
+	for _, field := range fields {
+		if field.Type == zapcore.ErrorType && field.Key == "error" {
+			if err, ok := field.Interface.(error); ok && err != nil {
+				mapped = append(mapped,
+					zapcore.Field{Key: ecsKeyErrMessage, Type: zapcore.StringType, String: err.Error()},
+					zapcore.Field{Key: ecsKeyErrType, Type: zapcore.StringType, String: strings.TrimPrefix(fmt.Sprintf("%T", err), "*")},
+				)
+				continue
+			}
+		}
+		mapped = append(mapped, field)
+	}
+	return mapped
+}
+
+// expandIndex replaces `{date}` and `{date:layout}` in pattern with t in UTC,
+// `{date}` defaults to layout 2006.01.02.
+func expandIndex(pattern string, t time.Time) string {
+	var builder strings.Builder
+	for {
+		var start = strings.Index(pattern, "{date")
+		if start < 0 {
+			builder.WriteString(pattern)
+			return builder.String()
+		}
+		var end = strings.IndexByte(pattern[start:], '}')
+		if end < 0 {
+			builder.WriteString(pattern)
+			return builder.String()
+		}
+		var layout = "2006.01.02"
+		switch inner := pattern[start+1 : start+end]; {
+		case inner == "date":
+		case strings.HasPrefix(inner, "date:") && len(inner) > len("date:"):
+			layout = inner[len("date:"):]
+		default:
+			builder.WriteString(pattern[:start+end+1])
+			pattern = pattern[start+end+1:]
+			continue
+		}
+		builder.WriteString(pattern[:start])
+		builder.WriteString(t.UTC().Format(layout))
+		pattern = pattern[start+end+1:]
+	}
+}
@@ -0,0 +1,440 @@
+package sink_elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	ElasticsearchSchema             = "elasticsearch"
+	ElasticsearchParamTarget        = "target"
+	ElasticsearchParamIndex         = "index"
+	ElasticsearchParamOpType        = "opType"
+	ElasticsearchParamService       = "service"
+	ElasticsearchParamQueueFull     = "queueFull"
+	ElasticsearchParamBatchCount    = "batchCount"
+	ElasticsearchParamBatchBytes    = "batchBytes"
+	ElasticsearchParamInterval      = "interval"
+	ElasticsearchParamQueueSize     = "queueSize"
+	ElasticsearchParamRetries       = "retries"
+	ElasticsearchParamTimeout       = "timeout"
+	ElasticsearchParamLevel         = "level"
+	ElasticsearchConfigURL          = "URL"
+	ElasticsearchConfigIndex        = "Index"
+	ElasticsearchConfigOpType       = "OpType"
+	ElasticsearchConfigService      = "ServiceName"
+	ElasticsearchConfigQueueFull    = "QueueFull"
+	ElasticsearchConfigBatchCount   = "BatchCount"
+	ElasticsearchConfigBatchBytes   = "BatchBytes"
+	ElasticsearchConfigInterval     = "FlushInterval"
+	ElasticsearchConfigQueueSize    = "QueueSize"
+	ElasticsearchConfigRetries      = "Retries"
+	ElasticsearchConfigTimeout      = "Timeout"
+	ElasticsearchConfigLevel        = "Level"
+	ElasticsearchConfigBasicUser    = "BasicAuthUser"
+	ElasticsearchConfigBasicPass    = "BasicAuthPassword" // #nosec G101
+	ElasticsearchConfigAPIKey       = "APIKey"            // #nosec G101
+	ElasticsearchOpTypeCreate       = "create"
+	ElasticsearchOpTypeIndex        = "index"
+	ElasticsearchQueueFullDrop      = "drop"
+	ElasticsearchQueueFullBlock     = "block"
+	elasticsearchDefaultIndex       = "logs-{date}"
+	elasticsearchDefaultBatchCount  = 1000
+	elasticsearchDefaultBatchBytes  = 5 * 1024 * 1024
+	elasticsearchDefaultInterval    = time.Second
+	elasticsearchDefaultQueueSize   = 8192
+	elasticsearchDefaultRetries     = 5
+	elasticsearchDefaultHTTPTimeout = 30 * time.Second
+)
+
+type elasticsearchCore struct {
+	sink *elasticsearchSink
+	enc  zapcore.Encoder
+}
+
+func (core *elasticsearchCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= core.sink.level
+}
+
+func (core *elasticsearchCore) With(fields []zapcore.Field) zapcore.Core {
+	var enc = core.enc.Clone()
+	for _, field := range ecsFields(zapcore.Entry{}, fields) {
+		field.AddTo(enc)
+	}
+	return &elasticsearchCore{sink: core.sink, enc: enc}
+}
+
+func (core *elasticsearchCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry { // nolint:gocritic
+	if core.Enabled(ent.Level) {
+		return ce.AddCore(ent, core)
+	}
+	return ce
+}
+
+func (core *elasticsearchCore) Write(e zapcore.Entry, fields []zapcore.Field) error { // nolint:gocritic
+	buf, err := core.enc.EncodeEntry(e, ecsFields(e, fields))
+	if err != nil {
+		return err
+	}
+	var body = strings.TrimSuffix(buf.String(), zapcore.DefaultLineEnding)
+	buf.Free()
+	core.sink.enqueue(&bulkDoc{index: expandIndex(core.sink.index, e.Time), body: []byte(body)})
+	return nil
+}
+
+func (core *elasticsearchCore) Sync() error {
+	return core.sink.Sync()
+}
+
+// elasticsearchSink batches documents and writes them through the _bulk api from a single goroutine.
+type elasticsearchSink struct {
+	target     string
+	index      string
+	opType     string
+	service    string
+	block      bool
+	batchCount int
+	batchBytes int
+	interval   time.Duration
+	retries    int
+	level      zapcore.Level
+	headers    http.Header
+	client     *http.Client
+	queue      chan *bulkDoc
+	flush      chan chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+	dropped    uint64
+	reporter   atomic.Value
+}
+
+func (sink *elasticsearchSink) HijackCore() zapcore.Core {
+	var enc = ecsEncoder()
+	enc.AddString(ecsKeyVersion, ecsVersion)
+	if hostname, err := os.Hostname(); err == nil {
+		enc.AddString(ecsKeyHost, hostname)
+	}
+	if sink.service != "" {
+		enc.AddString(ecsKeyService, sink.service)
+	}
+	return &elasticsearchCore{sink: sink, enc: enc}
+}
+
+func (sink *elasticsearchSink) AcceptReporter(reporter *zap.Logger) {
+	sink.reporter.Store(reporter)
+}
+
+// AcceptConfig receives the credentials, so they never appear in the sink url.
+func (sink *elasticsearchSink) AcceptConfig(_ interface{}, argStore func(string) (string, bool)) (err error) {
+	sink.headers, err = parseSecrets(argStore)
+	return err
+}
+
+func (sink *elasticsearchSink) report(msg string, fields ...zap.Field) {
+	if reporter, ok := sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error(msg, append(fields, zap.String("target", sink.target))...)
+	}
+}
+
+// enqueue hands doc to the sink goroutine, when the queue is full
+// the doc is dropped, or with queue policy `block` the logger waits.
+func (sink *elasticsearchSink) enqueue(doc *bulkDoc) {
+	if sink.block {
+		select {
+		case sink.queue <- doc:
+		case <-sink.done:
+		}
+		return
+	}
+	select {
+	case sink.queue <- doc:
+	default:
+		if dropped := atomic.AddUint64(&sink.dropped, 1); dropped&(dropped-1) == 0 {
+			sink.report("elasticsearch queue full, documents dropped", zap.Uint64("dropped", dropped))
+		}
+	}
+}
+
+func (sink *elasticsearchSink) run() {
+	defer close(sink.done)
+	var ticker = time.NewTicker(sink.interval)
+	defer ticker.Stop()
+	var batch []*bulkDoc
+	var batchBytes int
+	var send = func() {
+		if len(batch) > 0 {
+			sink.bulk(batch)
+			batch, batchBytes = nil, 0
+		}
+	}
+	var add = func(doc *bulkDoc) {
+		batch, batchBytes = append(batch, doc), batchBytes+len(doc.body)
+		if len(batch) >= sink.batchCount || batchBytes >= sink.batchBytes {
+			send()
+		}
+	}
+	var drain = func() {
+		for {
+			select {
+			case doc := <-sink.queue:
+				add(doc)
+			default:
+				send()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case doc := <-sink.queue:
+			add(doc)
+		case <-ticker.C:
+			send()
+		case flushed := <-sink.flush:
+			drain()
+			close(flushed)
+		case <-sink.stop:
+			drain()
+			return
+		}
+	}
+}
+
+func (sink *elasticsearchSink) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("use *elasticsearchCore instead")
+}
+
+// Sync waits until the documents queued so far are written.
+func (sink *elasticsearchSink) Sync() error {
+	var flushed = make(chan struct{})
+	select {
+	case sink.flush <- flushed:
+		<-flushed
+	case <-sink.done:
+	}
+	return nil
+}
+
+func (sink *elasticsearchSink) Close() error {
+	sink.closeOnce.Do(func() {
+		close(sink.stop)
+		<-sink.done
+	})
+	return nil
+}
+
+func parseCount(params url.Values, key string, def, min int) (int, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := strconv.Atoi(text)
+	if err != nil || val < min {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+func parseDuration(params url.Values, key string, def time.Duration) (time.Duration, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := time.ParseDuration(text)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+// parseOptions parses the url params shared by urlGenerator.Generate and register.
+func parseOptions(params url.Values, sink *elasticsearchSink) (queueSize int, timeout time.Duration, err error) {
+	if sink.index = params.Get(ElasticsearchParamIndex); sink.index == "" {
+		sink.index = elasticsearchDefaultIndex
+	}
+	if sample := expandIndex(sink.index, time.Now()); sample != strings.ToLower(sample) ||
+		strings.ContainsAny(sample, `\/*?"<>| ,#:`) || strings.HasPrefix(sample, "_") {
+		return 0, 0, fmt.Errorf("invalid arg `%s`: %s", ElasticsearchParamIndex, sink.index)
+	}
+	switch sink.opType = params.Get(ElasticsearchParamOpType); sink.opType {
+	case "":
+		sink.opType = ElasticsearchOpTypeCreate
+	case ElasticsearchOpTypeCreate, ElasticsearchOpTypeIndex:
+	default:
+		return 0, 0, fmt.Errorf("invalid arg `%s`: %s", ElasticsearchParamOpType, sink.opType)
+	}
+	switch queueFull := params.Get(ElasticsearchParamQueueFull); queueFull {
+	case "", ElasticsearchQueueFullDrop:
+	case ElasticsearchQueueFullBlock:
+		sink.block = true
+	default:
+		return 0, 0, fmt.Errorf("invalid arg `%s`: %s", ElasticsearchParamQueueFull, queueFull)
+	}
+	if sink.batchCount, err = parseCount(params, ElasticsearchParamBatchCount, elasticsearchDefaultBatchCount, 1); err != nil {
+		return 0, 0, err
+	}
+	if sink.batchBytes, err = parseCount(params, ElasticsearchParamBatchBytes, elasticsearchDefaultBatchBytes, 1); err != nil {
+		return 0, 0, err
+	}
+	if queueSize, err = parseCount(params, ElasticsearchParamQueueSize, elasticsearchDefaultQueueSize, 1); err != nil {
+		return 0, 0, err
+	}
+	if sink.retries, err = parseCount(params, ElasticsearchParamRetries, elasticsearchDefaultRetries, 0); err != nil {
+		return 0, 0, err
+	}
+	if sink.interval, err = parseDuration(params, ElasticsearchParamInterval, elasticsearchDefaultInterval); err != nil {
+		return 0, 0, err
+	}
+	if timeout, err = parseDuration(params, ElasticsearchParamTimeout, elasticsearchDefaultHTTPTimeout); err != nil {
+		return 0, 0, err
+	}
+	sink.level = zapcore.DebugLevel
+	if levelVal := params.Get(ElasticsearchParamLevel); levelVal != "" {
+		if err = sink.level.UnmarshalText([]byte(levelVal)); err != nil {
+			return 0, 0, fmt.Errorf("cant parse arg `%s`: %w", ElasticsearchParamLevel, err)
+		}
+	}
+	return queueSize, timeout, nil
+}
+
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var params = logPath.Query()
+	var _sink = &elasticsearchSink{
+		target:  params.Get(ElasticsearchParamTarget),
+		service: params.Get(ElasticsearchParamService),
+		headers: http.Header{},
+		flush:   make(chan chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if _sink.target == "" {
+		return nil, fmt.Errorf("undefined arg `%s`", ElasticsearchParamTarget)
+	}
+	var queueSize int
+	var timeout time.Duration
+	if queueSize, timeout, err = parseOptions(params, _sink); err != nil {
+		return nil, err
+	}
+	_sink.client = &http.Client{Timeout: timeout}
+	_sink.queue = make(chan *bulkDoc, queueSize)
+	go _sink.run()
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(ElasticsearchSchema, register); err != nil {
+		panic(fmt.Errorf("cant register elasticsearch sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic   string
+	service string
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic:   topic,
+		service: g.service,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return ElasticsearchSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var ok bool
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: ElasticsearchSchema, Host: "localhost"}
+	{
+		var target string
+		if target, ok = argStore(ElasticsearchConfigURL); !ok || target == "" {
+			return "", fmt.Errorf("`%s` not optional", ElasticsearchConfigURL)
+		}
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", ElasticsearchConfigURL, err)
+		} else if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+			return "", fmt.Errorf("unsupported `%s` scheme: %s", ElasticsearchConfigURL, targetURL.Scheme)
+		} else if targetURL.User != nil {
+			return "", fmt.Errorf("use `%s`/`%s` instead of userinfo in `%s`",
+				ElasticsearchConfigBasicUser, ElasticsearchConfigBasicPass, ElasticsearchConfigURL)
+		}
+		if !strings.HasSuffix(targetURL.Path, "/_bulk") {
+			targetURL.Path = strings.TrimSuffix(targetURL.Path, "/") + "/_bulk"
+		}
+		outputQuery.Set(ElasticsearchParamTarget, targetURL.String())
+	}
+	if service, exist := argStore(ElasticsearchConfigService); exist && service != "" {
+		outputQuery.Set(ElasticsearchParamService, service)
+	} else if g.service != "" {
+		outputQuery.Set(ElasticsearchParamService, g.service)
+	}
+	for config, param := range map[string]string{
+		ElasticsearchConfigIndex:      ElasticsearchParamIndex,
+		ElasticsearchConfigOpType:     ElasticsearchParamOpType,
+		ElasticsearchConfigQueueFull:  ElasticsearchParamQueueFull,
+		ElasticsearchConfigBatchCount: ElasticsearchParamBatchCount,
+		ElasticsearchConfigBatchBytes: ElasticsearchParamBatchBytes,
+		ElasticsearchConfigInterval:   ElasticsearchParamInterval,
+		ElasticsearchConfigQueueSize:  ElasticsearchParamQueueSize,
+		ElasticsearchConfigRetries:    ElasticsearchParamRetries,
+		ElasticsearchConfigTimeout:    ElasticsearchParamTimeout,
+		ElasticsearchConfigLevel:      ElasticsearchParamLevel,
+	} {
+		if val, exist := argStore(config); exist && val != "" {
+			outputQuery.Set(param, strings.TrimSpace(val))
+		}
+	}
+	for _, param := range []string{ElasticsearchParamOpType, ElasticsearchParamQueueFull} {
+		if val := outputQuery.Get(param); val != "" {
+			outputQuery.Set(param, strings.ToLower(val))
+		}
+	}
+	if _, _, err := parseOptions(outputQuery, &elasticsearchSink{}); err != nil {
+		return "", err
+	}
+	if _, err := parseSecrets(argStore); err != nil {
+		return "", err
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+// parseSecrets builds the headers sent with every bulk request, including credentials.
+func parseSecrets(argStore func(string) (string, bool)) (http.Header, error) {
+	var headers = http.Header{}
+	var user, _ = argStore(ElasticsearchConfigBasicUser)
+	var apiKey, _ = argStore(ElasticsearchConfigAPIKey)
+	switch {
+	case user != "" && apiKey != "":
+		return nil, fmt.Errorf("`%s` conflicts with `%s`", ElasticsearchConfigBasicUser, ElasticsearchConfigAPIKey)
+	case user != "":
+		var password, _ = argStore(ElasticsearchConfigBasicPass)
+		var req = &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(user, password)
+		headers.Set("Authorization", req.Header.Get("Authorization"))
+	case apiKey != "":
+		headers.Set("Authorization", "ApiKey "+apiKey)
+	}
+	return headers, nil
+}
+
+func NewURLGenerator(service string) *urlGenerator {
+	return &urlGenerator{service: service}
+}
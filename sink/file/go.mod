@@ -0,0 +1,15 @@
+module github.com/lipence/log-zap/sink/file
+
+go 1.17
+
+require (
+	github.com/lipence/log-zap/sink/lumberjack v0.0.0
+	go.uber.org/zap v1.21.0
+)
+
+require (
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+)
+
+replace github.com/lipence/log-zap/sink/lumberjack => ../lumberjack
@@ -0,0 +1,289 @@
+package sink_file
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lipence/log-zap/sink/lumberjack/logpath"
+)
+
+const (
+	FileSchema              = "file-reopen"
+	FileParamPath           = "path"
+	FileParamBase           = "base"
+	FileParamFileMode       = "fileMode"
+	FileParamCreateDirs     = "createDirs"
+	FileParamReopen         = "reopen"
+	FileParamCheckInterval  = "checkInterval"
+	FileConfigPath          = "Path"
+	FileConfigFileMode      = "FileMode"
+	FileConfigCreateDirs    = "CreateDirs"
+	FileConfigReopen        = "Reopen"
+	FileConfigCheckInterval = "CheckInterval"
+	FileReopenNever         = "never"
+	FileReopenSignal        = "signal"
+	FileReopenInode         = "inode"
+	fileDefaultFileMode     = 0644
+	fileDefaultDirMode      = 0755
+	fileDefaultInterval     = time.Second
+)
+
+// fileSink appends to a single file and never rotates it, the file is reopened
+// on SIGHUP or once its path points to another inode, so external tools like
+// logrotate can move or truncate it.
+type fileSink struct {
+	mu            sync.Mutex
+	path          string
+	mode          os.FileMode
+	file          *os.File
+	info          os.FileInfo
+	onSignal      bool
+	onInode       bool
+	checkInterval time.Duration
+	lastCheck     time.Time
+	signals       chan os.Signal
+	stop          chan struct{}
+	done          chan struct{}
+	closeOnce     sync.Once
+	reporter      atomic.Value
+}
+
+func (sink *fileSink) AcceptReporter(reporter *zap.Logger) {
+	sink.reporter.Store(reporter)
+}
+
+func (sink *fileSink) report(msg string, fields ...zap.Field) {
+	if reporter, ok := sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error(msg, append(fields, zap.String("path", sink.path))...)
+	}
+}
+
+func (sink *fileSink) open() error {
+	file, err := os.OpenFile(sink.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, sink.mode)
+	if err != nil {
+		return fmt.Errorf("cant open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("cant stat log file: %w", err)
+	}
+	if sink.file != nil {
+		_ = sink.file.Close()
+	}
+	sink.file, sink.info = file, info
+	return nil
+}
+
+// reopen replaces the current file, it keeps writing to the old one on failure.
+func (sink *fileSink) reopen(reason string) {
+	if err := sink.open(); err != nil {
+		sink.report("file reopen failed", zap.String("reason", reason), zap.Error(err))
+	}
+}
+
+// moved reports whether the path no longer refers to the open file.
+func (sink *fileSink) moved(now time.Time) bool {
+	if now.Sub(sink.lastCheck) < sink.checkInterval {
+		return false
+	}
+	sink.lastCheck = now
+	info, err := os.Stat(sink.path)
+	return err != nil || !os.SameFile(info, sink.info)
+}
+
+func (sink *fileSink) Write(p []byte) (int, error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.onInode && sink.moved(time.Now()) {
+		sink.reopen(FileReopenInode)
+	}
+	return sink.file.Write(p)
+}
+
+func (sink *fileSink) Sync() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.file.Sync()
+}
+
+func (sink *fileSink) Close() (err error) {
+	sink.closeOnce.Do(func() {
+		if sink.signals != nil {
+			signal.Stop(sink.signals)
+			close(sink.stop)
+			<-sink.done
+		}
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		err = sink.file.Close()
+	})
+	return err
+}
+
+func (sink *fileSink) watchSignal() {
+	defer close(sink.done)
+	for {
+		select {
+		case <-sink.signals:
+			sink.mu.Lock()
+			sink.reopen(FileReopenSignal)
+			sink.mu.Unlock()
+		case <-sink.stop:
+			return
+		}
+	}
+}
+
+// parseReopen parses a comma separated list of reopen policies.
+func parseReopen(text string) (onSignal, onInode bool, err error) {
+	if text == "" {
+		return true, false, nil
+	}
+	for _, policy := range strings.Split(text, ",") {
+		switch policy = strings.ToLower(strings.TrimSpace(policy)); policy {
+		case FileReopenNever:
+		case FileReopenSignal:
+			onSignal = true
+		case FileReopenInode:
+			onInode = true
+		default:
+			return false, false, fmt.Errorf("unsupported reopen policy: %s", policy)
+		}
+	}
+	return onSignal, onInode, nil
+}
+
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var params = logPath.Query()
+	var fileBase, filePath string
+	if fileBase = params.Get(FileParamBase); fileBase == "" {
+		return nil, fmt.Errorf("undefined arg `%s`", FileParamBase)
+	}
+	if filePath = params.Get(FileParamPath); filePath == "" {
+		return nil, fmt.Errorf("undefined arg `%s`", FileParamPath)
+	}
+	if err = logpath.Check(fileBase, filePath); err != nil {
+		return nil, err
+	}
+	var _sink = &fileSink{
+		path:          logpath.Target(fileBase, filePath),
+		mode:          fileDefaultFileMode,
+		checkInterval: fileDefaultInterval,
+	}
+	if fileModeVal := params.Get(FileParamFileMode); fileModeVal != "" {
+		if _sink.mode, err = logpath.ParseMode(fileModeVal); err != nil {
+			return nil, fmt.Errorf("cant parse arg `%s`: %w", FileParamFileMode, err)
+		}
+	}
+	if _sink.onSignal, _sink.onInode, err = parseReopen(params.Get(FileParamReopen)); err != nil {
+		return nil, fmt.Errorf("invalid arg `%s`: %w", FileParamReopen, err)
+	}
+	if intervalVal := params.Get(FileParamCheckInterval); intervalVal != "" {
+		if _sink.checkInterval, err = time.ParseDuration(intervalVal); err != nil {
+			return nil, fmt.Errorf("cant parse arg `%s`: %w", FileParamCheckInterval, err)
+		}
+	}
+	if params.Get(FileParamCreateDirs) == "true" {
+		if err = os.MkdirAll(filepath.Dir(_sink.path), fileDefaultDirMode); err != nil {
+			return nil, fmt.Errorf("cant create log directory: %w", err)
+		}
+	}
+	if err = _sink.open(); err != nil {
+		return nil, err
+	}
+	if _sink.onSignal {
+		_sink.signals = make(chan os.Signal, 1)
+		_sink.stop = make(chan struct{})
+		_sink.done = make(chan struct{})
+		signal.Notify(_sink.signals, syscall.SIGHUP)
+		go _sink.watchSignal()
+	}
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(FileSchema, register); err != nil {
+		panic(fmt.Errorf("cant register file-reopen sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic string
+	base  string
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		base:  g.base,
+		topic: topic,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return FileSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: FileSchema, Host: "localhost"}
+	if g.base == "" {
+		return "", fmt.Errorf("unspecificed log base path `%s`", FileParamBase)
+	}
+	outputQuery.Set(FileParamBase, g.base)
+	if filePath, ok := argStore(FileConfigPath); ok && filePath != "" {
+		if err := logpath.Check(g.base, filePath); err != nil {
+			return "", err
+		}
+		outputQuery.Set(FileParamPath, filePath)
+	} else {
+		return "", fmt.Errorf("unspecificed log relative path `%s`", FileConfigPath)
+	}
+	if fileMode, ok := argStore(FileConfigFileMode); ok && fileMode != "" {
+		if _, err := logpath.ParseMode(fileMode); err != nil {
+			return "", fmt.Errorf("invalid `%s`: %w", FileConfigFileMode, err)
+		}
+		outputQuery.Set(FileParamFileMode, fileMode)
+	}
+	if createDirs, ok := argStore(FileConfigCreateDirs); ok && createDirs != "" {
+		if enabled, err := strconv.ParseBool(strings.TrimSpace(createDirs)); err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", FileConfigCreateDirs, err)
+		} else if enabled {
+			outputQuery.Set(FileParamCreateDirs, "true")
+		}
+	}
+	if reopen, ok := argStore(FileConfigReopen); ok && reopen != "" {
+		if _, _, err := parseReopen(reopen); err != nil {
+			return "", fmt.Errorf("invalid `%s`: %w", FileConfigReopen, err)
+		}
+		outputQuery.Set(FileParamReopen, strings.ToLower(strings.ReplaceAll(reopen, " ", "")))
+	}
+	if interval, ok := argStore(FileConfigCheckInterval); ok && interval != "" {
+		if val, err := time.ParseDuration(interval); err != nil || val < 0 {
+			return "", fmt.Errorf("invalid `%s`: %s", FileConfigCheckInterval, interval)
+		}
+		outputQuery.Set(FileParamCheckInterval, interval)
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+func NewURLGenerator(baseDir string) *urlGenerator {
+	return &urlGenerator{
+		base: baseDir,
+	}
+}
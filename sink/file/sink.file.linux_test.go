@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package sink_file
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReopenOnSignal(t *testing.T) {
+	var base = t.TempDir()
+	var sink = openFile(t, base, map[string]string{FileConfigPath: "app.log"})
+	var path = filepath.Join(base, "app.log")
+	write(t, sink, "before")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(path); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("file not reopened on SIGHUP")
+		}
+	}
+	write(t, sink, "after")
+	assertContent(t, path+".1", "before\n")
+	assertContent(t, path, "after\n")
+}
+
+func TestFileMode(t *testing.T) {
+	var base = t.TempDir()
+	var umask = syscall.Umask(0)
+	defer syscall.Umask(umask)
+	for name, c := range map[string]struct {
+		config map[string]string
+		want   os.FileMode
+	}{
+		"default": {map[string]string{FileConfigPath: "default.log"}, fileDefaultFileMode},
+		"custom": {map[string]string{
+			FileConfigPath:       "custom/app.log",
+			FileConfigFileMode:   "0600",
+			FileConfigCreateDirs: "true",
+		}, 0600},
+	} {
+		var sink = openFile(t, base, c.config)
+		if info, err := os.Stat(sink.path); err != nil {
+			t.Error(err)
+		} else if info.Mode() != c.want {
+			t.Errorf("%s: file has mode %v, want %v", name, info.Mode(), c.want)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(base, "custom")); err != nil {
+		t.Error(err)
+	} else if info.Mode() != fileDefaultDirMode|os.ModeDir {
+		t.Errorf("directory has mode %v, want %v", info.Mode(), os.FileMode(fileDefaultDirMode)|os.ModeDir)
+	}
+}
@@ -0,0 +1,129 @@
+package sink_file
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sinkURL(t *testing.T, base string, config map[string]string) *url.URL {
+	t.Helper()
+	rawURL, err := NewURLGenerator(base).Generate(func(key string) (string, bool) {
+		val, ok := config[key]
+		return val, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func openFile(t *testing.T, base string, config map[string]string) *fileSink {
+	t.Helper()
+	sink, err := register(sinkURL(t, base, config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sink.Close() })
+	return sink.(*fileSink)
+}
+
+func write(t *testing.T, sink *fileSink, line string) {
+	t.Helper()
+	if _, err := sink.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertContent(t *testing.T, path string, want string) {
+	t.Helper()
+	if data, err := os.ReadFile(path); err != nil {
+		t.Error(err)
+	} else if string(data) != want {
+		t.Errorf("%s contains %q, want %q", filepath.Base(path), data, want)
+	}
+}
+
+// TestReopenOnInodeChange moves the file away the way logrotate does,
+// the next write goes to a new file at the configured path.
+func TestReopenOnInodeChange(t *testing.T) {
+	var base = t.TempDir()
+	var sink = openFile(t, base, map[string]string{
+		FileConfigPath:          "app.log",
+		FileConfigReopen:        FileReopenInode,
+		FileConfigCheckInterval: "0s",
+	})
+	var path = filepath.Join(base, "app.log")
+	write(t, sink, "before")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	write(t, sink, "after")
+	assertContent(t, path+".1", "before\n")
+	assertContent(t, path, "after\n")
+
+	// a truncated file keeps its inode, appends continue at its new end
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	write(t, sink, "truncated")
+	assertContent(t, path, "truncated\n")
+}
+
+func TestReopenNever(t *testing.T) {
+	var base = t.TempDir()
+	var sink = openFile(t, base, map[string]string{
+		FileConfigPath:          "app.log",
+		FileConfigReopen:        FileReopenNever,
+		FileConfigCheckInterval: "0s",
+	})
+	if sink.signals != nil {
+		t.Error("signal watched with reopen never")
+	}
+	var path = filepath.Join(base, "app.log")
+	write(t, sink, "before")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	write(t, sink, "after")
+	assertContent(t, path+".1", "before\nafter\n")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file reopened with reopen never: %v", err)
+	}
+}
+
+func TestCreateDirs(t *testing.T) {
+	var base = t.TempDir()
+	var sink = openFile(t, base, map[string]string{FileConfigPath: "a/b/app.log", FileConfigCreateDirs: "true"})
+	write(t, sink, "nested")
+	assertContent(t, filepath.Join(base, "a", "b", "app.log"), "nested\n")
+
+	if _, err := register(sinkURL(t, base, map[string]string{FileConfigPath: "c/app.log"})); err == nil {
+		t.Error("missing directory created without createDirs")
+	}
+}
+
+func TestGenerateRejects(t *testing.T) {
+	for name, config := range map[string]map[string]string{
+		"path":          {},
+		"escape":        {FileConfigPath: "../app.log"},
+		"fileMode":      {FileConfigPath: "app.log", FileConfigFileMode: "0800"},
+		"fileModeRange": {FileConfigPath: "app.log", FileConfigFileMode: "01644"},
+		"createDirs":    {FileConfigPath: "app.log", FileConfigCreateDirs: "maybe"},
+		"reopen":        {FileConfigPath: "app.log", FileConfigReopen: "signal,daily"},
+		"checkInterval": {FileConfigPath: "app.log", FileConfigCheckInterval: "-1s"},
+	} {
+		var config = config
+		if _, err := NewURLGenerator(t.TempDir()).Generate(func(key string) (string, bool) {
+			val, ok := config[key]
+			return val, ok
+		}); err == nil {
+			t.Errorf("invalid %s accepted", name)
+		}
+	}
+}
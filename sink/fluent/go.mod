@@ -0,0 +1,14 @@
+module github.com/lipence/log-zap/sink/fluent
+
+go 1.17
+
+require (
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	go.uber.org/zap v1.21.0
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+)
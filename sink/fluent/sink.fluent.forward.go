@@ -0,0 +1,158 @@
+package sink_fluent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+// eventTimeExt is the msgpack ext type of Forward protocol EventTime.
+const eventTimeExt = 0
+
+type recordField struct {
+	key   string
+	value interface{}
+}
+
+// recordValue converts values of a zapcore.MapObjectEncoder into msgpack friendly ones,
+// times and durations are formatted like jsonEncoder does.
+func recordValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case time.Duration:
+		return value.Seconds()
+	case complex128, complex64:
+		return fmt.Sprint(value)
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = recordValue(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = recordValue(item)
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+// recordFields encodes fields in the order they were added, namespaces become nested maps.
+func recordFields(fields []zapcore.Field) []recordField {
+	var enc = zapcore.NewMapObjectEncoder()
+	var keys = make([]string, 0, len(fields))
+	for _, field := range fields {
+		field.AddTo(enc)
+		if len(enc.Fields) > len(keys) {
+			for key := range enc.Fields {
+				if !containsKey(keys, key) {
+					keys = append(keys, key)
+				}
+			}
+		}
+	}
+	var encoded = make([]recordField, 0, len(keys))
+	for _, key := range keys {
+		encoded = append(encoded, recordField{key: key, value: recordValue(enc.Fields[key])})
+	}
+	return encoded
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeEntry encodes one Forward protocol entry `[EventTime, record]`.
+func encodeEntry(t time.Time, record []recordField) ([]byte, error) {
+	var buf bytes.Buffer
+	var enc = msgpack.NewEncoder(&buf)
+	if err := enc.EncodeArrayLen(2); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeExtHeader(eventTimeExt, 8); err != nil {
+		return nil, err
+	}
+	var eventTime [8]byte
+	binary.BigEndian.PutUint32(eventTime[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(eventTime[4:], uint32(t.Nanosecond()))
+	buf.Write(eventTime[:])
+	if err := enc.EncodeMapLen(len(record)); err != nil {
+		return nil, err
+	}
+	for _, field := range record {
+		if err := enc.EncodeString(field.key); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(field.value); err != nil {
+			return nil, fmt.Errorf("cant encode field `%s`: %w", field.key, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// newChunkID returns the id a server acknowledges a message with.
+func newChunkID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return base64.StdEncoding.EncodeToString(id[:])
+}
+
+// encodeMessage encodes a PackedForward message `[tag, entries, option]`,
+// or CompressedPackedForward when compress is set.
+func encodeMessage(tag string, entries [][]byte, chunk string, compress bool) ([]byte, error) {
+	var stream bytes.Buffer
+	if compress {
+		var gz = gzip.NewWriter(&stream)
+		for _, entry := range entries {
+			_, _ = gz.Write(entry)
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, entry := range entries {
+			stream.Write(entry)
+		}
+	}
+	if stream.Len() > math.MaxUint32 {
+		return nil, fmt.Errorf("message too large: %d bytes", stream.Len())
+	}
+	var option = map[string]interface{}{"size": len(entries)}
+	if chunk != "" {
+		option["chunk"] = chunk
+	}
+	if compress {
+		option["compressed"] = "gzip"
+	}
+	var buf bytes.Buffer
+	var enc = msgpack.NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	if err := enc.EncodeArrayLen(3); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeString(tag); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeBytes(stream.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(option); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,530 @@
+package sink_fluent
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	FluentSchema              = "fluent"
+	FluentParamNetwork        = "network"
+	FluentParamAddress        = "address"
+	FluentParamTag            = "tag"
+	FluentParamTagLogger      = "tagLogger"
+	FluentParamRequireAck     = "requireAck"
+	FluentParamAckTimeout     = "ackTimeout"
+	FluentParamCompression    = "compression"
+	FluentParamBatchCount     = "batchCount"
+	FluentParamBatchBytes     = "batchBytes"
+	FluentParamInterval       = "interval"
+	FluentParamQueueSize      = "queueSize"
+	FluentParamRetries        = "retries"
+	FluentParamTimeout        = "timeout"
+	FluentParamLevel          = "level"
+	FluentParamTLSCAFile      = "tlsCAFile"
+	FluentParamTLSInsecure    = "tlsInsecure"
+	FluentConfigNetwork       = "Network"
+	FluentConfigAddress       = "Address"
+	FluentConfigTag           = "Tag"
+	FluentConfigTagLogger     = "TagLogger"
+	FluentConfigRequireAck    = "RequireAck"
+	FluentConfigAckTimeout    = "AckTimeout"
+	FluentConfigCompression   = "Compression"
+	FluentConfigBatchCount    = "BatchCount"
+	FluentConfigBatchBytes    = "BatchBytes"
+	FluentConfigInterval      = "FlushInterval"
+	FluentConfigQueueSize     = "QueueSize"
+	FluentConfigRetries       = "Retries"
+	FluentConfigTimeout       = "Timeout"
+	FluentConfigLevel         = "Level"
+	FluentConfigTLSCAFile     = "TLSCAFile"
+	FluentConfigTLSInsecure   = "TLSInsecureSkipVerify"
+	FluentNetworkTLS          = "tls"
+	fluentDefaultNetwork      = "tcp"
+	fluentDefaultAddress      = "127.0.0.1:24224"
+	fluentDefaultAckTimeout   = 10 * time.Second
+	fluentDefaultBatchCount   = 1000
+	fluentDefaultBatchBytes   = 1024 * 1024
+	fluentDefaultInterval     = time.Second
+	fluentDefaultQueueSize    = 8192
+	fluentDefaultRetries      = 3
+	fluentDefaultTimeout      = 5 * time.Second
+	fluentRetryBackoffBase    = 500 * time.Millisecond
+	fluentRetryBackoffMaximum = 30 * time.Second
+)
+
+type fluentCore struct {
+	sink    *fluentSink
+	context []recordField
+}
+
+func (core *fluentCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= core.sink.level
+}
+
+func (core *fluentCore) With(fields []zapcore.Field) zapcore.Core {
+	var context = make([]recordField, 0, len(core.context)+len(fields))
+	context = append(context, core.context...)
+	return &fluentCore{sink: core.sink, context: append(context, recordFields(fields)...)}
+}
+
+func (core *fluentCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry { // nolint:gocritic
+	if core.Enabled(ent.Level) {
+		return ce.AddCore(ent, core)
+	}
+	return ce
+}
+
+func (core *fluentCore) Write(e zapcore.Entry, fields []zapcore.Field) error { // nolint:gocritic
+	var record = make([]recordField, 0, 5+len(core.context)+len(fields))
+	record = append(record, recordField{key: "level", value: e.Level.String()})
+	if e.LoggerName != "" {
+		record = append(record, recordField{key: "logger", value: e.LoggerName})
+	}
+	if e.Caller.Defined {
+		record = append(record, recordField{key: "caller", value: e.Caller.TrimmedPath()})
+	}
+	record = append(record, recordField{key: "msg", value: e.Message})
+	if e.Stack != "" {
+		record = append(record, recordField{key: "stacktrace", value: e.Stack})
+	}
+	record = append(record, core.context...)
+	record = append(record, recordFields(fields)...)
+	entry, err := encodeEntry(e.Time, record)
+	if err != nil {
+		return err
+	}
+	core.sink.enqueue(fluentEntry{tag: core.sink.tagOf(e.LoggerName), data: entry})
+	return nil
+}
+
+func (core *fluentCore) Sync() error {
+	return core.sink.Sync()
+}
+
+type fluentEntry struct {
+	tag  string
+	data []byte
+}
+
+// fluentSink batches entries per tag and forwards them from a single goroutine,
+// entries are dropped when the queue is full, so logging never blocks on fluentd.
+type fluentSink struct {
+	network    string
+	address    string
+	tlsConfig  *tls.Config
+	tag        string
+	tagLogger  bool
+	requireAck bool
+	ackTimeout time.Duration
+	compress   bool
+	batchCount int
+	batchBytes int
+	interval   time.Duration
+	retries    int
+	timeout    time.Duration
+	level      zapcore.Level
+	conn       net.Conn
+	reader     *bufio.Reader
+	queue      chan fluentEntry
+	flush      chan chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+	dropped    uint64
+	reporter   atomic.Value
+}
+
+func (sink *fluentSink) HijackCore() zapcore.Core {
+	return &fluentCore{sink: sink}
+}
+
+func (sink *fluentSink) AcceptReporter(reporter *zap.Logger) {
+	sink.reporter.Store(reporter)
+}
+
+func (sink *fluentSink) report(msg string, fields ...zap.Field) {
+	if reporter, ok := sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error(msg, append(fields, zap.String("address", sink.network+"://"+sink.address))...)
+	}
+}
+
+// tagOf appends the logger name to the configured tag when tagLogger is set.
+func (sink *fluentSink) tagOf(loggerName string) string {
+	if sink.tagLogger && loggerName != "" {
+		return sink.tag + "." + loggerName
+	}
+	return sink.tag
+}
+
+func (sink *fluentSink) enqueue(entry fluentEntry) {
+	select {
+	case sink.queue <- entry:
+	default:
+		if dropped := atomic.AddUint64(&sink.dropped, 1); dropped&(dropped-1) == 0 {
+			sink.report("fluent queue full, entries dropped", zap.Uint64("dropped", dropped))
+		}
+	}
+}
+
+func (sink *fluentSink) run() {
+	defer close(sink.done)
+	var ticker = time.NewTicker(sink.interval)
+	defer ticker.Stop()
+	var batch []fluentEntry
+	var batchBytes int
+	var send = func() {
+		if len(batch) > 0 {
+			sink.forward(batch)
+			batch, batchBytes = nil, 0
+		}
+	}
+	var add = func(entry fluentEntry) {
+		batch, batchBytes = append(batch, entry), batchBytes+len(entry.data)
+		if len(batch) >= sink.batchCount || batchBytes >= sink.batchBytes {
+			send()
+		}
+	}
+	var drain = func() {
+		for {
+			select {
+			case entry := <-sink.queue:
+				add(entry)
+			default:
+				send()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case entry := <-sink.queue:
+			add(entry)
+		case <-ticker.C:
+			send()
+		case flushed := <-sink.flush:
+			drain()
+			close(flushed)
+		case <-sink.stop:
+			drain()
+			if sink.conn != nil {
+				_ = sink.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// forward sends one PackedForward message per tag in batch.
+func (sink *fluentSink) forward(batch []fluentEntry) {
+	var tags []string
+	var entries = make(map[string][][]byte)
+	for _, entry := range batch {
+		if _, exist := entries[entry.tag]; !exist {
+			tags = append(tags, entry.tag)
+		}
+		entries[entry.tag] = append(entries[entry.tag], entry.data)
+	}
+	for _, tag := range tags {
+		var chunk string
+		if sink.requireAck {
+			chunk = newChunkID()
+		}
+		message, err := encodeMessage(tag, entries[tag], chunk, sink.compress)
+		if err != nil {
+			sink.report("fluent cant encode message", zap.String("tag", tag), zap.Error(err))
+			continue
+		}
+		if err = sink.send(message, chunk); err != nil {
+			sink.report("fluent forward failed", zap.String("tag", tag), zap.Int("entries", len(entries[tag])), zap.Error(err))
+		}
+	}
+}
+
+// send writes message, reconnecting and retrying with exponential backoff,
+// a message is only done once its chunk is acknowledged when acks are required.
+func (sink *fluentSink) send(message []byte, chunk string) (err error) {
+	var backoff = fluentRetryBackoffBase
+	for attempt := 0; ; attempt++ {
+		if err = sink.sendOnce(message, chunk); err == nil {
+			return nil
+		}
+		if sink.conn != nil {
+			_ = sink.conn.Close()
+			sink.conn, sink.reader = nil, nil
+		}
+		if attempt >= sink.retries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-sink.stop:
+			// closing, use the remaining attempts without waiting
+		}
+		if backoff *= 2; backoff > fluentRetryBackoffMaximum {
+			backoff = fluentRetryBackoffMaximum
+		}
+	}
+}
+
+func (sink *fluentSink) sendOnce(message []byte, chunk string) (err error) {
+	if sink.conn == nil {
+		var dialer = &net.Dialer{Timeout: sink.timeout}
+		if sink.network == FluentNetworkTLS {
+			sink.conn, err = tls.DialWithDialer(dialer, "tcp", sink.address, sink.tlsConfig)
+		} else {
+			sink.conn, err = dialer.Dial(sink.network, sink.address)
+		}
+		if err != nil {
+			sink.conn = nil
+			return err
+		}
+		sink.reader = bufio.NewReader(sink.conn)
+	}
+	_ = sink.conn.SetWriteDeadline(time.Now().Add(sink.timeout))
+	if _, err = sink.conn.Write(message); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+	_ = sink.conn.SetReadDeadline(time.Now().Add(sink.ackTimeout))
+	response, err := msgpack.NewDecoder(sink.reader).DecodeMap()
+	if err != nil {
+		return fmt.Errorf("cant read ack: %w", err)
+	}
+	if ack, _ := response["ack"].(string); ack != chunk {
+		return fmt.Errorf("unexpected ack `%v`, expect `%s`", response["ack"], chunk)
+	}
+	return nil
+}
+
+func (sink *fluentSink) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("use *fluentCore instead")
+}
+
+// Sync waits until the entries queued so far are forwarded.
+func (sink *fluentSink) Sync() error {
+	var flushed = make(chan struct{})
+	select {
+	case sink.flush <- flushed:
+		<-flushed
+	case <-sink.done:
+	}
+	return nil
+}
+
+func (sink *fluentSink) Close() error {
+	sink.closeOnce.Do(func() {
+		close(sink.stop)
+		<-sink.done
+	})
+	return nil
+}
+
+func loadTLSConfig(caFile string, insecure bool) (*tls.Config, error) {
+	var config = &tls.Config{InsecureSkipVerify: insecure} // #nosec G402
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cant read fluent tls ca file: %w", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in fluent tls ca file `%s`", caFile)
+	}
+	return config, nil
+}
+
+func parseCount(params url.Values, key string, def, min int) (int, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := strconv.Atoi(text)
+	if err != nil || val < min {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+func parseDuration(params url.Values, key string, def time.Duration) (time.Duration, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := time.ParseDuration(text)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+// parseOptions parses the url params shared by urlGenerator.Generate and register.
+func parseOptions(params url.Values, sink *fluentSink) (queueSize int, err error) {
+	switch sink.network = params.Get(FluentParamNetwork); sink.network {
+	case "":
+		sink.network = fluentDefaultNetwork
+	case "tcp", "tcp4", "tcp6", "unix", FluentNetworkTLS:
+	default:
+		return 0, fmt.Errorf("unsupported arg `%s`: %s", FluentParamNetwork, sink.network)
+	}
+	if sink.address = params.Get(FluentParamAddress); sink.address == "" {
+		sink.address = fluentDefaultAddress
+	}
+	if sink.tag = params.Get(FluentParamTag); sink.tag == "" {
+		return 0, fmt.Errorf("undefined arg `%s`", FluentParamTag)
+	}
+	sink.tagLogger = params.Get(FluentParamTagLogger) == "true"
+	sink.requireAck = params.Get(FluentParamRequireAck) == "true"
+	switch compression := params.Get(FluentParamCompression); compression {
+	case "", "none":
+	case "gzip":
+		sink.compress = true
+	default:
+		return 0, fmt.Errorf("unsupported arg `%s`: %s", FluentParamCompression, compression)
+	}
+	if sink.ackTimeout, err = parseDuration(params, FluentParamAckTimeout, fluentDefaultAckTimeout); err != nil {
+		return 0, err
+	}
+	if sink.batchCount, err = parseCount(params, FluentParamBatchCount, fluentDefaultBatchCount, 1); err != nil {
+		return 0, err
+	}
+	if sink.batchBytes, err = parseCount(params, FluentParamBatchBytes, fluentDefaultBatchBytes, 1); err != nil {
+		return 0, err
+	}
+	if queueSize, err = parseCount(params, FluentParamQueueSize, fluentDefaultQueueSize, 1); err != nil {
+		return 0, err
+	}
+	if sink.retries, err = parseCount(params, FluentParamRetries, fluentDefaultRetries, 0); err != nil {
+		return 0, err
+	}
+	if sink.interval, err = parseDuration(params, FluentParamInterval, fluentDefaultInterval); err != nil {
+		return 0, err
+	}
+	if sink.timeout, err = parseDuration(params, FluentParamTimeout, fluentDefaultTimeout); err != nil {
+		return 0, err
+	}
+	sink.level = zapcore.DebugLevel
+	if levelVal := params.Get(FluentParamLevel); levelVal != "" {
+		if err = sink.level.UnmarshalText([]byte(levelVal)); err != nil {
+			return 0, fmt.Errorf("cant parse arg `%s`: %w", FluentParamLevel, err)
+		}
+	}
+	if sink.network == FluentNetworkTLS {
+		if sink.tlsConfig, err = loadTLSConfig(params.Get(FluentParamTLSCAFile), params.Get(FluentParamTLSInsecure) == "true"); err != nil {
+			return 0, err
+		}
+	}
+	return queueSize, nil
+}
+
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var _sink = &fluentSink{
+		flush: make(chan chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	var queueSize int
+	if queueSize, err = parseOptions(logPath.Query(), _sink); err != nil {
+		return nil, err
+	}
+	_sink.queue = make(chan fluentEntry, queueSize)
+	go _sink.run()
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(FluentSchema, register); err != nil {
+		panic(fmt.Errorf("cant register fluent sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic string
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic: topic,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return FluentSchema
+}
+
+// Generate builds the sink url, the tag defaults to the topic name.
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: FluentSchema, Host: "localhost"}
+	if tag, exist := argStore(FluentConfigTag); exist && tag != "" {
+		outputQuery.Set(FluentParamTag, strings.TrimSpace(tag))
+	} else {
+		outputQuery.Set(FluentParamTag, g.Provider())
+	}
+	for config, param := range map[string]string{
+		FluentConfigTagLogger:   FluentParamTagLogger,
+		FluentConfigRequireAck:  FluentParamRequireAck,
+		FluentConfigTLSInsecure: FluentParamTLSInsecure,
+	} {
+		if val, exist := argStore(config); exist && val != "" {
+			var enabled, err = strconv.ParseBool(strings.TrimSpace(val))
+			if err != nil {
+				return "", fmt.Errorf("cant parse `%s`: %w", config, err)
+			}
+			outputQuery.Set(param, strconv.FormatBool(enabled))
+		}
+	}
+	for config, param := range map[string]string{
+		FluentConfigNetwork:     FluentParamNetwork,
+		FluentConfigAddress:     FluentParamAddress,
+		FluentConfigAckTimeout:  FluentParamAckTimeout,
+		FluentConfigCompression: FluentParamCompression,
+		FluentConfigBatchCount:  FluentParamBatchCount,
+		FluentConfigBatchBytes:  FluentParamBatchBytes,
+		FluentConfigInterval:    FluentParamInterval,
+		FluentConfigQueueSize:   FluentParamQueueSize,
+		FluentConfigRetries:     FluentParamRetries,
+		FluentConfigTimeout:     FluentParamTimeout,
+		FluentConfigLevel:       FluentParamLevel,
+		FluentConfigTLSCAFile:   FluentParamTLSCAFile,
+	} {
+		if val, exist := argStore(config); exist && val != "" {
+			outputQuery.Set(param, strings.TrimSpace(val))
+		}
+	}
+	for _, param := range []string{FluentParamNetwork, FluentParamCompression} {
+		if val := outputQuery.Get(param); val != "" {
+			outputQuery.Set(param, strings.ToLower(val))
+		}
+	}
+	if _, err := parseOptions(outputQuery, &fluentSink{}); err != nil {
+		return "", err
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+func NewURLGenerator() *urlGenerator {
+	return &urlGenerator{}
+}
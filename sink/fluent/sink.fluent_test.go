@@ -0,0 +1,276 @@
+package sink_fluent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type forwardEntry struct {
+	time   time.Time
+	record map[string]interface{}
+}
+
+type forwardMessage struct {
+	tag     string
+	entries []forwardEntry
+	option  map[string]interface{}
+}
+
+// forwardServer is a stand-in of a fluentd in_forward input, it decodes
+// PackedForward messages and answers their chunk with an ack when ack is set.
+type forwardServer struct {
+	net.Listener
+	ack      bool
+	messages chan forwardMessage
+	errs     chan error
+}
+
+func newForwardServer(t *testing.T, ack bool) *forwardServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s = &forwardServer{Listener: listener, ack: ack, messages: make(chan forwardMessage, 100), errs: make(chan error, 10)}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *forwardServer) serve(conn net.Conn) {
+	defer conn.Close()
+	var dec = msgpack.NewDecoder(conn)
+	dec.UseLooseInterfaceDecoding(true)
+	for {
+		var msg, err = decodeMessage(dec)
+		if err != nil {
+			if _, closed := err.(*net.OpError); err != io.EOF && !closed {
+				s.errs <- fmt.Errorf("cant decode forward message: %w", err)
+			}
+			return
+		}
+		s.messages <- msg
+		if chunk, _ := msg.option["chunk"].(string); s.ack && chunk != "" {
+			var ack, _ = msgpack.Marshal(map[string]string{"ack": chunk})
+			if _, err = conn.Write(ack); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func decodeMessage(dec *msgpack.Decoder) (msg forwardMessage, err error) {
+	if size, err := dec.DecodeArrayLen(); err != nil {
+		return msg, err
+	} else if size != 3 {
+		return msg, fmt.Errorf("message of %d items is not PackedForward", size)
+	}
+	if msg.tag, err = dec.DecodeString(); err != nil {
+		return msg, err
+	}
+	stream, err := dec.DecodeBytes()
+	if err != nil {
+		return msg, err
+	}
+	if msg.option, err = dec.DecodeMap(); err != nil {
+		return msg, err
+	}
+	var reader io.Reader = bytes.NewReader(stream)
+	if msg.option["compressed"] == "gzip" {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return msg, err
+		}
+	}
+	var entries = msgpack.NewDecoder(reader)
+	entries.UseLooseInterfaceDecoding(true)
+	for {
+		var entry forwardEntry
+		if size, err := entries.DecodeArrayLen(); err == io.EOF {
+			return msg, nil
+		} else if err != nil {
+			return msg, err
+		} else if size != 2 {
+			return msg, fmt.Errorf("entry of %d items", size)
+		}
+		if id, size, err := entries.DecodeExtHeader(); err != nil {
+			return msg, err
+		} else if id != eventTimeExt || size != 8 {
+			return msg, fmt.Errorf("unexpected time ext %d of %d bytes", id, size)
+		}
+		var eventTime [8]byte
+		if err = entries.ReadFull(eventTime[:]); err != nil {
+			return msg, err
+		}
+		entry.time = time.Unix(int64(binary.BigEndian.Uint32(eventTime[:4])), int64(binary.BigEndian.Uint32(eventTime[4:])))
+		if entry.record, err = entries.DecodeMap(); err != nil {
+			return msg, err
+		}
+		msg.entries = append(msg.entries, entry)
+	}
+}
+
+func (s *forwardServer) receive(t *testing.T) forwardMessage {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case err := <-s.errs:
+		t.Fatal(err)
+		return forwardMessage{}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+		return forwardMessage{}
+	}
+}
+
+// openSink opens a sink of the topic app forwarding to s, keys set the other topic config.
+func (s *forwardServer) openSink(t *testing.T, keys map[string]string) (*zap.Logger, *fluentSink, *observer.ObservedLogs) {
+	t.Helper()
+	rawURL, err := NewURLGenerator().WithTopic("app").Generate(func(key string) (string, bool) {
+		if key == FluentConfigAddress {
+			return s.Addr().String(), true
+		}
+		val, ok := keys[key]
+		return val, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_sink, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink = _sink.(*fluentSink)
+	var reporterCore, reports = observer.New(zapcore.DebugLevel)
+	sink.AcceptReporter(zap.New(reporterCore))
+	t.Cleanup(func() { _ = sink.Close() })
+	return zap.New(sink.HijackCore()), sink, reports
+}
+
+func TestSinkPackedForward(t *testing.T) {
+	var server = newForwardServer(t, false)
+	var log, sink, _ = server.openSink(t, map[string]string{
+		FluentConfigTagLogger: "true",
+		FluentConfigLevel:     "info",
+	})
+	var before = time.Now().Truncate(time.Second)
+	log.Debug("dropped")
+	log.Info("first", zap.Int("n", 1))
+	log.Info("second")
+	log.Named("api").With(zap.String("request", "r1")).Warn("named", zap.Namespace("http"), zap.Int("status", 503))
+	_ = sink.Sync()
+
+	var app, api = server.receive(t), server.receive(t)
+	if app.tag != "app" || len(app.entries) != 2 || fmt.Sprint(app.option["size"]) != "2" {
+		t.Fatalf("unexpected message %+v", app)
+	}
+	if _, exist := app.option["chunk"]; exist {
+		t.Errorf("chunk sent without ack required: %v", app.option)
+	}
+	var first = app.entries[0]
+	if first.record["msg"] != "first" || first.record["level"] != "info" || fmt.Sprint(first.record["n"]) != "1" {
+		t.Errorf("unexpected record %v", first.record)
+	}
+	if first.time.Before(before) || first.time.After(time.Now()) {
+		t.Errorf("unexpected event time %v", first.time)
+	}
+	if app.entries[1].record["msg"] != "second" {
+		t.Errorf("entries out of order: %v", app.entries)
+	}
+	if api.tag != "app.api" || len(api.entries) != 1 {
+		t.Fatalf("unexpected message %+v", api)
+	}
+	var named = api.entries[0].record
+	var nested, _ = named["http"].(map[string]interface{})
+	if named["logger"] != "api" || named["request"] != "r1" || fmt.Sprint(nested["status"]) != "503" {
+		t.Errorf("unexpected record %v", named)
+	}
+}
+
+func TestSinkAckCompressed(t *testing.T) {
+	var server = newForwardServer(t, true)
+	var log, sink, reports = server.openSink(t, map[string]string{
+		FluentConfigRequireAck:  "true",
+		FluentConfigCompression: "gzip",
+	})
+	log.Info("acked")
+	_ = sink.Sync()
+	log.Info("acked again")
+	_ = sink.Sync()
+	for _, want := range []string{"acked", "acked again"} {
+		var msg = server.receive(t)
+		if chunk, _ := msg.option["chunk"].(string); chunk == "" || msg.option["compressed"] != "gzip" {
+			t.Errorf("unexpected option %v", msg.option)
+		}
+		if len(msg.entries) != 1 || msg.entries[0].record["msg"] != want {
+			t.Errorf("unexpected entries %v, want %s", msg.entries, want)
+		}
+	}
+	if failed := reports.FilterMessage("fluent forward failed").AllUntimed(); len(failed) != 0 {
+		t.Errorf("acked messages reported as failed: %v", failed)
+	}
+}
+
+// TestSinkAckMissing checks that a message without ack is sent again on a
+// new connection, and reported once the retries are exhausted.
+func TestSinkAckMissing(t *testing.T) {
+	var server = newForwardServer(t, false)
+	var log, sink, reports = server.openSink(t, map[string]string{
+		FluentConfigRequireAck: "true",
+		FluentConfigAckTimeout: "100ms",
+		FluentConfigRetries:    "1",
+	})
+	log.Info("unacked")
+	_ = sink.Sync()
+	var first, second = server.receive(t), server.receive(t)
+	if first.option["chunk"] == nil || first.option["chunk"] != second.option["chunk"] {
+		t.Errorf("retry changed chunk %v to %v", first.option["chunk"], second.option["chunk"])
+	}
+	var failed = reports.FilterMessage("fluent forward failed").AllUntimed()
+	if len(failed) != 1 || failed[0].ContextMap()["entries"] != int64(1) || failed[0].ContextMap()["tag"] != "app" {
+		t.Errorf("got failure reports %v, want one", failed)
+	}
+}
+
+func TestGenerateRejects(t *testing.T) {
+	for name, config := range map[string]map[string]string{
+		"network":     {FluentConfigNetwork: "udp"},
+		"compression": {FluentConfigCompression: "zstd"},
+		"boolean":     {FluentConfigRequireAck: "yes"},
+		"count":       {FluentConfigBatchCount: "0"},
+		"duration":    {FluentConfigAckTimeout: "-1s"},
+		"level":       {FluentConfigLevel: "loud"},
+		"tlsCAFile":   {FluentConfigNetwork: "tls", FluentConfigTLSCAFile: "/nonexistent/ca.pem"},
+	} {
+		if _, err := NewURLGenerator().Generate(func(key string) (string, bool) {
+			val, ok := config[key]
+			return val, ok
+		}); err == nil {
+			t.Errorf("invalid %s accepted", name)
+		}
+	}
+}
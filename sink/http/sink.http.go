@@ -0,0 +1,545 @@
+package sink_http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	HTTPSchema            = "http-batch"
+	HTTPParamTarget       = "target"
+	HTTPParamMethod       = "method"
+	HTTPParamFormat       = "format"
+	HTTPParamGzip         = "gzip"
+	HTTPParamBatchCount   = "batchCount"
+	HTTPParamBatchBytes   = "batchBytes"
+	HTTPParamInterval     = "interval"
+	HTTPParamQueueSize    = "queueSize"
+	HTTPParamRetries      = "retries"
+	HTTPParamTimeout      = "timeout"
+	HTTPParamLevel        = "level"
+	HTTPConfigURL         = "URL"
+	HTTPConfigMethod      = "Method"
+	HTTPConfigFormat      = "Format"
+	HTTPConfigGzip        = "Gzip"
+	HTTPConfigBatchCount  = "BatchCount"
+	HTTPConfigBatchBytes  = "BatchBytes"
+	HTTPConfigInterval    = "FlushInterval"
+	HTTPConfigQueueSize   = "QueueSize"
+	HTTPConfigRetries     = "Retries"
+	HTTPConfigTimeout     = "Timeout"
+	HTTPConfigLevel       = "Level"
+	HTTPConfigHeaders     = "Headers"
+	HTTPConfigBasicUser   = "BasicAuthUser"
+	HTTPConfigBasicPass   = "BasicAuthPassword" // #nosec G101
+	HTTPConfigBearerToken = "BearerToken"       // #nosec G101
+	HTTPFormatNDJSON      = "ndjson"
+	HTTPFormatJSONArray   = "json-array"
+)
+
+const (
+	defaultBatchCount   = 500
+	defaultBatchBytes   = 1024 * 1024
+	defaultInterval     = time.Second
+	defaultQueueSize    = 8192
+	defaultRetries      = 3
+	defaultTimeout      = 10 * time.Second
+	retryBackoffBase    = 500 * time.Millisecond
+	retryBackoffMaximum = 30 * time.Second
+)
+
+type httpCore struct {
+	sink *httpSink
+	enc  zapcore.Encoder
+}
+
+func (core *httpCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= core.sink.level
+}
+
+func (core *httpCore) With(fields []zapcore.Field) zapcore.Core {
+	var enc = core.enc.Clone()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	return &httpCore{sink: core.sink, enc: enc}
+}
+
+func (core *httpCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry { // nolint:gocritic
+	if core.Enabled(ent.Level) {
+		return ce.AddCore(ent, core)
+	}
+	return ce
+}
+
+func (core *httpCore) Write(e zapcore.Entry, fields []zapcore.Field) error { // nolint:gocritic
+	buf, err := core.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	var entry = bytes.TrimSuffix(buf.Bytes(), []byte(zapcore.DefaultLineEnding))
+	core.sink.enqueue(append(make([]byte, 0, len(entry)), entry...))
+	buf.Free()
+	return nil
+}
+
+func (core *httpCore) Sync() error {
+	return core.sink.Sync()
+}
+
+// httpSink batches encoded entries and posts them from a single goroutine,
+// entries are dropped when the queue is full, so logging never blocks on the collector.
+type httpSink struct {
+	target     string
+	method     string
+	format     string
+	gzip       bool
+	batchCount int
+	batchBytes int
+	interval   time.Duration
+	retries    int
+	level      zapcore.Level
+	headers    http.Header
+	newEncoder func() zapcore.Encoder
+	client     *http.Client
+	queue      chan []byte
+	flush      chan chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+	dropped    uint64
+	reporter   atomic.Value
+}
+
+func (sink *httpSink) HijackCore() zapcore.Core {
+	return &httpCore{sink: sink, enc: sink.newEncoder()}
+}
+
+func (sink *httpSink) AcceptReporter(reporter *zap.Logger) {
+	sink.reporter.Store(reporter)
+}
+
+func (sink *httpSink) AcceptEncoder(newEncoder func() zapcore.Encoder) {
+	sink.newEncoder = newEncoder
+}
+
+// AcceptConfig receives the headers and credentials, so they never appear in the sink url.
+func (sink *httpSink) AcceptConfig(_ interface{}, argStore func(string) (string, bool)) (err error) {
+	sink.headers, err = parseSecrets(argStore)
+	return err
+}
+
+func (sink *httpSink) report(msg string, fields ...zap.Field) {
+	if reporter, ok := sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error(msg, append(fields, zap.String("target", sink.target))...)
+	}
+}
+
+func (sink *httpSink) enqueue(entry []byte) {
+	select {
+	case sink.queue <- entry:
+	default:
+		if dropped := atomic.AddUint64(&sink.dropped, 1); dropped&(dropped-1) == 0 {
+			sink.report("http-batch queue full, entries dropped", zap.Uint64("dropped", dropped))
+		}
+	}
+}
+
+func (sink *httpSink) run() {
+	defer close(sink.done)
+	var ticker = time.NewTicker(sink.interval)
+	defer ticker.Stop()
+	var batch [][]byte
+	var batchBytes int
+	var send = func() {
+		if len(batch) > 0 {
+			sink.post(batch)
+			batch, batchBytes = nil, 0
+		}
+	}
+	var drain = func() {
+		for {
+			select {
+			case entry := <-sink.queue:
+				batch, batchBytes = append(batch, entry), batchBytes+len(entry)
+				if len(batch) >= sink.batchCount || batchBytes >= sink.batchBytes {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case entry := <-sink.queue:
+			batch, batchBytes = append(batch, entry), batchBytes+len(entry)
+			if len(batch) >= sink.batchCount || batchBytes >= sink.batchBytes {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-sink.flush:
+			drain()
+			close(flushed)
+		case <-sink.stop:
+			drain()
+			return
+		}
+	}
+}
+
+func (sink *httpSink) body(batch [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if sink.gzip {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	if sink.format == HTTPFormatJSONArray {
+		_, _ = w.Write([]byte{'['})
+		for i, entry := range batch {
+			if i > 0 {
+				_, _ = w.Write([]byte{','})
+			}
+			_, _ = w.Write(entry)
+		}
+		_, _ = w.Write([]byte{']'})
+	} else {
+		for _, entry := range batch {
+			_, _ = w.Write(entry)
+			_, _ = w.Write([]byte{'\n'})
+		}
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends a batch, retrying network errors, 429 and 5xx responses with exponential backoff.
+func (sink *httpSink) post(batch [][]byte) {
+	body, err := sink.body(batch)
+	if err != nil {
+		sink.report("http-batch cant encode batch", zap.Error(err))
+		return
+	}
+	var backoff = retryBackoffBase
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		if retryable, err = sink.do(body); err == nil {
+			return
+		}
+		if !retryable || attempt >= sink.retries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-sink.stop:
+			// closing, use the remaining attempts without waiting
+		}
+		if backoff *= 2; backoff > retryBackoffMaximum {
+			backoff = retryBackoffMaximum
+		}
+	}
+	sink.report("http-batch delivery failed", zap.Int("entries", len(batch)), zap.Error(err))
+}
+
+func (sink *httpSink) do(body []byte) (retryable bool, err error) {
+	req, err := http.NewRequest(sink.method, sink.target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, values := range sink.headers {
+		req.Header[key] = values
+	}
+	if sink.format == HTTPFormatJSONArray {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	if sink.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		fmt.Errorf("unexpected response status: %s", resp.Status)
+}
+
+func (sink *httpSink) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("use *httpCore instead")
+}
+
+// Sync waits until the entries queued so far are posted.
+func (sink *httpSink) Sync() error {
+	var flushed = make(chan struct{})
+	select {
+	case sink.flush <- flushed:
+		<-flushed
+	case <-sink.done:
+	}
+	return nil
+}
+
+func (sink *httpSink) Close() error {
+	sink.closeOnce.Do(func() {
+		close(sink.stop)
+		<-sink.done
+	})
+	return nil
+}
+
+func parseHeaders(text string) (http.Header, error) {
+	var headers = http.Header{}
+	for _, pair := range strings.Split(text, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		var kv = strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("cant parse header `%s`: expect key=value", pair)
+		}
+		headers.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return headers, nil
+}
+
+func parsePositive(params url.Values, key string, def int) (int, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := strconv.Atoi(text)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+func parseCount(params url.Values, key string, def int) (int, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := strconv.Atoi(text)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+func parseDuration(params url.Values, key string, def time.Duration) (time.Duration, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := time.ParseDuration(text)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var params = logPath.Query()
+	var _sink = &httpSink{
+		target:  params.Get(HTTPParamTarget),
+		method:  params.Get(HTTPParamMethod),
+		format:  params.Get(HTTPParamFormat),
+		gzip:    params.Get(HTTPParamGzip) == "true",
+		headers: http.Header{},
+		newEncoder: func() zapcore.Encoder {
+			return zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		},
+		flush: make(chan chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		level: zapcore.DebugLevel,
+	}
+	if _sink.target == "" {
+		return nil, fmt.Errorf("undefined arg `%s`", HTTPParamTarget)
+	}
+	if _sink.method == "" {
+		_sink.method = http.MethodPost
+	}
+	if _sink.batchCount, err = parsePositive(params, HTTPParamBatchCount, defaultBatchCount); err != nil {
+		return nil, err
+	}
+	if _sink.batchBytes, err = parsePositive(params, HTTPParamBatchBytes, defaultBatchBytes); err != nil {
+		return nil, err
+	}
+	var queueSize int
+	if queueSize, err = parsePositive(params, HTTPParamQueueSize, defaultQueueSize); err != nil {
+		return nil, err
+	}
+	if _sink.retries, err = parseCount(params, HTTPParamRetries, defaultRetries); err != nil {
+		return nil, err
+	}
+	if _sink.interval, err = parseDuration(params, HTTPParamInterval, defaultInterval); err != nil {
+		return nil, err
+	}
+	var timeout time.Duration
+	if timeout, err = parseDuration(params, HTTPParamTimeout, defaultTimeout); err != nil {
+		return nil, err
+	}
+	if levelVal := params.Get(HTTPParamLevel); levelVal != "" {
+		if err = _sink.level.UnmarshalText([]byte(levelVal)); err != nil {
+			return nil, fmt.Errorf("cant parse arg `%s`: %w", HTTPParamLevel, err)
+		}
+	}
+	_sink.client = &http.Client{Timeout: timeout}
+	_sink.queue = make(chan []byte, queueSize)
+	go _sink.run()
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(HTTPSchema, register); err != nil {
+		panic(fmt.Errorf("cant register http-batch sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic string
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic: topic,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return HTTPSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var ok bool
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: HTTPSchema, Host: "localhost"}
+	{
+		var target string
+		if target, ok = argStore(HTTPConfigURL); !ok || target == "" {
+			return "", fmt.Errorf("`%s` not optional", HTTPConfigURL)
+		}
+		if targetURL, err := url.Parse(target); err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", HTTPConfigURL, err)
+		} else if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+			return "", fmt.Errorf("unsupported `%s` scheme: %s", HTTPConfigURL, targetURL.Scheme)
+		} else if targetURL.User != nil {
+			return "", fmt.Errorf("use `%s`/`%s` instead of userinfo in `%s`",
+				HTTPConfigBasicUser, HTTPConfigBasicPass, HTTPConfigURL)
+		}
+		outputQuery.Set(HTTPParamTarget, target)
+	}
+	if method, exist := argStore(HTTPConfigMethod); exist && method != "" {
+		outputQuery.Set(HTTPParamMethod, strings.ToUpper(strings.TrimSpace(method)))
+	}
+	if format, exist := argStore(HTTPConfigFormat); exist && format != "" {
+		switch format = strings.ToLower(strings.TrimSpace(format)); format {
+		case HTTPFormatNDJSON, HTTPFormatJSONArray:
+			outputQuery.Set(HTTPParamFormat, format)
+		default:
+			return "", fmt.Errorf("unsupported `%s`: %s", HTTPConfigFormat, format)
+		}
+	}
+	if gz, exist := argStore(HTTPConfigGzip); exist && gz != "" {
+		if enabled, err := strconv.ParseBool(strings.TrimSpace(gz)); err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", HTTPConfigGzip, err)
+		} else if enabled {
+			outputQuery.Set(HTTPParamGzip, "true")
+		}
+	}
+	for config, param := range map[string]string{
+		HTTPConfigBatchCount: HTTPParamBatchCount,
+		HTTPConfigBatchBytes: HTTPParamBatchBytes,
+		HTTPConfigQueueSize:  HTTPParamQueueSize,
+		HTTPConfigInterval:   HTTPParamInterval,
+		HTTPConfigTimeout:    HTTPParamTimeout,
+		HTTPConfigLevel:      HTTPParamLevel,
+		HTTPConfigRetries:    HTTPParamRetries,
+	} {
+		if val, exist := argStore(config); exist && val != "" {
+			outputQuery.Set(param, strings.TrimSpace(val))
+		}
+	}
+	for _, validate := range []func() error{
+		func() error { _, err := parsePositive(outputQuery, HTTPParamBatchCount, 0); return err },
+		func() error { _, err := parsePositive(outputQuery, HTTPParamBatchBytes, 0); return err },
+		func() error { _, err := parsePositive(outputQuery, HTTPParamQueueSize, 0); return err },
+		func() error { _, err := parseCount(outputQuery, HTTPParamRetries, 0); return err },
+		func() error { _, err := parseDuration(outputQuery, HTTPParamInterval, 0); return err },
+		func() error { _, err := parseDuration(outputQuery, HTTPParamTimeout, 0); return err },
+		func() error {
+			var lvl zapcore.Level
+			if text := outputQuery.Get(HTTPParamLevel); text != "" {
+				return lvl.UnmarshalText([]byte(text))
+			}
+			return nil
+		},
+	} {
+		if err := validate(); err != nil {
+			return "", err
+		}
+	}
+	if _, err := parseSecrets(argStore); err != nil {
+		return "", err
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+// parseSecrets builds the headers sent with every request, including credentials.
+func parseSecrets(argStore func(string) (string, bool)) (headers http.Header, err error) {
+	headers = http.Header{}
+	if text, exist := argStore(HTTPConfigHeaders); exist && text != "" {
+		if headers, err = parseHeaders(text); err != nil {
+			return nil, err
+		}
+	}
+	var user, _ = argStore(HTTPConfigBasicUser)
+	var password, _ = argStore(HTTPConfigBasicPass)
+	var token, _ = argStore(HTTPConfigBearerToken)
+	switch {
+	case user != "" && token != "":
+		return nil, fmt.Errorf("`%s` conflicts with `%s`", HTTPConfigBasicUser, HTTPConfigBearerToken)
+	case user != "":
+		var req = &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(user, password)
+		headers.Set("Authorization", req.Header.Get("Authorization"))
+	case token != "":
+		headers.Set("Authorization", "Bearer "+token)
+	}
+	return headers, nil
+}
+
+func NewURLGenerator() *urlGenerator {
+	return &urlGenerator{}
+}
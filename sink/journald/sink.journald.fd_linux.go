@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package sink_journald
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// sendFile passes p to journald through an unlinked file in /dev/shm,
+// the way sd_journal_send handles entries too large for a datagram.
+func sendFile(conn *net.UnixConn, socket *net.UnixAddr, p []byte) error {
+	file, err := os.CreateTemp("/dev/shm", "journal.")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = os.Remove(file.Name()); err != nil {
+		return err
+	}
+	if _, err = file.Write(p); err != nil {
+		return err
+	}
+	_, _, err = conn.WriteMsgUnix(nil, syscall.UnixRights(int(file.Fd())), socket)
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package sink_journald
+
+import (
+	"fmt"
+	"net"
+)
+
+// sendFile is only supported on linux, where journald runs.
+func sendFile(_ *net.UnixConn, _ *net.UnixAddr, p []byte) error {
+	return fmt.Errorf("entry of %d bytes exceeds the datagram size limit", len(p))
+}
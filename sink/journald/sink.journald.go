@@ -0,0 +1,361 @@
+package sink_journald
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	JournaldSchema            = "journald"
+	JournaldParamSocket       = "socket"
+	JournaldParamIdentifier   = "identifier"
+	JournaldParamFieldPrefix  = "fieldPrefix"
+	JournaldParamLevel        = "level"
+	JournaldConfigSocket      = "Socket"
+	JournaldConfigIdentifier  = "Identifier"
+	JournaldConfigFieldPrefix = "FieldPrefix"
+	JournaldConfigLevel       = "Level"
+	journaldDefaultSocket     = "/run/systemd/journal/socket"
+	// journaldMaxFieldName is the longest field name journald accepts.
+	journaldMaxFieldName = 64
+	// journaldClashPrefix is put in front of user fields named like the fields the sink
+	// or journald itself sets, such as `message` or `priority`.
+	journaldClashPrefix = "F_"
+)
+
+// journaldFields are the fields written by the sink or interpreted by journald.
+var journaldFields = map[string]bool{
+	"MESSAGE": true, "MESSAGE_ID": true, "PRIORITY": true, "SYSLOG_IDENTIFIER": true,
+	"SYSLOG_FACILITY": true, "SYSLOG_PID": true, "SYSLOG_TIMESTAMP": true, "LOGGER": true,
+	"CODE_FILE": true, "CODE_LINE": true, "CODE_FUNC": true, "STACKTRACE": true,
+	"ERRNO": true, "TID": true, "INVOCATION_ID": true, "DOCUMENTATION": true,
+}
+
+// priority maps zap levels to syslog priorities, as used by the PRIORITY field.
+func priority(lvl zapcore.Level) int {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel:
+		return 2
+	case zapcore.PanicLevel:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// fieldName converts key to a journald field name: uppercase letters, digits and
+// underscores, not starting with a digit or underscore, at most 64 characters.
+// Leading underscores are dropped since those names are reserved for trusted fields.
+func fieldName(prefix, key string) string {
+	var name = make([]byte, 0, len(prefix)+len(key))
+	for _, text := range []string{prefix, key} {
+		for i := 0; i < len(text); i++ {
+			switch c := text[i]; {
+			case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+				name = append(name, c)
+			case c >= 'a' && c <= 'z':
+				name = append(name, c-'a'+'A')
+			default:
+				name = append(name, '_')
+			}
+		}
+	}
+	for len(name) > 0 && name[0] == '_' {
+		name = name[1:]
+	}
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+		name = append([]byte("X_"), name...)
+	}
+	if len(name) > journaldMaxFieldName {
+		name = name[:journaldMaxFieldName]
+	}
+	return string(name)
+}
+
+// userFieldName is fieldName for user keys, names clashing with journaldFields
+// get journaldClashPrefix, so `message` cant replace the entry message.
+func userFieldName(prefix, key string) string {
+	var name = fieldName(prefix, key)
+	if journaldFields[name] {
+		name = fieldName(journaldClashPrefix, name)
+	}
+	return name
+}
+
+// fieldValue renders values of a zapcore.MapObjectEncoder, nested values as JSON.
+func fieldValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case time.Duration:
+		return value.String()
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr, float32, float64:
+		return fmt.Sprint(value)
+	default:
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+		return fmt.Sprint(value)
+	}
+}
+
+// appendField appends one field in the native protocol, values containing
+// newlines use the length prefixed binary form.
+func appendField(buf *buffer.Buffer, name, value string) {
+	if name == "" {
+		return
+	}
+	buf.AppendString(name)
+	if strings.IndexByte(value, '\n') < 0 {
+		buf.AppendByte('=')
+		buf.AppendString(value)
+		buf.AppendByte('\n')
+		return
+	}
+	buf.AppendByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	_, _ = buf.Write(size[:])
+	buf.AppendString(value)
+	buf.AppendByte('\n')
+}
+
+type journaldField struct {
+	name  string
+	value string
+}
+
+type journaldCore struct {
+	sink    *journaldSink
+	context []journaldField
+}
+
+func (core *journaldCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= core.sink.level
+}
+
+// encodeFields converts fields to journald fields in the order they were added.
+func (core *journaldCore) encodeFields(fields []zapcore.Field) []journaldField {
+	var enc = zapcore.NewMapObjectEncoder()
+	var encoded = make([]journaldField, 0, len(fields))
+	for _, field := range fields {
+		field.AddTo(enc)
+		if value, ok := enc.Fields[field.Key]; ok {
+			encoded = append(encoded, journaldField{name: userFieldName(core.sink.fieldPrefix, field.Key), value: fieldValue(value)})
+			delete(enc.Fields, field.Key)
+		}
+	}
+	return encoded
+}
+
+func (core *journaldCore) With(fields []zapcore.Field) zapcore.Core {
+	var context = make([]journaldField, 0, len(core.context)+len(fields))
+	context = append(context, core.context...)
+	return &journaldCore{sink: core.sink, context: append(context, core.encodeFields(fields)...)}
+}
+
+func (core *journaldCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry { // nolint:gocritic
+	if core.Enabled(ent.Level) {
+		return ce.AddCore(ent, core)
+	}
+	return ce
+}
+
+func (core *journaldCore) Write(e zapcore.Entry, fields []zapcore.Field) error { // nolint:gocritic
+	var buf = core.sink.pool.Get()
+	defer buf.Free()
+	appendField(buf, "MESSAGE", e.Message)
+	appendField(buf, "PRIORITY", strconv.Itoa(priority(e.Level)))
+	appendField(buf, "SYSLOG_IDENTIFIER", core.sink.identifier)
+	if e.LoggerName != "" {
+		appendField(buf, "LOGGER", e.LoggerName)
+	}
+	if e.Caller.Defined {
+		appendField(buf, "CODE_FILE", e.Caller.File)
+		appendField(buf, "CODE_LINE", strconv.Itoa(e.Caller.Line))
+		if e.Caller.Function != "" {
+			appendField(buf, "CODE_FUNC", e.Caller.Function)
+		}
+	}
+	if e.Stack != "" {
+		appendField(buf, "STACKTRACE", e.Stack)
+	}
+	for _, field := range core.context {
+		appendField(buf, field.name, field.value)
+	}
+	for _, field := range core.encodeFields(fields) {
+		appendField(buf, field.name, field.value)
+	}
+	_, err := core.sink.Write(buf.Bytes())
+	return err
+}
+
+func (core *journaldCore) Sync() error {
+	return nil
+}
+
+type journaldSink struct {
+	mu          sync.Mutex
+	socket      *net.UnixAddr
+	identifier  string
+	fieldPrefix string
+	level       zapcore.Level
+	conn        *net.UnixConn
+	pool        buffer.Pool
+}
+
+func (sink *journaldSink) HijackCore() zapcore.Core {
+	return &journaldCore{sink: sink}
+}
+
+// Write sends one native protocol datagram, datagrams over the socket
+// size limit are passed to journald as a file descriptor instead.
+func (sink *journaldSink) Write(p []byte) (n int, err error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn == nil {
+		// an unbound socket, so a restarted journald is reached without redialing
+		if sink.conn, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"}); err != nil {
+			return 0, fmt.Errorf("cant open journald socket: %w", err)
+		}
+	}
+	if n, _, err = sink.conn.WriteMsgUnix(p, nil, sink.socket); err == nil {
+		return n, nil
+	}
+	if errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS) {
+		if err = sendFile(sink.conn, sink.socket, p); err == nil {
+			return len(p), nil
+		}
+	}
+	return 0, fmt.Errorf("cant write journald entry to %s: %w", sink.socket.Name, err)
+}
+
+func (sink *journaldSink) Sync() error {
+	return nil
+}
+
+func (sink *journaldSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn == nil {
+		return nil
+	}
+	var err = sink.conn.Close()
+	sink.conn = nil
+	return err
+}
+
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var params = logPath.Query()
+	var _sink = &journaldSink{
+		socket:      &net.UnixAddr{Name: params.Get(JournaldParamSocket), Net: "unixgram"},
+		identifier:  params.Get(JournaldParamIdentifier),
+		fieldPrefix: params.Get(JournaldParamFieldPrefix),
+		pool:        buffer.NewPool(),
+	}
+	if _sink.socket.Name == "" {
+		_sink.socket.Name = journaldDefaultSocket
+	}
+	if _sink.identifier == "" {
+		_sink.identifier = filepath.Base(os.Args[0])
+	}
+	if levelVal := params.Get(JournaldParamLevel); levelVal != "" {
+		if err = _sink.level.UnmarshalText([]byte(levelVal)); err != nil {
+			return nil, fmt.Errorf("cant parse arg `%s`: %w", JournaldParamLevel, err)
+		}
+	} else {
+		_sink.level = zapcore.DebugLevel
+	}
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(JournaldSchema, register); err != nil {
+		panic(fmt.Errorf("cant register journald sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic      string
+	identifier string
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic:      topic,
+		identifier: g.identifier,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return JournaldSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: JournaldSchema, Host: "localhost"}
+	if socket, exist := argStore(JournaldConfigSocket); exist && socket != "" {
+		if !filepath.IsAbs(socket) {
+			return "", fmt.Errorf("`%s` should be an absolute path: %s", JournaldConfigSocket, socket)
+		}
+		outputQuery.Set(JournaldParamSocket, socket)
+	}
+	if identifier, exist := argStore(JournaldConfigIdentifier); exist && identifier != "" {
+		outputQuery.Set(JournaldParamIdentifier, identifier)
+	} else if g.identifier != "" {
+		outputQuery.Set(JournaldParamIdentifier, g.identifier)
+	}
+	if prefix, exist := argStore(JournaldConfigFieldPrefix); exist && prefix != "" {
+		if name := fieldName("", prefix); name != strings.ToUpper(prefix) {
+			return "", fmt.Errorf("invalid `%s`: %s", JournaldConfigFieldPrefix, prefix)
+		}
+		outputQuery.Set(JournaldParamFieldPrefix, prefix)
+	}
+	if levelVal, exist := argStore(JournaldConfigLevel); exist && levelVal != "" {
+		var lvl zapcore.Level
+		if err := lvl.UnmarshalText([]byte(levelVal)); err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", JournaldConfigLevel, err)
+		}
+		outputQuery.Set(JournaldParamLevel, levelVal)
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+func NewURLGenerator(identifier string) *urlGenerator {
+	return &urlGenerator{identifier: identifier}
+}
@@ -0,0 +1,169 @@
+//go:build linux
+// +build linux
+
+package sink_journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// listen opens a stand-in of the journald socket, the path stays below the sun_path limit.
+func listen(t *testing.T) (*net.UnixConn, string) {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "journald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	var socket = filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, socket
+}
+
+// openSink opens the sink of app sending to socket, fieldPrefix is set unless empty.
+func openSink(t *testing.T, socket, fieldPrefix string) (*zap.Logger, *journaldSink) {
+	t.Helper()
+	rawURL, err := NewURLGenerator("app").Generate(func(key string) (string, bool) {
+		switch key {
+		case JournaldConfigSocket:
+			return socket, true
+		case JournaldConfigFieldPrefix:
+			return fieldPrefix, fieldPrefix != ""
+		}
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_sink, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink = _sink.(*journaldSink)
+	return zap.New(sink.HijackCore(), zap.AddCaller()), sink
+}
+
+// receive reads one datagram and parses the native protocol, including the
+// binary form of values with newlines, raw holds the datagram itself.
+func receive(t *testing.T, conn *net.UnixConn) (fields map[string]string, raw []byte) {
+	t.Helper()
+	var buf = make([]byte, 64*1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = buf[:n]
+	fields = map[string]string{}
+	for data := raw; len(data) > 0; {
+		var eol = bytes.IndexByte(data, '\n')
+		if eol < 0 {
+			t.Fatalf("unterminated field %q", data)
+		}
+		if eq := bytes.IndexByte(data[:eol], '='); eq >= 0 {
+			fields[string(data[:eq])] = string(data[eq+1 : eol])
+			data = data[eol+1:]
+			continue
+		}
+		var name = string(data[:eol])
+		data = data[eol+1:]
+		if len(data) < 8 {
+			t.Fatalf("truncated size of %s", name)
+		}
+		var size = binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		if uint64(len(data)) < size+1 || data[size] != '\n' {
+			t.Fatalf("malformed binary value of %s", name)
+		}
+		fields[name], data = string(data[:size]), data[size+1:]
+	}
+	return fields, raw
+}
+
+func TestSinkFields(t *testing.T) {
+	var conn, socket = listen(t)
+	var log, sink = openSink(t, socket, "")
+	defer sink.Close()
+	log.Named("api").Warn("hello", zap.String("request-id", "r1"), zap.Int("status", 503))
+	var fields, _ = receive(t, conn)
+	for name, want := range map[string]string{
+		"MESSAGE":           "hello",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "app",
+		"LOGGER":            "api",
+		"REQUEST_ID":        "r1",
+		"STATUS":            "503",
+	} {
+		if fields[name] != want {
+			t.Errorf("%s = %q, want %q", name, fields[name], want)
+		}
+	}
+	if filepath.Base(fields["CODE_FILE"]) != "sink.journald_test.go" || fields["CODE_LINE"] == "" {
+		t.Errorf("unexpected caller %s:%s", fields["CODE_FILE"], fields["CODE_LINE"])
+	}
+}
+
+func TestSinkClashingKeys(t *testing.T) {
+	var conn, socket = listen(t)
+	var log, sink = openSink(t, socket, "")
+	defer sink.Close()
+	log.With(zap.String("syslog_identifier", "other")).
+		Error("real", zap.String("message", "fake"), zap.Int("priority", 7), zap.String("code_file", "x.go"))
+	var fields, _ = receive(t, conn)
+	for name, want := range map[string]string{
+		"MESSAGE":             "real",
+		"PRIORITY":            "3",
+		"SYSLOG_IDENTIFIER":   "app",
+		"F_MESSAGE":           "fake",
+		"F_PRIORITY":          "7",
+		"F_SYSLOG_IDENTIFIER": "other",
+		"F_CODE_FILE":         "x.go",
+	} {
+		if fields[name] != want {
+			t.Errorf("%s = %q, want %q", name, fields[name], want)
+		}
+	}
+
+	var prefixed, prefixedSink = openSink(t, socket, "APP_")
+	defer prefixedSink.Close()
+	prefixed.Info("prefixed", zap.String("message", "kept"))
+	fields, _ = receive(t, conn)
+	if fields["MESSAGE"] != "prefixed" || fields["APP_MESSAGE"] != "kept" {
+		t.Errorf("unexpected prefixed fields %v", fields)
+	}
+}
+
+func TestSinkBinaryFraming(t *testing.T) {
+	var conn, socket = listen(t)
+	var log, sink = openSink(t, socket, "")
+	defer sink.Close()
+	log.Info("line one\nline two", zap.String("single", "plain"))
+	var fields, raw = receive(t, conn)
+	if fields["MESSAGE"] != "line one\nline two" || fields["SINGLE"] != "plain" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	var want = append([]byte("MESSAGE\n\x11\x00\x00\x00\x00\x00\x00\x00"), "line one\nline two\n"...)
+	if !bytes.HasPrefix(raw, want) {
+		t.Errorf("datagram starts with %q, want %q", raw[:len(want)], want)
+	}
+	if !bytes.Contains(raw, []byte("\nSINGLE=plain\n")) {
+		t.Errorf("datagram %q lacks the plain form of SINGLE", raw)
+	}
+}
@@ -0,0 +1,15 @@
+module github.com/lipence/log-zap/sink/kafka
+
+go 1.17
+
+require (
+	github.com/segmentio/kafka-go v0.4.47
+	go.uber.org/zap v1.21.0
+)
+
+require (
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+)
@@ -0,0 +1,442 @@
+package sink_kafka
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	KafkaSchema             = "kafka"
+	KafkaParamBrokers       = "brokers"
+	KafkaParamTopic         = "topic"
+	KafkaParamKey           = "key"
+	KafkaParamAcks          = "acks"
+	KafkaParamCompression   = "compression"
+	KafkaParamAsync         = "async"
+	KafkaParamBatchSize     = "batchSize"
+	KafkaParamBatchBytes    = "batchBytes"
+	KafkaParamBatchTimeout  = "batchTimeout"
+	KafkaParamWriteTimeout  = "writeTimeout"
+	KafkaParamLevel         = "level"
+	KafkaParamProducer      = "producer"
+	KafkaConfigBrokers      = "Brokers"
+	KafkaConfigTopic        = "Topic"
+	KafkaConfigKey          = "Key"
+	KafkaConfigAcks         = "Acks"
+	KafkaConfigCompression  = "Compression"
+	KafkaConfigAsync        = "Async"
+	KafkaConfigBatchSize    = "BatchSize"
+	KafkaConfigBatchBytes   = "BatchBytes"
+	KafkaConfigBatchTimeout = "BatchTimeout"
+	KafkaConfigWriteTimeout = "WriteTimeout"
+	KafkaConfigLevel        = "Level"
+)
+
+var kafkaAcks = map[string]kafka.RequiredAcks{
+	"none":   kafka.RequireNone,
+	"0":      kafka.RequireNone,
+	"leader": kafka.RequireOne,
+	"one":    kafka.RequireOne,
+	"1":      kafka.RequireOne,
+	"all":    kafka.RequireAll,
+	"-1":     kafka.RequireAll,
+}
+
+var kafkaCompressions = map[string]kafka.Compression{
+	"none":   0,
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
+}
+
+// Producer is the part of *kafka.Writer used by the sink,
+// it can be replaced through urlGenerator.WithProducer.
+type Producer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// keyOf renders the value of field as message key.
+func keyOf(field zapcore.Field) []byte {
+	switch field.Type {
+	case zapcore.StringType:
+		return []byte(field.String)
+	case zapcore.ByteStringType, zapcore.BinaryType:
+		return field.Interface.([]byte)
+	case zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type:
+		return []byte(strconv.FormatInt(field.Integer, 10))
+	case zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type, zapcore.UintptrType:
+		return []byte(strconv.FormatUint(uint64(field.Integer), 10))
+	case zapcore.BoolType:
+		return []byte(strconv.FormatBool(field.Integer == 1))
+	case zapcore.Float64Type:
+		return []byte(strconv.FormatFloat(math.Float64frombits(uint64(field.Integer)), 'g', -1, 64))
+	default:
+		var enc = zapcore.NewMapObjectEncoder()
+		field.AddTo(enc)
+		return []byte(fmt.Sprint(enc.Fields[field.Key]))
+	}
+}
+
+type kafkaCore struct {
+	sink *kafkaSink
+	enc  zapcore.Encoder
+	key  []byte
+}
+
+func (core *kafkaCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= core.sink.level
+}
+
+func (core *kafkaCore) With(fields []zapcore.Field) zapcore.Core {
+	var clone = &kafkaCore{sink: core.sink, enc: core.enc.Clone(), key: core.key}
+	for _, field := range fields {
+		if core.sink.keyField != "" && field.Key == core.sink.keyField {
+			clone.key = keyOf(field)
+		}
+		field.AddTo(clone.enc)
+	}
+	return clone
+}
+
+func (core *kafkaCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry { // nolint:gocritic
+	if core.Enabled(ent.Level) {
+		return ce.AddCore(ent, core)
+	}
+	return ce
+}
+
+func (core *kafkaCore) Write(e zapcore.Entry, fields []zapcore.Field) error { // nolint:gocritic
+	var key = core.key
+	if core.sink.keyField != "" {
+		for _, field := range fields {
+			if field.Key == core.sink.keyField {
+				key = keyOf(field)
+			}
+		}
+	}
+	buf, err := core.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	var value = bytes.TrimSuffix(buf.Bytes(), []byte(zapcore.DefaultLineEnding))
+	var msg = kafka.Message{
+		Key:   key,
+		Value: append(make([]byte, 0, len(value)), value...),
+		Time:  e.Time,
+	}
+	buf.Free()
+	return core.sink.write(msg)
+}
+
+func (core *kafkaCore) Sync() error {
+	return core.sink.Sync()
+}
+
+type kafkaSink struct {
+	topic        string
+	keyField     string
+	level        zapcore.Level
+	writeTimeout time.Duration
+	producer     Producer
+	newEncoder   func() zapcore.Encoder
+	reporter     atomic.Value
+}
+
+func (sink *kafkaSink) HijackCore() zapcore.Core {
+	return &kafkaCore{sink: sink, enc: sink.newEncoder()}
+}
+
+func (sink *kafkaSink) AcceptReporter(reporter *zap.Logger) {
+	sink.reporter.Store(reporter)
+}
+
+func (sink *kafkaSink) AcceptEncoder(newEncoder func() zapcore.Encoder) {
+	sink.newEncoder = newEncoder
+}
+
+// AcceptConfig receives the producer set by urlGenerator.WithProducer.
+func (sink *kafkaSink) AcceptConfig(generator interface{}, _ func(string) (string, bool)) error {
+	if g, ok := generator.(*urlGenerator); ok && g.producer != nil {
+		sink.producer = g.producer
+	}
+	if sink.producer == nil {
+		return fmt.Errorf("undefined producer of `%s`", sink.topic)
+	}
+	return nil
+}
+
+func (sink *kafkaSink) report(count int, err error) {
+	if reporter, ok := sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error("kafka delivery failed",
+			zap.String("topic", sink.topic), zap.Int("messages", count), zap.Error(err))
+	}
+}
+
+// completion is the callback of async writers.
+func (sink *kafkaSink) completion(messages []kafka.Message, err error) {
+	if err != nil {
+		sink.report(len(messages), err)
+	}
+}
+
+// write hands msg to the producer, failures go to the rate limited reporter
+// instead of zap's error output, which would print one line per entry.
+func (sink *kafkaSink) write(msg kafka.Message) error {
+	if sink.producer == nil {
+		return fmt.Errorf("kafka producer not started")
+	}
+	var ctx, cancel = context.WithTimeout(context.Background(), sink.writeTimeout)
+	defer cancel()
+	if err := sink.producer.WriteMessages(ctx, msg); err != nil {
+		sink.report(1, err)
+	}
+	return nil
+}
+
+func (sink *kafkaSink) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("use *kafkaCore instead")
+}
+
+// Sync is a no-op, pending batches of async writers are flushed by Close.
+func (sink *kafkaSink) Sync() error {
+	return nil
+}
+
+func (sink *kafkaSink) Close() error {
+	if sink.producer == nil {
+		return nil
+	}
+	return sink.producer.Close()
+}
+
+func parsePositive(params url.Values, key string) (int, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return 0, nil
+	}
+	val, err := strconv.Atoi(text)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+func parseDuration(params url.Values, key string) (time.Duration, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return 0, nil
+	}
+	val, err := time.ParseDuration(text)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var params = logPath.Query()
+	var _sink = &kafkaSink{
+		topic:        params.Get(KafkaParamTopic),
+		keyField:     params.Get(KafkaParamKey),
+		level:        zapcore.DebugLevel,
+		writeTimeout: 10 * time.Second,
+		newEncoder: func() zapcore.Encoder {
+			return zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		},
+	}
+	// the producer set by urlGenerator.WithProducer arrives later through AcceptConfig
+	var customProducer = params.Get(KafkaParamProducer) == "custom"
+	if _sink.topic == "" {
+		return nil, fmt.Errorf("undefined arg `%s`", KafkaParamTopic)
+	}
+	if levelVal := params.Get(KafkaParamLevel); levelVal != "" {
+		if err = _sink.level.UnmarshalText([]byte(levelVal)); err != nil {
+			return nil, fmt.Errorf("cant parse arg `%s`: %w", KafkaParamLevel, err)
+		}
+	}
+	var writer = &kafka.Writer{
+		Topic:        _sink.topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Async:        params.Get(KafkaParamAsync) != "false",
+	}
+	// sync writers return their failures to write, which reports them
+	if writer.Async {
+		writer.Completion = _sink.completion
+	}
+	if brokers := params.Get(KafkaParamBrokers); brokers != "" {
+		writer.Addr = kafka.TCP(strings.Split(brokers, ",")...)
+	} else if !customProducer {
+		return nil, fmt.Errorf("undefined arg `%s`", KafkaParamBrokers)
+	}
+	if acksVal := params.Get(KafkaParamAcks); acksVal != "" {
+		var ok bool
+		if writer.RequiredAcks, ok = kafkaAcks[acksVal]; !ok {
+			return nil, fmt.Errorf("cant parse arg `%s`: %s", KafkaParamAcks, acksVal)
+		}
+	}
+	if compressionVal := params.Get(KafkaParamCompression); compressionVal != "" {
+		var ok bool
+		if writer.Compression, ok = kafkaCompressions[compressionVal]; !ok {
+			return nil, fmt.Errorf("cant parse arg `%s`: %s", KafkaParamCompression, compressionVal)
+		}
+	}
+	if writer.BatchSize, err = parsePositive(params, KafkaParamBatchSize); err != nil {
+		return nil, err
+	}
+	var batchBytes int
+	if batchBytes, err = parsePositive(params, KafkaParamBatchBytes); err != nil {
+		return nil, err
+	}
+	writer.BatchBytes = int64(batchBytes)
+	if writer.BatchTimeout, err = parseDuration(params, KafkaParamBatchTimeout); err != nil {
+		return nil, err
+	}
+	var writeTimeout time.Duration
+	if writeTimeout, err = parseDuration(params, KafkaParamWriteTimeout); err != nil {
+		return nil, err
+	} else if writeTimeout > 0 {
+		_sink.writeTimeout, writer.WriteTimeout = writeTimeout, writeTimeout
+	}
+	if !customProducer {
+		_sink.producer = writer
+	}
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(KafkaSchema, register); err != nil {
+		panic(fmt.Errorf("cant register kafka sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic    string
+	producer Producer
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic:    topic,
+		producer: g.producer,
+	}
+}
+
+// WithProducer replaces the *kafka.Writer built from config, e.g. with a mock in tests,
+// the sink closes it on logger sync.
+func (g *urlGenerator) WithProducer(producer Producer) *urlGenerator {
+	return &urlGenerator{
+		topic:    g.topic,
+		producer: producer,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return KafkaSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var ok bool
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: KafkaSchema, Host: "localhost"}
+	{
+		var brokers string
+		if brokers, ok = argStore(KafkaConfigBrokers); (!ok || brokers == "") && g.producer == nil {
+			return "", fmt.Errorf("`%s` not optional", KafkaConfigBrokers)
+		}
+		var brokerList []string
+		for _, broker := range strings.Split(brokers, ",") {
+			if broker = strings.TrimSpace(broker); broker != "" {
+				brokerList = append(brokerList, broker)
+			}
+		}
+		if len(brokerList) > 0 {
+			outputQuery.Set(KafkaParamBrokers, strings.Join(brokerList, ","))
+		}
+	}
+	{
+		var topic string
+		if topic, ok = argStore(KafkaConfigTopic); !ok || topic == "" {
+			return "", fmt.Errorf("`%s` not optional", KafkaConfigTopic)
+		}
+		outputQuery.Set(KafkaParamTopic, topic)
+	}
+	if key, exist := argStore(KafkaConfigKey); exist && key != "" {
+		outputQuery.Set(KafkaParamKey, key)
+	}
+	if acks, exist := argStore(KafkaConfigAcks); exist && acks != "" {
+		acks = strings.ToLower(strings.TrimSpace(acks))
+		if _, ok = kafkaAcks[acks]; !ok {
+			return "", fmt.Errorf("unsupported `%s`: %s", KafkaConfigAcks, acks)
+		}
+		outputQuery.Set(KafkaParamAcks, acks)
+	}
+	if compression, exist := argStore(KafkaConfigCompression); exist && compression != "" {
+		compression = strings.ToLower(strings.TrimSpace(compression))
+		if _, ok = kafkaCompressions[compression]; !ok {
+			return "", fmt.Errorf("unsupported `%s`: %s", KafkaConfigCompression, compression)
+		}
+		outputQuery.Set(KafkaParamCompression, compression)
+	}
+	if async, exist := argStore(KafkaConfigAsync); exist && async != "" {
+		var enabled, err = strconv.ParseBool(strings.TrimSpace(async))
+		if err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", KafkaConfigAsync, err)
+		}
+		outputQuery.Set(KafkaParamAsync, strconv.FormatBool(enabled))
+	}
+	for config, param := range map[string]string{
+		KafkaConfigBatchSize:    KafkaParamBatchSize,
+		KafkaConfigBatchBytes:   KafkaParamBatchBytes,
+		KafkaConfigBatchTimeout: KafkaParamBatchTimeout,
+		KafkaConfigWriteTimeout: KafkaParamWriteTimeout,
+		KafkaConfigLevel:        KafkaParamLevel,
+	} {
+		if val, exist := argStore(config); exist && val != "" {
+			outputQuery.Set(param, strings.TrimSpace(val))
+		}
+	}
+	if _, err := parsePositive(outputQuery, KafkaParamBatchSize); err != nil {
+		return "", err
+	}
+	if _, err := parsePositive(outputQuery, KafkaParamBatchBytes); err != nil {
+		return "", err
+	}
+	if _, err := parseDuration(outputQuery, KafkaParamBatchTimeout); err != nil {
+		return "", err
+	}
+	if _, err := parseDuration(outputQuery, KafkaParamWriteTimeout); err != nil {
+		return "", err
+	}
+	if levelVal := outputQuery.Get(KafkaParamLevel); levelVal != "" {
+		var lvl zapcore.Level
+		if err := lvl.UnmarshalText([]byte(levelVal)); err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", KafkaConfigLevel, err)
+		}
+	}
+	if g.producer != nil {
+		outputQuery.Set(KafkaParamProducer, "custom")
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+func NewURLGenerator() *urlGenerator {
+	return &urlGenerator{}
+}
@@ -0,0 +1,161 @@
+package sink_kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+
+	kafka "github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type mockProducer struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	err      error
+	closed   bool
+}
+
+func (p *mockProducer) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.messages = append(p.messages, msgs...)
+	return nil
+}
+
+func (p *mockProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+// registerSink registers the sink generated by g, producing to the topic logs
+// unless keys set another.
+func registerSink(t *testing.T, g *urlGenerator, keys map[string]string) *kafkaSink {
+	t.Helper()
+	rawURL, err := g.Generate(func(key string) (string, bool) {
+		if val, ok := keys[key]; ok || key != KafkaConfigTopic {
+			return val, ok
+		}
+		return "logs", true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_sink, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return _sink.(*kafkaSink)
+}
+
+// openSink opens a sink handing its messages to producer, with reports observed.
+func openSink(t *testing.T, producer Producer, keys map[string]string) (*zap.Logger, *kafkaSink, *observer.ObservedLogs) {
+	t.Helper()
+	var g = NewURLGenerator().WithProducer(producer)
+	var sink = registerSink(t, g, keys)
+	var reporterCore, reports = observer.New(zapcore.DebugLevel)
+	sink.AcceptReporter(zap.New(reporterCore))
+	if err := sink.AcceptConfig(g, nil); err != nil {
+		t.Fatal(err)
+	}
+	return zap.New(sink.HijackCore()), sink, reports
+}
+
+func TestSinkMessages(t *testing.T) {
+	var producer = &mockProducer{}
+	var log, sink, _ = openSink(t, producer, map[string]string{
+		KafkaConfigKey:   "user",
+		KafkaConfigLevel: "info",
+	})
+	log.Debug("dropped")
+	log.With(zap.String("user", "alice")).Info("from context")
+	log.Info("from entry", zap.Int("user", 42))
+	log.Info("without key")
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !producer.closed {
+		t.Error("producer not closed")
+	}
+	if len(producer.messages) != 3 {
+		t.Fatalf("got %d messages, want 3", len(producer.messages))
+	}
+	for i, want := range []struct{ key, msg string }{
+		{key: "alice", msg: "from context"},
+		{key: "42", msg: "from entry"},
+		{key: "", msg: "without key"},
+	} {
+		var msg = producer.messages[i]
+		var value map[string]interface{}
+		if err := json.Unmarshal(msg.Value, &value); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if string(msg.Key) != want.key || value["msg"] != want.msg || msg.Time.IsZero() {
+			t.Errorf("message %d: key %q, value %s", i, msg.Key, msg.Value)
+		}
+	}
+}
+
+func TestSinkReportsFailures(t *testing.T) {
+	var producer = &mockProducer{err: errors.New("broker down")}
+	var log, sink, reports = openSink(t, producer, nil)
+	defer sink.Close()
+	log.Info("lost")
+	var failed = reports.FilterMessage("kafka delivery failed").AllUntimed()
+	if len(failed) != 1 {
+		t.Fatalf("got %d failure reports, want 1", len(failed))
+	}
+	if fields := failed[0].ContextMap(); fields["topic"] != "logs" || fields["messages"] != int64(1) ||
+		fields["error"] != "broker down" {
+		t.Errorf("unexpected failure report %v", fields)
+	}
+}
+
+// TestWriterCompletion checks that only async writers report through Completion,
+// sync writers return the error to write which reports it already.
+func TestWriterCompletion(t *testing.T) {
+	for _, tc := range []struct {
+		async string
+		want  bool
+	}{
+		{async: "", want: true},
+		{async: "true", want: true},
+		{async: "false", want: false},
+	} {
+		var sink = registerSink(t, NewURLGenerator(), map[string]string{
+			KafkaConfigBrokers: "localhost:9092",
+			KafkaConfigAsync:   tc.async,
+		})
+		var writer = sink.producer.(*kafka.Writer)
+		if writer.Async != tc.want || (writer.Completion != nil) != tc.want {
+			t.Errorf("async %q: writer async %v, completion set %v", tc.async, writer.Async, writer.Completion != nil)
+		}
+		_ = sink.Close()
+	}
+}
+
+func TestCustomProducerRequired(t *testing.T) {
+	var sink = registerSink(t, NewURLGenerator().WithProducer(&mockProducer{}), nil)
+	if err := sink.AcceptConfig(NewURLGenerator(), nil); err == nil {
+		t.Error("sink accepted without producer")
+	}
+	if _, err := NewURLGenerator().Generate(func(key string) (string, bool) {
+		return "logs", key == KafkaConfigTopic
+	}); err == nil {
+		t.Error("generated without brokers or producer")
+	}
+}
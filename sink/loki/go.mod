@@ -0,0 +1,14 @@
+module github.com/lipence/log-zap/sink/loki
+
+go 1.17
+
+require (
+	github.com/golang/snappy v0.0.4
+	go.uber.org/zap v1.21.0
+	google.golang.org/protobuf v1.25.0
+)
+
+require (
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+)
@@ -0,0 +1,273 @@
+// Package lokitest provides an in-process stand-in of the Loki push api,
+// the loki sink can be pointed at it through the `URL` topic key.
+package lokitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const pushPath = "/loki/api/v1/push"
+
+// Entry is a log line received by Server.
+type Entry struct {
+	Time time.Time
+	Line string
+}
+
+// Stream is the entries of one push request sharing a label set.
+type Stream struct {
+	Tenant  string
+	Labels  map[string]string
+	Entries []Entry
+}
+
+type Server struct {
+	*httptest.Server
+	mu       sync.Mutex
+	streams  []Stream
+	status   int
+	requests uint64
+}
+
+func NewServer() *Server {
+	var s = &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Fail makes the following requests fail with status, zero restores success.
+func (s *Server) Fail(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// Requests returns the count of push requests received, including failed ones.
+func (s *Server) Requests() uint64 {
+	return atomic.LoadUint64(&s.requests)
+}
+
+// Streams returns the streams accepted so far, in arrival order.
+func (s *Server) Streams() []Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Stream(nil), s.streams...)
+}
+
+// Lines returns the line of every accepted entry, in arrival order.
+func (s *Server) Lines() []string {
+	var lines []string
+	for _, stream := range s.Streams() {
+		for _, entry := range stream.Entries {
+			lines = append(lines, entry.Line)
+		}
+	}
+	return lines
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	atomic.AddUint64(&s.requests, 1)
+	if r.Method != http.MethodPost || r.URL.Path != pushPath {
+		http.Error(w, r.Method+" "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	s.mu.Lock()
+	var status = s.status
+	s.mu.Unlock()
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var streams []Stream
+	switch contentType := r.Header.Get("Content-Type"); contentType {
+	case "application/x-protobuf":
+		streams, err = decodeProtobuf(body)
+	case "application/json":
+		streams, err = decodeJSON(body)
+	default:
+		err = fmt.Errorf("unsupported content type: %s", contentType)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i := range streams {
+		streams[i].Tenant = r.Header.Get("X-Scope-OrgID")
+	}
+	s.mu.Lock()
+	s.streams = append(s.streams, streams...)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeJSON(body []byte) ([]Stream, error) {
+	var request struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+	var streams = make([]Stream, 0, len(request.Streams))
+	for _, item := range request.Streams {
+		var stream = Stream{Labels: item.Stream}
+		for _, value := range item.Values {
+			ns, err := strconv.ParseInt(value[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp: %w", err)
+			}
+			stream.Entries = append(stream.Entries, Entry{Time: time.Unix(0, ns), Line: value[1]})
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+func decodeProtobuf(body []byte) ([]Stream, error) {
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("cant uncompress snappy body: %w", err)
+	}
+	var streams []Stream
+	err = eachField(raw, func(num protowire.Number, streamMsg []byte) error {
+		if num != 1 {
+			return nil
+		}
+		var stream Stream
+		return eachField(streamMsg, func(num protowire.Number, value []byte) (err error) {
+			switch num {
+			case 1:
+				if stream.Labels, err = parseLabels(string(value)); err == nil {
+					streams = append(streams, stream)
+				}
+				return err
+			case 2:
+				entry, err := decodeEntry(value)
+				if err == nil && len(streams) > 0 {
+					var last = &streams[len(streams)-1]
+					last.Entries = append(last.Entries, entry)
+				}
+				return err
+			}
+			return nil
+		})
+	})
+	return streams, err
+}
+
+func decodeEntry(msg []byte) (entry Entry, err error) {
+	var seconds, nanos int64
+	err = eachField(msg, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			return eachVarint(value, func(num protowire.Number, v uint64) {
+				if num == 1 {
+					seconds = int64(v)
+				} else if num == 2 {
+					nanos = int64(v)
+				}
+			})
+		case 2:
+			entry.Line = string(value)
+		}
+		return nil
+	})
+	entry.Time = time.Unix(seconds, nanos)
+	return entry, err
+}
+
+// eachField calls fn with every length delimited field of msg.
+func eachField(msg []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, msg); n < 0 {
+				return protowire.ParseError(n)
+			}
+			msg = msg[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachVarint calls fn with every varint field of msg.
+func eachVarint(msg []byte, fn func(num protowire.Number, value uint64)) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		if typ == protowire.VarintType {
+			value, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(num, value)
+			msg = msg[n:]
+			continue
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, msg); n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+	}
+	return nil
+}
+
+// parseLabels parses labels in format `{key="value", key2="value2"}`.
+func parseLabels(text string) (map[string]string, error) {
+	var labels = make(map[string]string)
+	if !strings.HasPrefix(text, "{") || !strings.HasSuffix(text, "}") {
+		return nil, fmt.Errorf("invalid labels: %s", text)
+	}
+	var rest = strings.TrimSpace(text[1 : len(text)-1])
+	for rest != "" {
+		var eq = strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid labels: %s", text)
+		}
+		var key = strings.TrimSpace(rest[:eq])
+		quoted, err := strconv.QuotedPrefix(rest[eq+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels: %s", text)
+		}
+		if labels[key], err = strconv.Unquote(quoted); err != nil {
+			return nil, fmt.Errorf("invalid labels: %s", text)
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest[eq+1+len(quoted):]), ","))
+	}
+	return labels, nil
+}
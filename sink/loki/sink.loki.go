@@ -0,0 +1,569 @@
+package sink_loki
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	LokiSchema               = "loki"
+	LokiPushPath             = "/loki/api/v1/push"
+	LokiParamTarget          = "target"
+	LokiParamEncoding        = "encoding"
+	LokiParamLabels          = "labels"
+	LokiParamLabelLevel      = "labelLevel"
+	LokiParamLabelLogger     = "labelLogger"
+	LokiParamMaxLoggerNames  = "maxLoggerNames"
+	LokiParamBatchCount      = "batchCount"
+	LokiParamBatchBytes      = "batchBytes"
+	LokiParamInterval        = "interval"
+	LokiParamQueueSize       = "queueSize"
+	LokiParamRetries         = "retries"
+	LokiParamTimeout         = "timeout"
+	LokiParamLevel           = "level"
+	LokiConfigURL            = "URL"
+	LokiConfigEncoding       = "Encoding"
+	LokiConfigLabels         = "Labels"
+	LokiConfigLabelLevel     = "LabelLevel"
+	LokiConfigLabelLogger    = "LabelLogger"
+	LokiConfigMaxLoggerNames = "MaxLoggerNames"
+	LokiConfigBatchCount     = "BatchCount"
+	LokiConfigBatchBytes     = "BatchBytes"
+	LokiConfigInterval       = "FlushInterval"
+	LokiConfigQueueSize      = "QueueSize"
+	LokiConfigRetries        = "Retries"
+	LokiConfigTimeout        = "Timeout"
+	LokiConfigLevel          = "Level"
+	LokiConfigTenantID       = "TenantID"
+	LokiConfigBasicUser      = "BasicAuthUser"
+	LokiConfigBasicPass      = "BasicAuthPassword" // #nosec G101
+)
+
+const (
+	// maxLabelNames follows the default max_label_names_per_series of Loki.
+	maxLabelNames         = 15
+	otherLoggerName       = "other"
+	defaultMaxLoggerNames = 32
+	defaultBatchCount     = 1000
+	defaultBatchBytes     = 1024 * 1024
+	defaultInterval       = time.Second
+	defaultQueueSize      = 8192
+	defaultRetries        = 3
+	defaultTimeout        = 10 * time.Second
+	retryBackoffBase      = 500 * time.Millisecond
+	retryBackoffMaximum   = 30 * time.Second
+)
+
+type lokiCore struct {
+	sink *lokiSink
+	enc  zapcore.Encoder
+}
+
+func (core *lokiCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= core.sink.level
+}
+
+func (core *lokiCore) With(fields []zapcore.Field) zapcore.Core {
+	var enc = core.enc.Clone()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	return &lokiCore{sink: core.sink, enc: enc}
+}
+
+func (core *lokiCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry { // nolint:gocritic
+	if core.Enabled(ent.Level) {
+		return ce.AddCore(ent, core)
+	}
+	return ce
+}
+
+func (core *lokiCore) Write(e zapcore.Entry, fields []zapcore.Field) error { // nolint:gocritic
+	buf, err := core.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	core.sink.enqueue(lokiEntry{
+		stream: core.sink.stream(e.Level, e.LoggerName),
+		ts:     e.Time.UnixNano(),
+		line:   strings.TrimSuffix(buf.String(), zapcore.DefaultLineEnding),
+	})
+	buf.Free()
+	return nil
+}
+
+func (core *lokiCore) Sync() error {
+	return core.sink.Sync()
+}
+
+// lokiSink batches entries and pushes them from a single goroutine,
+// entries are dropped when the queue is full, so logging never blocks on Loki.
+type lokiSink struct {
+	target         string
+	encoding       string
+	labels         map[string]string
+	labelLevel     bool
+	labelLogger    bool
+	maxLoggerNames int
+	batchCount     int
+	batchBytes     int
+	interval       time.Duration
+	retries        int
+	level          zapcore.Level
+	headers        http.Header
+	newEncoder     func() zapcore.Encoder
+	client         *http.Client
+	streams        sync.Map // level and logger name => rendered labels
+	streamLabels   sync.Map // rendered labels => labels
+	loggersMu      sync.Mutex
+	loggers        map[string]struct{}
+	queue          chan lokiEntry
+	flush          chan chan struct{}
+	stop           chan struct{}
+	done           chan struct{}
+	closeOnce      sync.Once
+	dropped        uint64
+	reporter       atomic.Value
+}
+
+func (sink *lokiSink) HijackCore() zapcore.Core {
+	return &lokiCore{sink: sink, enc: sink.newEncoder()}
+}
+
+func (sink *lokiSink) AcceptReporter(reporter *zap.Logger) {
+	sink.reporter.Store(reporter)
+}
+
+func (sink *lokiSink) AcceptEncoder(newEncoder func() zapcore.Encoder) {
+	sink.newEncoder = newEncoder
+}
+
+// AcceptConfig receives the tenant and credentials, so they never appear in the sink url.
+func (sink *lokiSink) AcceptConfig(_ interface{}, argStore func(string) (string, bool)) error {
+	sink.headers = parseSecrets(argStore)
+	return nil
+}
+
+func (sink *lokiSink) report(msg string, fields ...zap.Field) {
+	if reporter, ok := sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error(msg, append(fields, zap.String("target", sink.target))...)
+	}
+}
+
+// loggerLabel bounds the distinct values of the logger label,
+// names beyond maxLoggerNames share the label value "other".
+func (sink *lokiSink) loggerLabel(name string) string {
+	sink.loggersMu.Lock()
+	defer sink.loggersMu.Unlock()
+	if _, exist := sink.loggers[name]; exist {
+		return name
+	}
+	if len(sink.loggers) >= sink.maxLoggerNames {
+		return otherLoggerName
+	}
+	sink.loggers[name] = struct{}{}
+	return name
+}
+
+// stream returns the rendered labels of entries with lvl and loggerName.
+func (sink *lokiSink) stream(lvl zapcore.Level, loggerName string) string {
+	var cacheKey = lvl.String() + "\x00" + loggerName
+	if stream, ok := sink.streams.Load(cacheKey); ok {
+		return stream.(string)
+	}
+	var labels = make(map[string]string, len(sink.labels)+2)
+	for key, val := range sink.labels {
+		labels[key] = val
+	}
+	if sink.labelLevel {
+		labels["level"] = lvl.String()
+	}
+	if sink.labelLogger && loggerName != "" {
+		labels["logger"] = sink.loggerLabel(loggerName)
+	}
+	var stream = renderLabels(labels)
+	sink.streamLabels.LoadOrStore(stream, labels)
+	sink.streams.Store(cacheKey, stream)
+	return stream
+}
+
+func (sink *lokiSink) labelsOf(stream string) map[string]string {
+	if labels, ok := sink.streamLabels.Load(stream); ok {
+		return labels.(map[string]string)
+	}
+	return map[string]string{}
+}
+
+func (sink *lokiSink) enqueue(entry lokiEntry) {
+	select {
+	case sink.queue <- entry:
+	default:
+		if dropped := atomic.AddUint64(&sink.dropped, 1); dropped&(dropped-1) == 0 {
+			sink.report("loki queue full, entries dropped", zap.Uint64("dropped", dropped))
+		}
+	}
+}
+
+func (sink *lokiSink) run() {
+	defer close(sink.done)
+	var ticker = time.NewTicker(sink.interval)
+	defer ticker.Stop()
+	var batch []lokiEntry
+	var batchBytes int
+	var add = func(entry lokiEntry) {
+		batch, batchBytes = append(batch, entry), batchBytes+len(entry.line)
+		if len(batch) >= sink.batchCount || batchBytes >= sink.batchBytes {
+			sink.push(batch)
+			batch, batchBytes = nil, 0
+		}
+	}
+	var drain = func() {
+		for {
+			select {
+			case entry := <-sink.queue:
+				add(entry)
+			default:
+				if len(batch) > 0 {
+					sink.push(batch)
+					batch, batchBytes = nil, 0
+				}
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case entry := <-sink.queue:
+			add(entry)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				sink.push(batch)
+				batch, batchBytes = nil, 0
+			}
+		case flushed := <-sink.flush:
+			drain()
+			close(flushed)
+		case <-sink.stop:
+			drain()
+			return
+		}
+	}
+}
+
+// push sends a batch, retrying network errors, 429 and 5xx responses with exponential backoff.
+func (sink *lokiSink) push(batch []lokiEntry) {
+	var body []byte
+	var contentType string
+	if sink.encoding == LokiEncodingJSON {
+		var err error
+		if body, err = encodeJSON(batch, sink.labelsOf); err != nil {
+			sink.report("loki cant encode batch", zap.Error(err))
+			return
+		}
+		contentType = "application/json"
+	} else {
+		body, contentType = encodeProtobuf(batch), "application/x-protobuf"
+	}
+	var err error
+	var backoff = retryBackoffBase
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		if retryable, err = sink.do(body, contentType); err == nil {
+			return
+		}
+		if !retryable || attempt >= sink.retries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-sink.stop:
+			// closing, use the remaining attempts without waiting
+		}
+		if backoff *= 2; backoff > retryBackoffMaximum {
+			backoff = retryBackoffMaximum
+		}
+	}
+	sink.report("loki push failed", zap.Int("entries", len(batch)), zap.Error(err))
+}
+
+func (sink *lokiSink) do(body []byte, contentType string) (retryable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, sink.target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, values := range sink.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := sink.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		fmt.Errorf("unexpected response status: %s: %s", resp.Status, bytes.TrimSpace(message))
+}
+
+func (sink *lokiSink) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("use *lokiCore instead")
+}
+
+// Sync waits until the entries queued so far are pushed.
+func (sink *lokiSink) Sync() error {
+	var flushed = make(chan struct{})
+	select {
+	case sink.flush <- flushed:
+		<-flushed
+	case <-sink.done:
+	}
+	return nil
+}
+
+func (sink *lokiSink) Close() error {
+	sink.closeOnce.Do(func() {
+		close(sink.stop)
+		<-sink.done
+	})
+	return nil
+}
+
+// parseLabels parses static labels in format `key=value,key2=value2`.
+func parseLabels(text string) (map[string]string, error) {
+	var labels = make(map[string]string)
+	for _, pair := range strings.Split(text, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		var kv = strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("cant parse label `%s`: expect key=value", pair)
+		}
+		var key = strings.TrimSpace(kv[0])
+		if !validLabelName(key) || strings.HasPrefix(key, "__") {
+			return nil, fmt.Errorf("invalid label name `%s`", key)
+		}
+		labels[key] = strings.TrimSpace(kv[1])
+	}
+	return labels, nil
+}
+
+func parseCount(params url.Values, key string, def, min int) (int, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := strconv.Atoi(text)
+	if err != nil || val < min {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+func parseDuration(params url.Values, key string, def time.Duration) (time.Duration, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := time.ParseDuration(text)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+// parseOptions parses the url params shared by urlGenerator.Generate and register.
+func parseOptions(params url.Values, sink *lokiSink) (queueSize int, timeout time.Duration, err error) {
+	if sink.labels, err = parseLabels(params.Get(LokiParamLabels)); err != nil {
+		return 0, 0, err
+	}
+	sink.labelLevel = params.Get(LokiParamLabelLevel) != "false"
+	sink.labelLogger = params.Get(LokiParamLabelLogger) != "false"
+	var labelNames = len(sink.labels)
+	if _, exist := sink.labels["level"]; sink.labelLevel && !exist {
+		labelNames++
+	}
+	if _, exist := sink.labels["logger"]; sink.labelLogger && !exist {
+		labelNames++
+	}
+	if labelNames > maxLabelNames {
+		return 0, 0, fmt.Errorf("too many labels: %d, at most %d", labelNames, maxLabelNames)
+	}
+	if sink.maxLoggerNames, err = parseCount(params, LokiParamMaxLoggerNames, defaultMaxLoggerNames, 1); err != nil {
+		return 0, 0, err
+	}
+	if sink.batchCount, err = parseCount(params, LokiParamBatchCount, defaultBatchCount, 1); err != nil {
+		return 0, 0, err
+	}
+	if sink.batchBytes, err = parseCount(params, LokiParamBatchBytes, defaultBatchBytes, 1); err != nil {
+		return 0, 0, err
+	}
+	if queueSize, err = parseCount(params, LokiParamQueueSize, defaultQueueSize, 1); err != nil {
+		return 0, 0, err
+	}
+	if sink.retries, err = parseCount(params, LokiParamRetries, defaultRetries, 0); err != nil {
+		return 0, 0, err
+	}
+	if sink.interval, err = parseDuration(params, LokiParamInterval, defaultInterval); err != nil {
+		return 0, 0, err
+	}
+	if timeout, err = parseDuration(params, LokiParamTimeout, defaultTimeout); err != nil {
+		return 0, 0, err
+	}
+	sink.level = zapcore.DebugLevel
+	if levelVal := params.Get(LokiParamLevel); levelVal != "" {
+		if err = sink.level.UnmarshalText([]byte(levelVal)); err != nil {
+			return 0, 0, fmt.Errorf("cant parse arg `%s`: %w", LokiParamLevel, err)
+		}
+	}
+	return queueSize, timeout, nil
+}
+
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var params = logPath.Query()
+	var _sink = &lokiSink{
+		target:   params.Get(LokiParamTarget),
+		encoding: params.Get(LokiParamEncoding),
+		headers:  http.Header{},
+		newEncoder: func() zapcore.Encoder {
+			return zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		},
+		loggers: make(map[string]struct{}),
+		flush:   make(chan chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if _sink.target == "" {
+		return nil, fmt.Errorf("undefined arg `%s`", LokiParamTarget)
+	}
+	var queueSize int
+	var timeout time.Duration
+	if queueSize, timeout, err = parseOptions(params, _sink); err != nil {
+		return nil, err
+	}
+	_sink.client = &http.Client{Timeout: timeout}
+	_sink.queue = make(chan lokiEntry, queueSize)
+	go _sink.run()
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(LokiSchema, register); err != nil {
+		panic(fmt.Errorf("cant register loki sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic string
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic: topic,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return LokiSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var ok bool
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: LokiSchema, Host: "localhost"}
+	{
+		var target string
+		if target, ok = argStore(LokiConfigURL); !ok || target == "" {
+			return "", fmt.Errorf("`%s` not optional", LokiConfigURL)
+		}
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", LokiConfigURL, err)
+		} else if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+			return "", fmt.Errorf("unsupported `%s` scheme: %s", LokiConfigURL, targetURL.Scheme)
+		} else if targetURL.User != nil {
+			return "", fmt.Errorf("use `%s`/`%s` instead of userinfo in `%s`",
+				LokiConfigBasicUser, LokiConfigBasicPass, LokiConfigURL)
+		}
+		if !strings.HasSuffix(targetURL.Path, LokiPushPath) {
+			targetURL.Path = strings.TrimSuffix(targetURL.Path, "/") + LokiPushPath
+		}
+		outputQuery.Set(LokiParamTarget, targetURL.String())
+	}
+	if encoding, exist := argStore(LokiConfigEncoding); exist && encoding != "" {
+		switch encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding {
+		case LokiEncodingProtobuf, LokiEncodingJSON:
+			outputQuery.Set(LokiParamEncoding, encoding)
+		default:
+			return "", fmt.Errorf("unsupported `%s`: %s", LokiConfigEncoding, encoding)
+		}
+	}
+	for config, param := range map[string]string{
+		LokiConfigLabelLevel:  LokiParamLabelLevel,
+		LokiConfigLabelLogger: LokiParamLabelLogger,
+	} {
+		if val, exist := argStore(config); exist && val != "" {
+			var enabled, err = strconv.ParseBool(strings.TrimSpace(val))
+			if err != nil {
+				return "", fmt.Errorf("cant parse `%s`: %w", config, err)
+			}
+			outputQuery.Set(param, strconv.FormatBool(enabled))
+		}
+	}
+	for config, param := range map[string]string{
+		LokiConfigLabels:         LokiParamLabels,
+		LokiConfigMaxLoggerNames: LokiParamMaxLoggerNames,
+		LokiConfigBatchCount:     LokiParamBatchCount,
+		LokiConfigBatchBytes:     LokiParamBatchBytes,
+		LokiConfigInterval:       LokiParamInterval,
+		LokiConfigQueueSize:      LokiParamQueueSize,
+		LokiConfigRetries:        LokiParamRetries,
+		LokiConfigTimeout:        LokiParamTimeout,
+		LokiConfigLevel:          LokiParamLevel,
+	} {
+		if val, exist := argStore(config); exist && val != "" {
+			outputQuery.Set(param, strings.TrimSpace(val))
+		}
+	}
+	if _, _, err := parseOptions(outputQuery, &lokiSink{}); err != nil {
+		return "", err
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+// parseSecrets builds the headers sent with every push, including the tenant and credentials.
+func parseSecrets(argStore func(string) (string, bool)) http.Header {
+	var headers = http.Header{}
+	if tenant, exist := argStore(LokiConfigTenantID); exist && tenant != "" {
+		headers.Set("X-Scope-OrgID", tenant)
+	}
+	if user, exist := argStore(LokiConfigBasicUser); exist && user != "" {
+		var password, _ = argStore(LokiConfigBasicPass)
+		var req = &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(user, password)
+		headers.Set("Authorization", req.Header.Get("Authorization"))
+	}
+	return headers
+}
+
+func NewURLGenerator() *urlGenerator {
+	return &urlGenerator{}
+}
@@ -0,0 +1,122 @@
+package sink_loki
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	LokiEncodingProtobuf = "protobuf"
+	LokiEncodingJSON     = "json"
+)
+
+type lokiEntry struct {
+	stream string
+	ts     int64
+	line   string
+}
+
+// renderLabels formats labels the way Loki parses stream selectors, keys sorted.
+func renderLabels(labels map[string]string) string {
+	var keys = make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteString(key)
+		builder.WriteByte('=')
+		builder.WriteString(strconv.Quote(labels[key]))
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+// validLabelName reports whether name matches [a-zA-Z_][a-zA-Z0-9_]*.
+func validLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// groupStreams groups entries by stream, keeping the order streams first appear in.
+func groupStreams(batch []lokiEntry) (streams []string, entries map[string][]lokiEntry) {
+	entries = make(map[string][]lokiEntry)
+	for _, entry := range batch {
+		if _, exist := entries[entry.stream]; !exist {
+			streams = append(streams, entry.stream)
+		}
+		entries[entry.stream] = append(entries[entry.stream], entry)
+	}
+	return streams, entries
+}
+
+// encodeProtobuf builds a snappy compressed logproto.PushRequest:
+//
+//	PushRequest  { repeated Stream streams = 1; }
+//	Stream       { string labels = 1; repeated Entry entries = 2; }
+//	Entry        { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func encodeProtobuf(batch []lokiEntry) []byte {
+	var streams, entries = groupStreams(batch)
+	var request []byte
+	for _, stream := range streams {
+		var streamMsg = protowire.AppendTag(nil, 1, protowire.BytesType)
+		streamMsg = protowire.AppendString(streamMsg, stream)
+		for _, entry := range entries[stream] {
+			var timestamp []byte
+			timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+			timestamp = protowire.AppendVarint(timestamp, uint64(entry.ts/1e9))
+			timestamp = protowire.AppendTag(timestamp, 2, protowire.VarintType)
+			timestamp = protowire.AppendVarint(timestamp, uint64(entry.ts%1e9))
+			var entryMsg = protowire.AppendTag(nil, 1, protowire.BytesType)
+			entryMsg = protowire.AppendBytes(entryMsg, timestamp)
+			entryMsg = protowire.AppendTag(entryMsg, 2, protowire.BytesType)
+			entryMsg = protowire.AppendString(entryMsg, entry.line)
+			streamMsg = protowire.AppendTag(streamMsg, 2, protowire.BytesType)
+			streamMsg = protowire.AppendBytes(streamMsg, entryMsg)
+		}
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, streamMsg)
+	}
+	return snappy.Encode(nil, request)
+}
+
+type jsonStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// encodeJSON builds the JSON push body, stream labels are kept as a map beside the entries.
+func encodeJSON(batch []lokiEntry, labels func(stream string) map[string]string) ([]byte, error) {
+	var streams, entries = groupStreams(batch)
+	var body = struct {
+		Streams []jsonStream `json:"streams"`
+	}{Streams: make([]jsonStream, 0, len(streams))}
+	for _, stream := range streams {
+		var values = make([][2]string, 0, len(entries[stream]))
+		for _, entry := range entries[stream] {
+			values = append(values, [2]string{strconv.FormatInt(entry.ts, 10), entry.line})
+		}
+		body.Streams = append(body.Streams, jsonStream{Stream: labels(stream), Values: values})
+	}
+	return json.Marshal(body)
+}
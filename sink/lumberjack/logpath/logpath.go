@@ -0,0 +1,44 @@
+// Package logpath resolves and validates the paths and modes of log files,
+// shared by the sinks writing to the local filesystem.
+package logpath
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Target resolves target against base, absolute targets are kept as they are.
+func Target(base string, target string) string {
+	if filepath.IsAbs(target) {
+		return filepath.Clean(target)
+	}
+	return filepath.Clean(filepath.Join(base, target))
+}
+
+// Check rejects relative targets that climb out of base with `..`
+func Check(base string, target string) error {
+	if filepath.IsAbs(target) {
+		return nil
+	}
+	if rel, err := filepath.Rel(base, Target(base, target)); err != nil {
+		return fmt.Errorf("cant resolve log path `%s`: %w", target, err)
+	} else if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("log path `%s` escapes base directory `%s`", target, base)
+	}
+	return nil
+}
+
+// ParseMode parses an octal permission mode like `0640`.
+func ParseMode(text string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(text, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if mode&^uint64(os.ModePerm) != 0 {
+		return 0, fmt.Errorf("mode `%s` out of permission range", text)
+	}
+	return os.FileMode(mode), nil
+}
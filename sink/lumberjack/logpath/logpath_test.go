@@ -0,0 +1,39 @@
+package logpath
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	var base = filepath.Join(string(filepath.Separator), "var", "log")
+	for target, escapes := range map[string]bool{
+		"app.log":                  false,
+		"app/../app.log":           false,
+		"../app.log":               true,
+		"app/../../app.log":        true,
+		"..app.log":                false,
+		filepath.Join(base, "..x"): false,
+	} {
+		if err := Check(base, target); (err != nil) != escapes {
+			t.Errorf("Check(%q) = %v", target, err)
+		}
+	}
+	if got := Target(base, "app/../app.log"); got != filepath.Join(base, "app.log") {
+		t.Errorf("Target resolved %s", got)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for text, want := range map[string]os.FileMode{"0640": 0640, "600": 0600, "0777": 0777} {
+		if mode, err := ParseMode(text); err != nil || mode != want {
+			t.Errorf("ParseMode(%q) = %v, %v", text, mode, err)
+		}
+	}
+	for _, text := range []string{"", "0648", "1777", "rw-r--r--"} {
+		if _, err := ParseMode(text); err == nil {
+			t.Errorf("ParseMode(%q) accepted", text)
+		}
+	}
+}
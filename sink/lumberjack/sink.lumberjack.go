@@ -3,11 +3,18 @@ package sink_lumberjack
 import (
 	"fmt"
 	"net/url"
+	"os"
+	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/lipence/log-zap/sink/lumberjack/logpath"
 )
 
 const (
@@ -23,19 +30,227 @@ const (
 	LumberjackConfigMaxAge     = "MaxAge"
 )
 
+const (
+	LumberjackSplitByLevel          = "level"
+	LumberjackParamSplitBy          = "splitBy"
+	LumberjackParamSplitLevel       = "splitLevel"
+	LumberjackParamSplitPath        = "splitPath"
+	LumberjackParamSplitMaxSize     = "splitMaxSize"
+	LumberjackParamSplitMaxBackups  = "splitMaxBackups"
+	LumberjackParamSplitMaxAge      = "splitMaxAge"
+	LumberjackConfigSplitBy         = "SplitBy"
+	LumberjackConfigSplitLevel      = "SplitLevel"
+	LumberjackConfigSplitPath       = "SplitPath"
+	LumberjackConfigSplitMaxSize    = "SplitMaxSize"
+	LumberjackConfigSplitMaxBackups = "SplitMaxBackups"
+	LumberjackConfigSplitMaxAge     = "SplitMaxAge"
+)
+
+const (
+	LumberjackParamFileMode    = "fileMode"
+	LumberjackParamDirMode     = "dirMode"
+	LumberjackParamCreateDirs  = "createDirs"
+	LumberjackParamFileOwner   = "fileOwner"
+	LumberjackParamFileGroup   = "fileGroup"
+	LumberjackConfigFileMode   = "FileMode"
+	LumberjackConfigDirMode    = "DirMode"
+	LumberjackConfigCreateDirs = "CreateDirs"
+	LumberjackConfigFileOwner  = "FileOwner"
+	LumberjackConfigFileGroup  = "FileGroup"
+	// LumberjackConfigTopicName is answered by the logger with the topic prefix.
+	LumberjackConfigTopicName = "TopicName"
+)
+
+// lumberjackDirMode is the mode lumberjack creates missing directories with.
+const lumberjackDirMode = 0744
+
 type lumberjackSink struct {
 	*lumberjack.Logger
 }
 
+// fileOptions applies to log files and the directories created for them,
+// uid and gid are -1 when ownership is left to the process.
+type fileOptions struct {
+	fileMode   os.FileMode
+	dirMode    os.FileMode
+	createDirs bool
+	uid        int
+	gid        int
+}
+
 func (lumberjackSink) Sync() error {
 	return nil
 }
 
-func targetPath(base string, target string) string {
-	if filepath.IsAbs(target) {
-		return filepath.Clean(target)
+// lumberjackSplitSink writes every entry to the main file, and entries above
+// the split level to an extra file with its own rotation settings.
+type lumberjackSplitSink struct {
+	zap.Sink
+	level zapcore.Level
+	split zap.Sink
+}
+
+func (sink lumberjackSplitSink) SplitLevel() (zapcore.LevelEnabler, zapcore.WriteSyncer) {
+	return zap.LevelEnablerFunc(func(lvl zapcore.Level) bool { return lvl >= sink.level }), sink.split
+}
+
+func (sink lumberjackSplitSink) Close() error {
+	var err = sink.Sink.Close()
+	if _err := sink.split.Close(); err == nil {
+		err = _err
+	}
+	return err
+}
+
+// prepare creates the log file and its directory upfront, lumberjack keeps
+// the mode and owner of an existing file across rotations. Directories are
+// created with the mode lumberjack uses, createDirs applies dirMode and the owner to them.
+func (o fileOptions) prepare(filename string) error {
+	var dir = filepath.Dir(filename)
+	if o.createDirs {
+		if err := o.mkdirAll(dir); err != nil {
+			return fmt.Errorf("cant create log directory: %w", err)
+		}
+	} else if err := os.MkdirAll(dir, lumberjackDirMode); err != nil {
+		return fmt.Errorf("cant create log directory: %w", err)
+	}
+	if o.fileMode == 0 && o.uid == -1 && o.gid == -1 {
+		return nil
+	}
+	var mode = o.fileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return fmt.Errorf("cant create log file: %w", err)
+	}
+	if o.fileMode != 0 {
+		if err = file.Chmod(o.fileMode); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("cant chmod log file: %w", err)
+		}
+	}
+	if o.uid != -1 || o.gid != -1 {
+		if err = file.Chown(o.uid, o.gid); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("cant chown log file: %w", err)
+		}
+	}
+	return file.Close()
+}
+
+// mkdirAll creates the missing directories of dir with dirMode and the owner of
+// log files; the mode is set by chmod, so it is not masked by umask.
+func (o fileOptions) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("`%s` is not a directory", dir)
+		}
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := o.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, o.dirMode); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Chmod(dir, o.dirMode); err != nil {
+		return err
+	}
+	if o.uid != -1 || o.gid != -1 {
+		return os.Chown(dir, o.uid, o.gid)
+	}
+	return nil
+}
+
+// lookupID resolves a user or group name, numeric ids are used as is.
+func lookupID(text string, lookup func(string) (string, error)) (int, error) {
+	if text = strings.TrimSpace(text); text == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(text); err == nil {
+		if id < 0 {
+			return 0, fmt.Errorf("negative id %d", id)
+		}
+		return id, nil
+	}
+	id, err := lookup(text)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+func lookupUser(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+func lookupGroup(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
+
+// open creates a sink for filename, paths with date placeholders get a sink
+// that follows the date.
+func (o fileOptions) open(filename string, maxSize, maxBackups, maxAge int) (zap.Sink, error) {
+	var newLogger = func(filename string) *lumberjack.Logger {
+		return &lumberjack.Logger{Filename: filename, MaxSize: maxSize, MaxBackups: maxBackups, MaxAge: maxAge}
+	}
+	if hasDate(filename) {
+		var sink = &datedSink{pattern: filename, options: o, newLogger: newLogger, maxBackups: maxBackups, maxAge: maxAge}
+		if err := sink.rotate(time.Now()); err != nil {
+			return nil, err
+		}
+		return sink, nil
+	}
+	if err := o.prepare(filename); err != nil {
+		return nil, err
 	}
-	return filepath.Clean(filepath.Join(base, target))
+	return lumberjackSink{Logger: newLogger(filename)}, nil
+}
+
+// splitPath inserts the level name before the file extension: app.log -> app.error.log
+func splitPath(target string, level zapcore.Level) string {
+	var ext = filepath.Ext(target)
+	return strings.TrimSuffix(target, ext) + "." + level.String() + ext
+}
+
+func parseRotation(params url.Values, sizeKey, backupsKey, ageKey string) (maxSize, maxBackups, maxAge int, err error) {
+	var val int64
+	if maxSizeVal := params.Get(sizeKey); maxSizeVal != "" {
+		if val, err = strconv.ParseInt(maxSizeVal, 10, 32); err != nil {
+			return 0, 0, 0, fmt.Errorf("cant parse arg `%s`: %w", sizeKey, err)
+		}
+		maxSize = int(val)
+	}
+	if maxBackupsVal := params.Get(backupsKey); maxBackupsVal != "" {
+		if val, err = strconv.ParseInt(maxBackupsVal, 10, 32); err != nil {
+			return 0, 0, 0, fmt.Errorf("cant parse arg `%s`: %w", backupsKey, err)
+		}
+		maxBackups = int(val)
+	}
+	if maxAgeVal := params.Get(ageKey); maxAgeVal != "" {
+		if val, err = strconv.ParseInt(maxAgeVal, 10, 32); err != nil {
+			return 0, 0, 0, fmt.Errorf("cant parse arg `%s`: %w", ageKey, err)
+		}
+		maxAge = int(val)
+	}
+	return maxSize, maxBackups, maxAge, nil
 }
 
 func register(logPath *url.URL) (sink zap.Sink, err error) {
@@ -47,28 +262,79 @@ func register(logPath *url.URL) (sink zap.Sink, err error) {
 	if filePath = params.Get(LumberjackParamPath); filePath == "" {
 		return nil, fmt.Errorf("undefined arg `%s`", LumberjackParamPath)
 	}
-	var maxSize, maxBackups, maxAge int64
-	if maxSizeVal := params.Get(LumberjackParamMaxSize); maxSizeVal != "" {
-		if maxSize, err = strconv.ParseInt(maxSizeVal, 10, 32); err != nil {
-			return nil, fmt.Errorf("cant parse arg `%s`: %w", LumberjackParamMaxSize, err)
+	filePath = expandStatic(filePath, "")
+	if err = logpath.Check(fileBase, filePath); err != nil {
+		return nil, err
+	}
+	var options = fileOptions{dirMode: 0755, uid: -1, gid: -1}
+	if fileModeVal := params.Get(LumberjackParamFileMode); fileModeVal != "" {
+		if options.fileMode, err = logpath.ParseMode(fileModeVal); err != nil {
+			return nil, fmt.Errorf("cant parse arg `%s`: %w", LumberjackParamFileMode, err)
 		}
 	}
-	if maxBackupsVal := params.Get(LumberjackParamMaxBackups); maxBackupsVal != "" {
-		if maxBackups, err = strconv.ParseInt(maxBackupsVal, 10, 32); err != nil {
-			return nil, fmt.Errorf("cant parse arg `%s`: %w", LumberjackParamMaxBackups, err)
+	if dirModeVal := params.Get(LumberjackParamDirMode); dirModeVal != "" {
+		if options.dirMode, err = logpath.ParseMode(dirModeVal); err != nil {
+			return nil, fmt.Errorf("cant parse arg `%s`: %w", LumberjackParamDirMode, err)
 		}
 	}
-	if maxAgeVal := params.Get(LumberjackParamMaxAge); maxAgeVal != "" {
-		if maxAge, err = strconv.ParseInt(maxAgeVal, 10, 32); err != nil {
-			return nil, fmt.Errorf("cant parse arg `%s`: %w", LumberjackParamMaxAge, err)
+	options.createDirs = params.Get(LumberjackParamCreateDirs) == "true"
+	if options.uid, err = lookupID(params.Get(LumberjackParamFileOwner), lookupUser); err != nil {
+		return nil, fmt.Errorf("cant parse arg `%s`: %w", LumberjackParamFileOwner, err)
+	}
+	if options.gid, err = lookupID(params.Get(LumberjackParamFileGroup), lookupGroup); err != nil {
+		return nil, fmt.Errorf("cant parse arg `%s`: %w", LumberjackParamFileGroup, err)
+	}
+	var maxSize, maxBackups, maxAge int
+	if maxSize, maxBackups, maxAge, err = parseRotation(params,
+		LumberjackParamMaxSize, LumberjackParamMaxBackups, LumberjackParamMaxAge); err != nil {
+		return nil, err
+	}
+	var mainSink zap.Sink
+	if mainSink, err = options.open(logpath.Target(fileBase, filePath), maxSize, maxBackups, maxAge); err != nil {
+		return nil, err
+	}
+	switch splitBy := params.Get(LumberjackParamSplitBy); splitBy {
+	case "":
+		return mainSink, nil
+	case LumberjackSplitByLevel:
+	default:
+		_ = mainSink.Close()
+		return nil, fmt.Errorf("unsupported arg `%s`: %s", LumberjackParamSplitBy, splitBy)
+	}
+	var splitLevel zapcore.Level
+	var splitSink zap.Sink
+	if splitLevel, splitSink, err = openSplit(params, fileBase, filePath, options); err != nil {
+		_ = mainSink.Close()
+		return nil, err
+	}
+	return lumberjackSplitSink{Sink: mainSink, level: splitLevel, split: splitSink}, nil
+}
+
+func openSplit(params url.Values, fileBase, filePath string, options fileOptions) (
+	splitLevel zapcore.Level, sink zap.Sink, err error,
+) {
+	splitLevel = zapcore.ErrorLevel
+	if splitLevelVal := params.Get(LumberjackParamSplitLevel); splitLevelVal != "" {
+		if err = splitLevel.UnmarshalText([]byte(splitLevelVal)); err != nil {
+			return 0, nil, fmt.Errorf("cant parse arg `%s`: %w", LumberjackParamSplitLevel, err)
 		}
 	}
-	return lumberjackSink{Logger: &lumberjack.Logger{
-		Filename:   targetPath(fileBase, filePath),
-		MaxSize:    int(maxSize),
-		MaxBackups: int(maxBackups),
-		MaxAge:     int(maxAge),
-	}}, nil
+	var splitFile string
+	if splitFile = params.Get(LumberjackParamSplitPath); splitFile == "" {
+		splitFile = splitPath(filePath, splitLevel)
+	}
+	if err = logpath.Check(fileBase, expandStatic(splitFile, "")); err != nil {
+		return 0, nil, err
+	}
+	var maxSize, maxBackups, maxAge int
+	if maxSize, maxBackups, maxAge, err = parseRotation(params,
+		LumberjackParamSplitMaxSize, LumberjackParamSplitMaxBackups, LumberjackParamSplitMaxAge); err != nil {
+		return 0, nil, err
+	}
+	if sink, err = options.open(logpath.Target(fileBase, expandStatic(splitFile, "")), maxSize, maxBackups, maxAge); err != nil {
+		return 0, nil, err
+	}
+	return splitLevel, sink, nil
 }
 
 func init() {
@@ -96,6 +362,14 @@ func (g *urlGenerator) Provider() string {
 	return LumberjackSchema
 }
 
+// topicName expands {topic}, falling back to the provider outside of a logger topic.
+func (g *urlGenerator) topicName(argStore func(string) (string, bool)) string {
+	if name, ok := argStore(LumberjackConfigTopicName); ok && name != "" {
+		return name
+	}
+	return g.Provider()
+}
+
 func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
 	var ok bool
 	var outputQuery = url.Values{}
@@ -108,10 +382,53 @@ func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, e
 			return "", fmt.Errorf("unspecificed log base path `%s`", LumberjackParamBase)
 		}
 		if filePath, ok = argStore(LumberjackConfigPath); ok {
+			filePath = expandStatic(filePath, g.topicName(argStore))
 			outputQuery.Set(LumberjackParamPath, filePath)
 		} else {
 			return "", fmt.Errorf("unspecificed log relative path `%s`", LumberjackConfigPath)
 		}
+		if err := logpath.Check(fileBase, filePath); err != nil {
+			return "", err
+		}
+	}
+	{
+		var fileMode, dirMode, createDirs string
+		if fileMode, ok = argStore(LumberjackConfigFileMode); ok {
+			if _, err := logpath.ParseMode(fileMode); err != nil {
+				return "", fmt.Errorf("invalid `%s`: %w", LumberjackConfigFileMode, err)
+			}
+			outputQuery.Set(LumberjackParamFileMode, fileMode)
+		}
+		if createDirs, ok = argStore(LumberjackConfigCreateDirs); ok && createDirs != "" {
+			if enabled, err := strconv.ParseBool(strings.TrimSpace(createDirs)); err != nil {
+				return "", fmt.Errorf("cant parse `%s`: %w", LumberjackConfigCreateDirs, err)
+			} else if enabled {
+				outputQuery.Set(LumberjackParamCreateDirs, "true")
+			}
+		}
+		if dirMode, ok = argStore(LumberjackConfigDirMode); ok {
+			if _, err := logpath.ParseMode(dirMode); err != nil {
+				return "", fmt.Errorf("invalid `%s`: %w", LumberjackConfigDirMode, err)
+			}
+			if outputQuery.Get(LumberjackParamCreateDirs) == "" {
+				return "", fmt.Errorf("`%s` requires `%s`", LumberjackConfigDirMode, LumberjackConfigCreateDirs)
+			}
+			outputQuery.Set(LumberjackParamDirMode, dirMode)
+		}
+	}
+	for config, ownership := range map[string]struct {
+		param  string
+		lookup func(string) (string, error)
+	}{
+		LumberjackConfigFileOwner: {param: LumberjackParamFileOwner, lookup: lookupUser},
+		LumberjackConfigFileGroup: {param: LumberjackParamFileGroup, lookup: lookupGroup},
+	} {
+		if val, exist := argStore(config); exist && strings.TrimSpace(val) != "" {
+			if _, err := lookupID(val, ownership.lookup); err != nil {
+				return "", fmt.Errorf("invalid `%s`: %w", config, err)
+			}
+			outputQuery.Set(ownership.param, strings.TrimSpace(val))
+		}
 	}
 	{
 		var maxSize, maxBackups, maxAge string
@@ -125,6 +442,31 @@ func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, e
 			outputQuery.Set(LumberjackParamMaxAge, maxAge)
 		}
 	}
+	if splitBy, ok := argStore(LumberjackConfigSplitBy); ok && splitBy != "" {
+		if splitBy = strings.ToLower(strings.TrimSpace(splitBy)); splitBy != LumberjackSplitByLevel {
+			return "", fmt.Errorf("unsupported split mode `%s`: %s", LumberjackConfigSplitBy, splitBy)
+		}
+		outputQuery.Set(LumberjackParamSplitBy, splitBy)
+		for config, param := range map[string]string{
+			LumberjackConfigSplitLevel:      LumberjackParamSplitLevel,
+			LumberjackConfigSplitPath:       LumberjackParamSplitPath,
+			LumberjackConfigSplitMaxSize:    LumberjackParamSplitMaxSize,
+			LumberjackConfigSplitMaxBackups: LumberjackParamSplitMaxBackups,
+			LumberjackConfigSplitMaxAge:     LumberjackParamSplitMaxAge,
+		} {
+			if val, exist := argStore(config); exist {
+				if param == LumberjackParamSplitPath {
+					val = expandStatic(val, g.topicName(argStore))
+				}
+				outputQuery.Set(param, val)
+			}
+		}
+		if splitFile := outputQuery.Get(LumberjackParamSplitPath); splitFile != "" {
+			if err := logpath.Check(g.base, splitFile); err != nil {
+				return "", err
+			}
+		}
+	}
 	outputPath.RawQuery = outputQuery.Encode()
 	return outputPath.String(), nil
 }
@@ -0,0 +1,92 @@
+//go:build linux
+// +build linux
+
+package sink_lumberjack
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestDefaultDirMode(t *testing.T) {
+	var base = t.TempDir()
+	var umask = syscall.Umask(0)
+	defer syscall.Umask(umask)
+	sink, err := openSink(t, base, map[string]string{LumberjackConfigPath: "a/b/app.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	for _, path := range []string{"a", "a/b"} {
+		if info, err := os.Stat(filepath.Join(base, path)); err != nil {
+			t.Error(err)
+		} else if info.Mode() != lumberjackDirMode|os.ModeDir {
+			t.Errorf("%s has mode %v, want %v", path, info.Mode(), os.FileMode(lumberjackDirMode)|os.ModeDir)
+		}
+	}
+}
+
+func TestCreateDirsModeIgnoresUmask(t *testing.T) {
+	var base = t.TempDir()
+	var umask = syscall.Umask(0077)
+	defer syscall.Umask(umask)
+	sink, err := openSink(t, base, map[string]string{
+		LumberjackConfigPath:       "a/b/app.log",
+		LumberjackConfigCreateDirs: "true",
+		LumberjackConfigDirMode:    "0750",
+		LumberjackConfigFileMode:   "0640",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	for path, want := range map[string]os.FileMode{
+		"a":           0750 | os.ModeDir,
+		"a/b":         0750 | os.ModeDir,
+		"a/b/app.log": 0640,
+	} {
+		if info, err := os.Stat(filepath.Join(base, path)); err != nil {
+			t.Error(err)
+		} else if info.Mode() != want {
+			t.Errorf("%s has mode %v, want %v", path, info.Mode(), want)
+		}
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	var base = t.TempDir()
+	var uid, gid = strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+	sink, err := openSink(t, base, map[string]string{
+		LumberjackConfigPath:       "logs/app.log",
+		LumberjackConfigCreateDirs: "true",
+		LumberjackConfigFileOwner:  uid,
+		LumberjackConfigFileGroup:  gid,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	for _, path := range []string{"logs", "logs/app.log"} {
+		info, err := os.Stat(filepath.Join(base, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stat = info.Sys().(*syscall.Stat_t)
+		if strconv.Itoa(int(stat.Uid)) != uid || strconv.Itoa(int(stat.Gid)) != gid {
+			t.Errorf("%s owned by %d:%d, want %s:%s", path, stat.Uid, stat.Gid, uid, gid)
+		}
+	}
+	for _, config := range []string{LumberjackConfigFileOwner, LumberjackConfigFileGroup} {
+		_, err = openSink(t, base, map[string]string{
+			LumberjackConfigPath: "app.log",
+			config:               "no-such-principal",
+		})
+		if err == nil || !strings.Contains(err.Error(), config) {
+			t.Errorf("unknown %s accepted: %v", config, err)
+		}
+	}
+}
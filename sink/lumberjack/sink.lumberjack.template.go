@@ -0,0 +1,229 @@
+package sink_lumberjack
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	defaultDateLayout = "2006-01-02"
+	// backupTimeLayout is the timestamp lumberjack inserts into the names of its backups.
+	backupTimeLayout = "2006-01-02T15-04-05.000"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z]+)(?::([^{}]*))?}`)
+
+var globMeta = strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`)
+
+// expandStatic replaces the placeholders that stay fixed for the process lifetime:
+// {hostname}, {pid}, {topic} and {env:NAME}. Date placeholders are left untouched.
+func expandStatic(path string, topic string) string {
+	return placeholderPattern.ReplaceAllStringFunc(path, func(placeholder string) string {
+		var match = placeholderPattern.FindStringSubmatch(placeholder)
+		switch match[1] {
+		case "hostname":
+			if hostname, err := os.Hostname(); err == nil {
+				return hostname
+			}
+			return "localhost"
+		case "pid":
+			return strconv.Itoa(os.Getpid())
+		case "topic":
+			if topic == "" {
+				return placeholder
+			}
+			return topic
+		case "env":
+			return os.Getenv(match[2])
+		default:
+			return placeholder
+		}
+	})
+}
+
+// expandDate replaces {date} and {date:<go time layout>} with the given time.
+func expandDate(path string, t time.Time) string {
+	return placeholderPattern.ReplaceAllStringFunc(path, func(placeholder string) string {
+		var match = placeholderPattern.FindStringSubmatch(placeholder)
+		if match[1] != "date" {
+			return placeholder
+		}
+		if match[2] == "" {
+			return t.Format(defaultDateLayout)
+		}
+		return t.Format(match[2])
+	})
+}
+
+// globDate replaces the date placeholders with a wildcard, escaping the rest of path.
+func globDate(path string) string {
+	var glob strings.Builder
+	var last = 0
+	for _, loc := range placeholderPattern.FindAllStringSubmatchIndex(path, -1) {
+		if path[loc[2]:loc[3]] != "date" {
+			continue
+		}
+		glob.WriteString(escapeGlob(path[last:loc[0]]))
+		glob.WriteString("*")
+		last = loc[1]
+	}
+	glob.WriteString(escapeGlob(path[last:]))
+	return glob.String()
+}
+
+// datePattern matches the paths pattern expands to, with one group per date
+// placeholder; layouts holds the layout each group must parse with.
+func datePattern(pattern string) (matcher *regexp.Regexp, layouts []string) {
+	var expr strings.Builder
+	var last = 0
+	expr.WriteByte('^')
+	for _, loc := range placeholderPattern.FindAllStringSubmatchIndex(pattern, -1) {
+		if pattern[loc[2]:loc[3]] != "date" {
+			continue
+		}
+		var layout = defaultDateLayout
+		if loc[4] >= 0 && loc[5] > loc[4] {
+			layout = pattern[loc[4]:loc[5]]
+		}
+		expr.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		expr.WriteString("(.+?)")
+		layouts, last = append(layouts, layout), loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(pattern[last:]))
+	expr.WriteByte('$')
+	return regexp.MustCompile(expr.String()), layouts
+}
+
+// matchDate reports whether path is a file of pattern at some date, or a
+// lumberjack backup of one. The glob of a pattern also matches siblings such as
+// the split file `app-{date}.error.log` of `app-{date}.log`, their date doesnt parse.
+func matchDate(matcher *regexp.Regexp, layouts []string, path string) bool {
+	var ext = filepath.Ext(path)
+	var stem = strings.TrimSuffix(path, ext)
+	if cut := len(stem) - len(backupTimeLayout) - 1; cut > 0 && stem[cut] == '-' {
+		if _, err := time.Parse(backupTimeLayout, stem[cut+1:]); err == nil && matchDate(matcher, layouts, stem[:cut]+ext) {
+			return true
+		}
+	}
+	var groups = matcher.FindStringSubmatch(path)
+	if groups == nil {
+		return false
+	}
+	for i, layout := range layouts {
+		if _, err := time.Parse(layout, groups[i+1]); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeGlob quotes glob metacharacters, filepath.Glob has no escaping on windows.
+func escapeGlob(text string) string {
+	if filepath.Separator == '\\' {
+		return text
+	}
+	return globMeta.Replace(text)
+}
+
+func hasDate(path string) bool {
+	return strings.Contains(path, "{date}") || strings.Contains(path, "{date:")
+}
+
+// datedSink switches to a new lumberjack file whenever the expanded date
+// placeholders of its path change, size based rotation is left to lumberjack.
+// Files of previous dates are pruned by maxBackups and maxAge like lumberjack prunes its backups.
+type datedSink struct {
+	mu         sync.Mutex
+	pattern    string
+	current    string
+	checked    int64
+	options    fileOptions
+	maxBackups int
+	maxAge     int
+	newLogger  func(filename string) *lumberjack.Logger
+	logger     *lumberjack.Logger
+}
+
+func (sink *datedSink) rotate(now time.Time) error {
+	if sink.checked == now.Unix() && sink.logger != nil {
+		return nil
+	}
+	sink.checked = now.Unix()
+	var filename = expandDate(sink.pattern, now)
+	if filename == sink.current && sink.logger != nil {
+		return nil
+	}
+	if err := sink.options.prepare(filename); err != nil {
+		return err
+	}
+	if sink.logger != nil {
+		_ = sink.logger.Close()
+	}
+	sink.current, sink.logger = filename, sink.newLogger(filename)
+	sink.prune(now)
+	return nil
+}
+
+// prune removes files of previous dates and their backups, the backups
+// lumberjack keeps of the current file are left to lumberjack.
+func (sink *datedSink) prune(now time.Time) {
+	if sink.maxBackups == 0 && sink.maxAge == 0 {
+		return
+	}
+	var matches, err = filepath.Glob(globDate(sink.pattern))
+	if err != nil {
+		return
+	}
+	var matcher, layouts = datePattern(sink.pattern)
+	var ext = filepath.Ext(sink.current)
+	var ownBackups = strings.TrimSuffix(sink.current, ext) + "-"
+	var files []os.FileInfo
+	var paths = map[os.FileInfo]string{}
+	for _, path := range matches {
+		if path == sink.current || (strings.HasPrefix(path, ownBackups) && strings.HasSuffix(path, ext)) ||
+			!matchDate(matcher, layouts, path) {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			files = append(files, info)
+			paths[info] = path
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	var cutoff = now.Add(-time.Duration(sink.maxAge) * 24 * time.Hour)
+	for i, info := range files {
+		if (sink.maxBackups > 0 && i >= sink.maxBackups) || (sink.maxAge > 0 && info.ModTime().Before(cutoff)) {
+			_ = os.Remove(paths[info])
+		}
+	}
+}
+
+func (sink *datedSink) Write(p []byte) (int, error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if err := sink.rotate(time.Now()); err != nil {
+		return 0, err
+	}
+	return sink.logger.Write(p)
+}
+
+func (sink *datedSink) Sync() error {
+	return nil
+}
+
+func (sink *datedSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.logger == nil {
+		return nil
+	}
+	return sink.logger.Close()
+}
@@ -0,0 +1,279 @@
+package sink_lumberjack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// generate builds the sink url of g from keys.
+func generate(g *urlGenerator, keys map[string]string) (*url.URL, error) {
+	rawURL, err := g.Generate(func(key string) (string, bool) {
+		val, ok := keys[key]
+		return val, ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(rawURL)
+}
+
+// openSink opens a sink writing below base, keys set the topic config.
+func openSink(t *testing.T, base string, keys map[string]string) (interface{ Close() error }, error) {
+	t.Helper()
+	sinkURL, err := generate(NewURLGenerator(base), keys)
+	if err != nil {
+		return nil, err
+	}
+	return register(sinkURL)
+}
+
+func TestMissingDirCreated(t *testing.T) {
+	var base = t.TempDir()
+	for _, path := range []string{"missing/app.log", "dated/{date}/app.log"} {
+		sink, err := openSink(t, base, map[string]string{LumberjackConfigPath: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = sink.(zap.Sink).Write([]byte("entry\n"))
+		_ = sink.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	for _, path := range []string{"missing/app.log", filepath.Join("dated", time.Now().Format(defaultDateLayout), "app.log")} {
+		if _, err := os.Stat(filepath.Join(base, path)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTopicPlaceholder(t *testing.T) {
+	var g = NewURLGenerator(t.TempDir()).WithTopic("file")
+	for _, tc := range []struct {
+		keys map[string]string
+		want string
+	}{
+		{keys: map[string]string{LumberjackConfigPath: "{topic}.log", LumberjackConfigTopicName: "audit"}, want: "audit.log"},
+		{keys: map[string]string{LumberjackConfigPath: "{topic}.log"}, want: "file.log"},
+	} {
+		sinkURL, err := generate(g, tc.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path := sinkURL.Query().Get(LumberjackParamPath); path != tc.want {
+			t.Errorf("path %q, want %q", path, tc.want)
+		}
+	}
+}
+
+func TestDatedSinkPrune(t *testing.T) {
+	var dir = t.TempDir()
+	var now = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	var old = map[string]time.Duration{
+		"app-2026-10-18.log": 24 * time.Hour,
+		"app-2026-10-17.log": 48 * time.Hour,
+		"app-2026-10-16.log": 72 * time.Hour,
+		"app-2026-10-01.log": 18 * 24 * time.Hour,
+	}
+	for name, age := range old {
+		var path = filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var unrelated = filepath.Join(dir, "other.log")
+	if err := os.WriteFile(unrelated, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var sink = &datedSink{
+		pattern: filepath.Join(dir, "app-{date}.log"), options: fileOptions{uid: -1, gid: -1},
+		maxBackups: 2, maxAge: 7,
+		newLogger: func(filename string) *lumberjack.Logger { return &lumberjack.Logger{Filename: filename} },
+	}
+	if err := sink.rotate(now); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	for name, kept := range map[string]bool{
+		"app-2026-10-18.log": true,
+		"app-2026-10-17.log": true,
+		"app-2026-10-16.log": false,
+		"app-2026-10-01.log": false,
+		"other.log":          true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != kept {
+			t.Errorf("%s kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+}
+
+// splitLogger tees the main and split files of sink the way the logger does.
+func splitLogger(t *testing.T, sink interface{ Close() error }) *zap.Logger {
+	t.Helper()
+	var split, ok = sink.(lumberjackSplitSink)
+	if !ok {
+		t.Fatalf("got %T, want a split sink", sink)
+	}
+	var enabler, splitSyncer = split.SplitLevel()
+	var encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return zap.New(zapcore.NewTee(
+		zapcore.NewCore(encoder, split, zapcore.DebugLevel),
+		zapcore.NewCore(encoder.Clone(), splitSyncer, enabler),
+	))
+}
+
+func readMessages(t *testing.T, path string) []string {
+	t.Helper()
+	var data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry struct{ Msg string }
+		if err = json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		messages = append(messages, entry.Msg)
+	}
+	return messages
+}
+
+func TestSplitByLevel(t *testing.T) {
+	var base = t.TempDir()
+	sink, err := openSink(t, base, map[string]string{
+		LumberjackConfigPath:    "app.log",
+		LumberjackConfigSplitBy: "Level",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	var log = splitLogger(t, sink)
+	log.Debug("debug")
+	log.Warn("warn")
+	log.Error("error")
+	log.DPanic("dpanic")
+	if got, want := readMessages(t, filepath.Join(base, "app.log")), []string{"debug", "warn", "error", "dpanic"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("main file has %q, want %q", got, want)
+	}
+	if got, want := readMessages(t, filepath.Join(base, "app.error.log")), []string{"error", "dpanic"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("split file has %q, want %q", got, want)
+	}
+}
+
+func TestSplitRotation(t *testing.T) {
+	var base = t.TempDir()
+	sink, err := openSink(t, base, map[string]string{
+		LumberjackConfigPath:            "app.log",
+		LumberjackConfigMaxSize:         "10",
+		LumberjackConfigMaxBackups:      "1",
+		LumberjackConfigMaxAge:          "2",
+		LumberjackConfigSplitBy:         LumberjackSplitByLevel,
+		LumberjackConfigSplitLevel:      "warn",
+		LumberjackConfigSplitPath:       "errors/{topic}.log",
+		LumberjackConfigSplitMaxSize:    "20",
+		LumberjackConfigSplitMaxBackups: "5",
+		LumberjackConfigSplitMaxAge:     "30",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	var split = sink.(lumberjackSplitSink)
+	var main, splitFile = split.Sink.(lumberjackSink).Logger, split.split.(lumberjackSink).Logger
+	if main.Filename != filepath.Join(base, "app.log") || main.MaxSize != 10 || main.MaxBackups != 1 || main.MaxAge != 2 {
+		t.Errorf("unexpected main rotation %+v", main)
+	}
+	if splitFile.Filename != filepath.Join(base, "errors", "lumberjack.log") ||
+		splitFile.MaxSize != 20 || splitFile.MaxBackups != 5 || splitFile.MaxAge != 30 {
+		t.Errorf("unexpected split rotation %+v", splitFile)
+	}
+	splitLogger(t, sink).Info("info")
+	splitLogger(t, sink).Warn("warn")
+	if got := readMessages(t, splitFile.Filename); !reflect.DeepEqual(got, []string{"warn"}) {
+		t.Errorf("split file has %q, want warn only", got)
+	}
+}
+
+func TestMatchDate(t *testing.T) {
+	var matcher, layouts = datePattern(filepath.Join("logs", "app-{date}.{date:15}h.log"))
+	for name, want := range map[string]bool{
+		"app-2026-10-18.09h.log":                               true,
+		"app-2026-10-18.09h-2026-10-18T09-30-00.000.log":       true,
+		"app-2026-10-18.09h.error.log":                         false,
+		"app-2026-10-18.error.09h.log":                         false,
+		"app-latest.09h.log":                                   false,
+		"app-2026-10-18.09h-latest.log":                        false,
+		"app-2026-10-18.09h-2026-10-18T09-30-00.000.error.log": false,
+		"other-2026-10-18.09h-2026-10-18T09-30-00.000.log":     false,
+	} {
+		if got := matchDate(matcher, layouts, filepath.Join("logs", name)); got != want {
+			t.Errorf("%s matched = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestSplitDatedPrune checks that the main and split files of a dated path
+// prune their own files only, each by its own backup count.
+func TestSplitDatedPrune(t *testing.T) {
+	var base = t.TempDir()
+	var now = time.Now()
+	for days := 1; days <= 3; days++ {
+		var date = now.AddDate(0, 0, -days)
+		for _, name := range []string{"app-%s.log", "app-%s.error.log"} {
+			var path = filepath.Join(base, fmt.Sprintf(name, date.Format(defaultDateLayout)))
+			if err := os.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, date, date); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	sink, err := openSink(t, base, map[string]string{
+		LumberjackConfigPath:            "app-{date}.log",
+		LumberjackConfigMaxBackups:      "1",
+		LumberjackConfigSplitBy:         LumberjackSplitByLevel,
+		LumberjackConfigSplitMaxBackups: "5",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	var log = splitLogger(t, sink)
+	log.Error("today")
+	for _, want := range []struct {
+		days int
+		name string
+		kept bool
+	}{
+		{days: 0, name: "app-%s.log", kept: true},
+		{days: 0, name: "app-%s.error.log", kept: true},
+		{days: 1, name: "app-%s.log", kept: true},
+		{days: 2, name: "app-%s.log", kept: false},
+		{days: 3, name: "app-%s.log", kept: false},
+		{days: 1, name: "app-%s.error.log", kept: true},
+		{days: 2, name: "app-%s.error.log", kept: true},
+		{days: 3, name: "app-%s.error.log", kept: true},
+	} {
+		var name = fmt.Sprintf(want.name, now.AddDate(0, 0, -want.days).Format(defaultDateLayout))
+		if _, err := os.Stat(filepath.Join(base, name)); (err == nil) != want.kept {
+			t.Errorf("%s kept = %v, want %v", name, err == nil, want.kept)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package sink_network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	NetworkSchema              = "network"
+	NetworkParamNetwork        = "network"
+	NetworkParamAddress        = "address"
+	NetworkParamBufferSize     = "bufferSize"
+	NetworkParamDialTimeout    = "dialTimeout"
+	NetworkParamWriteTimeout   = "writeTimeout"
+	NetworkParamMaxBackoff     = "maxBackoff"
+	NetworkParamTLSCAFile      = "tlsCAFile"
+	NetworkParamTLSServerName  = "tlsServerName"
+	NetworkParamTLSInsecure    = "tlsInsecure"
+	NetworkConfigNetwork       = "Network"
+	NetworkConfigAddress       = "Address"
+	NetworkConfigBufferSize    = "BufferSize"
+	NetworkConfigDialTimeout   = "DialTimeout"
+	NetworkConfigWriteTimeout  = "WriteTimeout"
+	NetworkConfigMaxBackoff    = "MaxBackoff"
+	NetworkConfigTLSCAFile     = "TLSCAFile"
+	NetworkConfigTLSServer     = "TLSServerName"
+	NetworkConfigTLSInsecure   = "TLSInsecureSkipVerify"
+	NetworkTLS                 = "tls"
+	networkDefaultNetwork      = "tcp"
+	networkDefaultBufferSize   = 1024 * 1024
+	networkDefaultDialTimeout  = 5 * time.Second
+	networkDefaultWriteTimeout = 5 * time.Second
+	networkDefaultMaxBackoff   = 30 * time.Second
+	networkMinBackoff          = 100 * time.Millisecond
+)
+
+var networkSupported = map[string]bool{
+	"tcp": true, "tcp4": true, "tcp6": true,
+	"udp": true, "udp4": true, "udp6": true,
+	"unix": true, "unixgram": true, NetworkTLS: true,
+}
+
+// networkSink writes encoded entries to a socket. While disconnected, entries are kept
+// in a buffer bounded by bufferSize, dropping the oldest ones, and a goroutine
+// reconnects with exponential backoff, flushing the buffer once connected.
+// Stream writes accepted by the kernel before a broken connection is noticed are lost.
+type networkSink struct {
+	mu           sync.Mutex
+	network      string
+	address      string
+	tlsConfig    *tls.Config
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+	maxBackoff   time.Duration
+	bufferSize   int
+	conn         net.Conn
+	buffered     [][]byte
+	bufferedSize int
+	reconnecting bool
+	dropped      uint64
+	stop         chan struct{}
+	done         sync.WaitGroup
+	closed       bool
+	reporter     atomic.Value
+}
+
+func (sink *networkSink) AcceptReporter(reporter *zap.Logger) {
+	sink.reporter.Store(reporter)
+}
+
+func (sink *networkSink) report(msg string, fields ...zap.Field) {
+	if reporter, ok := sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error(msg, append(fields, zap.String("address", sink.network+"://"+sink.address))...)
+	}
+}
+
+func (sink *networkSink) dial() (net.Conn, error) {
+	var dialer = &net.Dialer{Timeout: sink.dialTimeout}
+	if sink.network == NetworkTLS {
+		return tls.DialWithDialer(dialer, "tcp", sink.address, sink.tlsConfig)
+	}
+	return dialer.Dial(sink.network, sink.address)
+}
+
+// writeConn writes p to conn, n counts the bytes written before a failure.
+func (sink *networkSink) writeConn(conn net.Conn, p []byte) (n int, err error) {
+	if sink.writeTimeout > 0 {
+		_ = conn.SetWriteDeadline(time.Now().Add(sink.writeTimeout))
+	}
+	return conn.Write(p)
+}
+
+// unwritten is the part of p to buffer after a write of n bytes failed,
+// datagrams are resent whole while streams continue where the write stopped.
+func (sink *networkSink) unwritten(p []byte, n int) []byte {
+	switch sink.network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return p
+	default:
+		return p[n:]
+	}
+}
+
+// buffer keeps a copy of p, the caller holds sink.mu.
+func (sink *networkSink) buffer(p []byte) {
+	if len(p) > sink.bufferSize {
+		sink.drop(1)
+		return
+	}
+	sink.buffered = append(sink.buffered, append([]byte(nil), p...))
+	sink.bufferedSize += len(p)
+	sink.trimBuffered()
+}
+
+// trimBuffered drops the oldest entries over bufferSize, the caller holds sink.mu.
+func (sink *networkSink) trimBuffered() {
+	for sink.bufferedSize > sink.bufferSize && len(sink.buffered) > 0 {
+		sink.bufferedSize -= len(sink.buffered[0])
+		sink.buffered[0] = nil
+		sink.buffered = sink.buffered[1:]
+		sink.drop(1)
+	}
+}
+
+func (sink *networkSink) drop(count uint64) {
+	if dropped := atomic.AddUint64(&sink.dropped, count); dropped&(dropped-1) == 0 {
+		sink.report("network buffer full, entries dropped", zap.Uint64("dropped", dropped))
+	}
+}
+
+// disconnect closes the broken connection and starts reconnecting, the caller holds sink.mu.
+func (sink *networkSink) disconnect(err error) {
+	if sink.conn != nil {
+		_ = sink.conn.Close()
+		sink.conn = nil
+		sink.report("network connection lost", zap.Error(err))
+	}
+	if !sink.reconnecting && !sink.closed {
+		sink.reconnecting = true
+		sink.done.Add(1)
+		go sink.reconnect()
+	}
+}
+
+func (sink *networkSink) reconnect() {
+	defer sink.done.Done()
+	var backoff = networkMinBackoff
+	for {
+		select {
+		case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))): // #nosec G404
+		case <-sink.stop:
+			sink.mu.Lock()
+			sink.reconnecting = false
+			sink.mu.Unlock()
+			return
+		}
+		conn, err := sink.dial()
+		if err == nil {
+			if err = sink.flushConnecting(conn); err == nil {
+				return
+			}
+			_ = conn.Close()
+		}
+		sink.report("network reconnect failed", zap.Error(err), zap.Duration("backoff", backoff))
+		if backoff *= 2; backoff > sink.maxBackoff {
+			backoff = sink.maxBackoff
+		}
+	}
+}
+
+// flushConnecting writes the buffered entries to conn without holding sink.mu,
+// so Write keeps buffering meanwhile, and takes conn into use once the buffer is empty.
+func (sink *networkSink) flushConnecting(conn net.Conn) error {
+	for {
+		sink.mu.Lock()
+		if len(sink.buffered) == 0 {
+			sink.conn, sink.reconnecting = conn, false
+			sink.mu.Unlock()
+			return nil
+		}
+		var batch = sink.buffered
+		sink.buffered, sink.bufferedSize = nil, 0
+		sink.mu.Unlock()
+		if rest, err := sink.writeBatch(conn, batch); err != nil {
+			sink.mu.Lock()
+			sink.requeue(rest)
+			sink.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// writeBatch writes batch in order, rest holds what was not written on failure.
+func (sink *networkSink) writeBatch(conn net.Conn, batch [][]byte) (rest [][]byte, err error) {
+	for i, p := range batch {
+		var n int
+		if n, err = sink.writeConn(conn, p); err != nil {
+			if p = sink.unwritten(p, n); len(p) > 0 {
+				return append([][]byte{p}, batch[i+1:]...), err
+			}
+			return batch[i+1:], err
+		}
+	}
+	return nil, nil
+}
+
+// requeue puts rest in front of the entries buffered meanwhile, the caller holds sink.mu.
+func (sink *networkSink) requeue(rest [][]byte) {
+	for _, p := range rest {
+		sink.bufferedSize += len(p)
+	}
+	sink.buffered = append(rest, sink.buffered...)
+	sink.trimBuffered()
+}
+
+// Write sends p, or buffers it while the connection is down, it never fails
+// so a collector outage doesnt show up as zap write errors on every entry.
+func (sink *networkSink) Write(p []byte) (int, error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	var rest = p
+	if sink.conn != nil {
+		var n, err = sink.writeConn(sink.conn, p)
+		if err == nil {
+			return len(p), nil
+		}
+		rest = sink.unwritten(p, n)
+		sink.disconnect(err)
+	}
+	if len(rest) > 0 {
+		sink.buffer(rest)
+	}
+	return len(p), nil
+}
+
+func (sink *networkSink) Sync() error {
+	return nil
+}
+
+// Close stops reconnecting and closes the connection, entries still buffered are
+// written if the connection is up, otherwise they are lost.
+func (sink *networkSink) Close() error {
+	sink.mu.Lock()
+	if sink.closed {
+		sink.mu.Unlock()
+		return nil
+	}
+	sink.closed = true
+	close(sink.stop)
+	sink.mu.Unlock()
+	sink.done.Wait()
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn == nil {
+		if len(sink.buffered) > 0 {
+			sink.report("network closed while disconnected, entries lost", zap.Int("entries", len(sink.buffered)))
+		}
+		return nil
+	}
+	var rest, err = sink.writeBatch(sink.conn, sink.buffered)
+	if err != nil {
+		sink.report("network closed while flushing, entries lost", zap.Int("entries", len(rest)), zap.Error(err))
+	}
+	sink.buffered, sink.bufferedSize = nil, 0
+	if closeErr := sink.conn.Close(); err == nil {
+		err = closeErr
+	}
+	sink.conn = nil
+	return err
+}
+
+func loadTLSConfig(caFile, serverName string, insecure bool) (*tls.Config, error) {
+	var config = &tls.Config{ServerName: serverName, InsecureSkipVerify: insecure} // #nosec G402
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cant read network tls ca file: %w", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in network tls ca file `%s`", caFile)
+	}
+	return config, nil
+}
+
+func parseDuration(params url.Values, key string, def time.Duration) (time.Duration, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := time.ParseDuration(text)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+// parseOptions parses the url params shared by urlGenerator.Generate and register.
+func parseOptions(params url.Values, sink *networkSink) (err error) {
+	if sink.network = params.Get(NetworkParamNetwork); sink.network == "" {
+		sink.network = networkDefaultNetwork
+	} else if !networkSupported[sink.network] {
+		return fmt.Errorf("unsupported arg `%s`: %s", NetworkParamNetwork, sink.network)
+	}
+	sink.bufferSize = networkDefaultBufferSize
+	if text := params.Get(NetworkParamBufferSize); text != "" {
+		if sink.bufferSize, err = strconv.Atoi(text); err != nil || sink.bufferSize < 0 {
+			return fmt.Errorf("invalid arg `%s`: %s", NetworkParamBufferSize, text)
+		}
+	}
+	if sink.dialTimeout, err = parseDuration(params, NetworkParamDialTimeout, networkDefaultDialTimeout); err != nil {
+		return err
+	}
+	if sink.writeTimeout, err = parseDuration(params, NetworkParamWriteTimeout, networkDefaultWriteTimeout); err != nil {
+		return err
+	}
+	if sink.maxBackoff, err = parseDuration(params, NetworkParamMaxBackoff, networkDefaultMaxBackoff); err != nil {
+		return err
+	}
+	if sink.maxBackoff < networkMinBackoff {
+		sink.maxBackoff = networkMinBackoff
+	}
+	if sink.network == NetworkTLS {
+		if sink.tlsConfig, err = loadTLSConfig(params.Get(NetworkParamTLSCAFile),
+			params.Get(NetworkParamTLSServerName), params.Get(NetworkParamTLSInsecure) == "true"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register dials once, an unreachable collector only makes the sink start
+// disconnected, so the application can start before its log collector.
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var params = logPath.Query()
+	var _sink = &networkSink{
+		address: params.Get(NetworkParamAddress),
+		stop:    make(chan struct{}),
+	}
+	if _sink.address == "" {
+		return nil, fmt.Errorf("undefined arg `%s`", NetworkParamAddress)
+	}
+	if err = parseOptions(params, _sink); err != nil {
+		return nil, err
+	}
+	var dialErr error
+	if _sink.conn, dialErr = _sink.dial(); dialErr != nil {
+		_sink.mu.Lock()
+		_sink.disconnect(dialErr)
+		_sink.mu.Unlock()
+	}
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(NetworkSchema, register); err != nil {
+		panic(fmt.Errorf("cant register network sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic string
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic: topic,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return NetworkSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var ok bool
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: NetworkSchema, Host: "localhost"}
+	{
+		var address string
+		if address, ok = argStore(NetworkConfigAddress); !ok || address == "" {
+			return "", fmt.Errorf("`%s` not optional", NetworkConfigAddress)
+		}
+		outputQuery.Set(NetworkParamAddress, address)
+	}
+	if network, exist := argStore(NetworkConfigNetwork); exist && network != "" {
+		outputQuery.Set(NetworkParamNetwork, strings.ToLower(strings.TrimSpace(network)))
+	}
+	for config, param := range map[string]string{
+		NetworkConfigBufferSize:   NetworkParamBufferSize,
+		NetworkConfigDialTimeout:  NetworkParamDialTimeout,
+		NetworkConfigWriteTimeout: NetworkParamWriteTimeout,
+		NetworkConfigMaxBackoff:   NetworkParamMaxBackoff,
+		NetworkConfigTLSCAFile:    NetworkParamTLSCAFile,
+		NetworkConfigTLSServer:    NetworkParamTLSServerName,
+	} {
+		if val, exist := argStore(config); exist && val != "" {
+			outputQuery.Set(param, strings.TrimSpace(val))
+		}
+	}
+	if insecure, ok := argStore(NetworkConfigTLSInsecure); ok && insecure != "" {
+		if enabled, err := strconv.ParseBool(strings.TrimSpace(insecure)); err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", NetworkConfigTLSInsecure, err)
+		} else if enabled {
+			outputQuery.Set(NetworkParamTLSInsecure, "true")
+		}
+	}
+	if err := parseOptions(outputQuery, &networkSink{}); err != nil {
+		return "", err
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+func NewURLGenerator() *urlGenerator {
+	return &urlGenerator{}
+}
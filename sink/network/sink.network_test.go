@@ -0,0 +1,215 @@
+package sink_network
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// openSink opens a sink connecting to address, maxBackoff is set unless empty.
+func openSink(t *testing.T, address, maxBackoff string) (*networkSink, *observer.ObservedLogs) {
+	t.Helper()
+	rawURL, err := NewURLGenerator().Generate(func(key string) (string, bool) {
+		switch key {
+		case NetworkConfigAddress:
+			return address, true
+		case NetworkConfigMaxBackoff:
+			return maxBackoff, maxBackoff != ""
+		}
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_sink, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink = _sink.(*networkSink)
+	var reporterCore, reports = observer.New(zapcore.DebugLevel)
+	sink.AcceptReporter(zap.New(reporterCore))
+	return sink, reports
+}
+
+// readLines collects the lines received by the first connection accepted on listener.
+func readLines(listener net.Listener) <-chan string {
+	var lines = make(chan string, 100)
+	go func() {
+		defer close(lines)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var scanner = bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+func expectLines(t *testing.T, lines <-chan string, want ...string) {
+	t.Helper()
+	for _, line := range want {
+		select {
+		case got := <-lines:
+			if got != line {
+				t.Fatalf("received %q, want %q", got, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q not received", line)
+		}
+	}
+}
+
+func TestSinkWrites(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var lines = readLines(listener)
+	var sink, _ = openSink(t, listener.Addr().String(), "")
+	for _, line := range []string{"a", "b", "c"} {
+		if _, err = sink.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLines(t, lines, "a", "b", "c")
+	if err = sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSinkReconnects starts the sink before its listener, entries written
+// meanwhile arrive in order once the listener is up.
+func TestSinkReconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var address = listener.Addr().String()
+	_ = listener.Close()
+	var sink, reports = openSink(t, address, "200ms")
+	defer sink.Close()
+	_, _ = sink.Write([]byte("early 1\n"))
+	_, _ = sink.Write([]byte("early 2\n"))
+	// let a few reconnects fail first
+	time.Sleep(300 * time.Millisecond)
+	if listener, err = net.Listen("tcp", address); err != nil {
+		t.Skipf("cant listen on %s again: %v", address, err)
+	}
+	defer listener.Close()
+	var lines = readLines(listener)
+	expectLines(t, lines, "early 1", "early 2")
+	_, _ = sink.Write([]byte("late\n"))
+	expectLines(t, lines, "late")
+	if failed := reports.FilterMessage("network reconnect failed").Len(); failed == 0 {
+		t.Error("failed reconnects not reported")
+	}
+}
+
+func TestSinkBufferDropsOldest(t *testing.T) {
+	var sink = &networkSink{network: "tcp", bufferSize: 8}
+	for _, p := range []string{"aaa", "bbb", "ccc", "dddddddddd"} {
+		sink.buffer([]byte(p))
+	}
+	if len(sink.buffered) != 2 || string(sink.buffered[0]) != "bbb" || string(sink.buffered[1]) != "ccc" {
+		t.Errorf("buffered %q", sink.buffered)
+	}
+	if sink.bufferedSize != 6 || sink.dropped != 2 {
+		t.Errorf("buffered %d bytes, dropped %d", sink.bufferedSize, sink.dropped)
+	}
+}
+
+// brokenConn accepts limit bytes, then fails, writes block until gate is closed.
+type brokenConn struct {
+	net.Conn
+	mu      sync.Mutex
+	limit   int
+	written []byte
+	gate    chan struct{}
+	started sync.Once
+	blocked chan struct{}
+}
+
+func (c *brokenConn) Write(p []byte) (int, error) {
+	if c.gate != nil {
+		c.started.Do(func() { close(c.blocked) })
+		<-c.gate
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limit >= 0 && len(c.written)+len(p) > c.limit {
+		var n = c.limit - len(c.written)
+		c.written = append(c.written, p[:n]...)
+		return n, errors.New("broken pipe")
+	}
+	c.written = append(c.written, p...)
+	return len(p), nil
+}
+
+func (c *brokenConn) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
+
+func (c *brokenConn) Close() error {
+	return nil
+}
+
+func TestSinkPartialWrite(t *testing.T) {
+	for network, want := range map[string]string{"tcp": "def\n", "udp": "abcdef\n"} {
+		var sink = &networkSink{network: network, bufferSize: 1024, stop: make(chan struct{}), closed: true}
+		sink.conn = &brokenConn{limit: 3}
+		if n, err := sink.Write([]byte("abcdef\n")); n != 7 || err != nil {
+			t.Errorf("%s: write returned %d, %v", network, n, err)
+		}
+		if len(sink.buffered) != 1 || string(sink.buffered[0]) != want {
+			t.Errorf("%s: buffered %q, want %q", network, sink.buffered, want)
+		}
+	}
+}
+
+// TestSinkWritesDuringFlush checks that Write doesnt wait for a reconnect flush
+// and that its entries follow the flushed ones.
+func TestSinkWritesDuringFlush(t *testing.T) {
+	var sink = &networkSink{network: "tcp", bufferSize: 1024, reconnecting: true}
+	sink.buffer([]byte("old\n"))
+	var conn = &brokenConn{limit: -1, gate: make(chan struct{}), blocked: make(chan struct{})}
+	var flushed = make(chan error)
+	go func() { flushed <- sink.flushConnecting(conn) }()
+	<-conn.blocked
+	var written = make(chan struct{})
+	go func() {
+		_, _ = sink.Write([]byte("new\n"))
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked by flush")
+	}
+	close(conn.gate)
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	if got := string(conn.written); got != "old\nnew\n" {
+		t.Errorf("flushed %q", got)
+	}
+	if sink.conn != conn || sink.reconnecting || len(sink.buffered) != 0 {
+		t.Errorf("connection not taken into use after flush")
+	}
+}
@@ -0,0 +1,233 @@
+// Package otlptest provides an in-process stand-in of an OpenTelemetry collector
+// receiving logs over OTLP/HTTP and OTLP/gRPC, the otlp sink can be pointed at it
+// through the `Endpoint` topic key.
+package otlptest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip" // accepts gzip compressed requests
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type Collector struct {
+	collogspb.UnimplementedLogsServiceServer
+	HTTP     *httptest.Server
+	grpc     *grpc.Server
+	listener net.Listener
+	mu       sync.Mutex
+	logs     []*logspb.ResourceLogs
+	headers  []map[string]string
+	bodies   [][]byte
+	code     codes.Code
+}
+
+// NewCollector starts the stand-in on loopback ports for both transports.
+func NewCollector() (*Collector, error) {
+	var c = &Collector{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	c.listener = listener
+	c.grpc = grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(c.grpc, c)
+	go func() { _ = c.grpc.Serve(listener) }()
+	c.HTTP = httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	return c, nil
+}
+
+// HTTPEndpoint returns the `Endpoint` value for the http protocols.
+func (c *Collector) HTTPEndpoint() string {
+	return c.HTTP.URL
+}
+
+// GRPCEndpoint returns the `Endpoint` value for protocol grpc, connections are plaintext.
+func (c *Collector) GRPCEndpoint() string {
+	return "http://" + c.listener.Addr().String()
+}
+
+// Fail makes the following exports fail with code, codes.OK restores success,
+// http requests get the status the OTLP spec maps code to.
+func (c *Collector) Fail(code codes.Code) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.code = code
+}
+
+// ResourceLogs returns the resource logs received so far, in arrival order.
+func (c *Collector) ResourceLogs() []*logspb.ResourceLogs {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*logspb.ResourceLogs(nil), c.logs...)
+}
+
+// Records returns every log record received so far, in arrival order.
+func (c *Collector) Records() []*logspb.LogRecord {
+	var records []*logspb.LogRecord
+	for _, resourceLogs := range c.ResourceLogs() {
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			records = append(records, scopeLogs.GetLogRecords()...)
+		}
+	}
+	return records
+}
+
+// Headers returns the headers or metadata of each accepted export.
+func (c *Collector) Headers() []map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]map[string]string(nil), c.headers...)
+}
+
+// JSONBodies returns the raw bodies of accepted http/json exports.
+func (c *Collector) JSONBodies() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]byte(nil), c.bodies...)
+}
+
+func (c *Collector) Close() {
+	c.HTTP.Close()
+	c.grpc.Stop()
+}
+
+func (c *Collector) accept(req *collogspb.ExportLogsServiceRequest, headers map[string]string) codes.Code {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.code != codes.OK {
+		return c.code
+	}
+	c.logs = append(c.logs, req.GetResourceLogs()...)
+	c.headers = append(c.headers, headers)
+	return codes.OK
+}
+
+func (c *Collector) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	var headers = make(map[string]string)
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			headers[key] = values[0]
+		}
+	}
+	if code := c.accept(req, headers); code != codes.OK {
+		return nil, status.Error(code, code.String())
+	}
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+func (c *Collector) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/logs" {
+		http.Error(w, r.Method+" "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req = &collogspb.ExportLogsServiceRequest{}
+	switch r.Header.Get("Content-Type") {
+	case "application/x-protobuf":
+		err = proto.Unmarshal(data, req)
+	case "application/json":
+		err = unmarshalJSON(data, req)
+	default:
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var headers = make(map[string]string)
+	for key := range r.Header {
+		headers[key] = r.Header.Get(key)
+	}
+	var code = c.accept(req, headers)
+	if code == codes.OK && r.Header.Get("Content-Type") == "application/json" {
+		c.mu.Lock()
+		c.bodies = append(c.bodies, data)
+		c.mu.Unlock()
+	}
+	switch code {
+	case codes.OK:
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusOK)
+	case codes.Unavailable:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case codes.ResourceExhausted:
+		w.WriteHeader(http.StatusTooManyRequests)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// unmarshalJSON reads OTLP/JSON, whose trace and span ids are hex
+// where the protobuf JSON mapping expects base64.
+func unmarshalJSON(data []byte, req *collogspb.ExportLogsServiceRequest) error {
+	var decoded map[string]interface{}
+	var decoder = json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return err
+	}
+	for _, resourceLogs := range objects(decoded["resourceLogs"]) {
+		for _, scopeLogs := range objects(resourceLogs["scopeLogs"]) {
+			for _, record := range objects(scopeLogs["logRecords"]) {
+				for _, key := range []string{"traceId", "spanId"} {
+					if id, ok := record[key].(string); ok {
+						raw, err := hex.DecodeString(id)
+						if err != nil {
+							return fmt.Errorf("`%s` is not hex: %w", key, err)
+						}
+						record[key] = base64.StdEncoding.EncodeToString(raw)
+					}
+				}
+			}
+		}
+	}
+	converted, err := json.Marshal(decoded)
+	if err != nil {
+		return err
+	}
+	return protojson.Unmarshal(converted, req)
+}
+
+func objects(v interface{}) []map[string]interface{} {
+	var items, _ = v.([]interface{})
+	var result = make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if object, ok := item.(map[string]interface{}); ok {
+			result = append(result, object)
+		}
+	}
+	return result
+}
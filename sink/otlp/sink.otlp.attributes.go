@@ -0,0 +1,314 @@
+package sink_otlp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"go.uber.org/zap/zapcore"
+)
+
+// severityOf maps zap levels to OTLP severity numbers, following the
+// ranges of the log data model, DPanic sits above Error as ERROR2.
+func severityOf(lvl zapcore.Level) logspb.SeverityNumber {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG
+	case zapcore.InfoLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO
+	case zapcore.WarnLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+	case zapcore.ErrorLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR
+	case zapcore.DPanicLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR2
+	case zapcore.PanicLevel, zapcore.FatalLevel:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL
+	default:
+		if lvl < zapcore.DebugLevel {
+			return logspb.SeverityNumber_SEVERITY_NUMBER_TRACE
+		}
+		return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL4
+	}
+}
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+func intValue(i int64) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+}
+
+// reflectedValue converts values added by zap.Any through their JSON form.
+func reflectedValue(v interface{}) (*commonpb.AnyValue, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return jsonValue(decoded), nil
+}
+
+func jsonValue(v interface{}) *commonpb.AnyValue {
+	switch v := v.(type) {
+	case nil:
+		return &commonpb.AnyValue{}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case float64:
+		if v == float64(int64(v)) {
+			return intValue(int64(v))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case string:
+		return stringValue(v)
+	case []interface{}:
+		var values = make([]*commonpb.AnyValue, 0, len(v))
+		for _, item := range v {
+			values = append(values, jsonValue(item))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case map[string]interface{}:
+		var kvs = make([]*commonpb.KeyValue, 0, len(v))
+		for key, item := range v {
+			kvs = append(kvs, &commonpb.KeyValue{Key: key, Value: jsonValue(item)})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: kvs}}}
+	default:
+		return stringValue(fmt.Sprint(v))
+	}
+}
+
+// attrEncoder is a zapcore.ObjectEncoder collecting fields as OTLP attributes,
+// namespaces become nested key value lists.
+type attrEncoder struct {
+	attrs []*commonpb.KeyValue
+	// path holds the index of each open namespace within its parent list
+	path []int
+}
+
+// clone copies the lists on the namespace path, which are the only ones still appended to.
+func (enc *attrEncoder) clone() *attrEncoder {
+	var clone = &attrEncoder{
+		attrs: append(make([]*commonpb.KeyValue, 0, len(enc.attrs)+8), enc.attrs...),
+		path:  append([]int(nil), enc.path...),
+	}
+	var list = &clone.attrs
+	for _, i := range clone.path {
+		var values = (*list)[i].GetValue().GetKvlistValue().GetValues()
+		var copied = &commonpb.KeyValueList{Values: append([]*commonpb.KeyValue(nil), values...)}
+		(*list)[i] = &commonpb.KeyValue{Key: (*list)[i].Key, Value: &commonpb.AnyValue{
+			Value: &commonpb.AnyValue_KvlistValue{KvlistValue: copied},
+		}}
+		list = &copied.Values
+	}
+	return clone
+}
+
+func (enc *attrEncoder) add(key string, value *commonpb.AnyValue) {
+	var list = &enc.attrs
+	for _, i := range enc.path {
+		list = &(*list)[i].Value.GetKvlistValue().Values
+	}
+	*list = append(*list, &commonpb.KeyValue{Key: key, Value: value})
+}
+
+// addTop adds an attribute outside of any open namespace, used for semantic convention keys.
+func (enc *attrEncoder) addTop(key string, value *commonpb.AnyValue) {
+	enc.attrs = append(enc.attrs, &commonpb.KeyValue{Key: key, Value: value})
+}
+
+func (enc *attrEncoder) AddArray(key string, marshaler zapcore.ArrayMarshaler) error {
+	var arr = &arrEncoder{}
+	var err = marshaler.MarshalLogArray(arr)
+	enc.add(key, &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: arr.values}}})
+	return err
+}
+
+func (enc *attrEncoder) AddObject(key string, marshaler zapcore.ObjectMarshaler) error {
+	var obj = &attrEncoder{}
+	var err = marshaler.MarshalLogObject(obj)
+	enc.add(key, &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: obj.attrs}}})
+	return err
+}
+
+func (enc *attrEncoder) AddBinary(key string, value []byte) {
+	enc.add(key, &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: value}})
+}
+
+func (enc *attrEncoder) AddByteString(key string, value []byte) {
+	enc.add(key, stringValue(string(value)))
+}
+
+func (enc *attrEncoder) AddBool(key string, value bool) {
+	enc.add(key, &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: value}})
+}
+
+func (enc *attrEncoder) AddComplex128(key string, value complex128) {
+	enc.add(key, stringValue(fmt.Sprint(value)))
+}
+
+func (enc *attrEncoder) AddComplex64(key string, value complex64) {
+	enc.AddComplex128(key, complex128(value))
+}
+
+func (enc *attrEncoder) AddDuration(key string, value time.Duration) {
+	enc.add(key, &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value.Seconds()}})
+}
+
+func (enc *attrEncoder) AddFloat64(key string, value float64) {
+	enc.add(key, &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value}})
+}
+
+func (enc *attrEncoder) AddFloat32(key string, value float32) { enc.AddFloat64(key, float64(value)) }
+func (enc *attrEncoder) AddInt(key string, value int)         { enc.AddInt64(key, int64(value)) }
+func (enc *attrEncoder) AddInt64(key string, value int64)     { enc.add(key, intValue(value)) }
+func (enc *attrEncoder) AddInt32(key string, value int32)     { enc.AddInt64(key, int64(value)) }
+func (enc *attrEncoder) AddInt16(key string, value int16)     { enc.AddInt64(key, int64(value)) }
+func (enc *attrEncoder) AddInt8(key string, value int8)       { enc.AddInt64(key, int64(value)) }
+func (enc *attrEncoder) AddString(key, value string)          { enc.add(key, stringValue(value)) }
+func (enc *attrEncoder) AddUint(key string, value uint)       { enc.AddUint64(key, uint64(value)) }
+func (enc *attrEncoder) AddUint32(key string, value uint32)   { enc.AddInt64(key, int64(value)) }
+func (enc *attrEncoder) AddUint16(key string, value uint16)   { enc.AddInt64(key, int64(value)) }
+func (enc *attrEncoder) AddUint8(key string, value uint8)     { enc.AddInt64(key, int64(value)) }
+func (enc *attrEncoder) AddUintptr(key string, value uintptr) { enc.AddUint64(key, uint64(value)) }
+
+// AddUint64 keeps values beyond int64 as strings, OTLP has no unsigned type.
+func (enc *attrEncoder) AddUint64(key string, value uint64) {
+	if value > 1<<63-1 {
+		enc.add(key, stringValue(fmt.Sprint(value)))
+		return
+	}
+	enc.AddInt64(key, int64(value))
+}
+
+func (enc *attrEncoder) AddTime(key string, value time.Time) {
+	enc.add(key, stringValue(value.Format(time.RFC3339Nano)))
+}
+
+func (enc *attrEncoder) AddReflected(key string, value interface{}) error {
+	anyValue, err := reflectedValue(value)
+	if err != nil {
+		return err
+	}
+	enc.add(key, anyValue)
+	return nil
+}
+
+func (enc *attrEncoder) OpenNamespace(key string) {
+	enc.add(key, &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{}}})
+	var list = enc.attrs
+	for _, i := range enc.path {
+		list = list[i].Value.GetKvlistValue().Values
+	}
+	enc.path = append(enc.path, len(list)-1)
+}
+
+// arrEncoder is the zapcore.ArrayEncoder of attrEncoder.
+type arrEncoder struct {
+	values []*commonpb.AnyValue
+}
+
+func (enc *arrEncoder) AppendArray(marshaler zapcore.ArrayMarshaler) error {
+	var arr = &arrEncoder{}
+	var err = marshaler.MarshalLogArray(arr)
+	enc.values = append(enc.values, &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: arr.values}}})
+	return err
+}
+
+func (enc *arrEncoder) AppendObject(marshaler zapcore.ObjectMarshaler) error {
+	var obj = &attrEncoder{}
+	var err = marshaler.MarshalLogObject(obj)
+	enc.values = append(enc.values, &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: obj.attrs}}})
+	return err
+}
+
+func (enc *arrEncoder) AppendReflected(value interface{}) error {
+	anyValue, err := reflectedValue(value)
+	if err != nil {
+		return err
+	}
+	enc.values = append(enc.values, anyValue)
+	return nil
+}
+
+func (enc *arrEncoder) AppendBool(value bool) {
+	enc.values = append(enc.values, &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: value}})
+}
+
+func (enc *arrEncoder) AppendByteString(value []byte) {
+	enc.values = append(enc.values, stringValue(string(value)))
+}
+
+func (enc *arrEncoder) AppendComplex128(value complex128) {
+	enc.values = append(enc.values, stringValue(fmt.Sprint(value)))
+}
+
+func (enc *arrEncoder) AppendDuration(value time.Duration) {
+	enc.values = append(enc.values, &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value.Seconds()}})
+}
+
+func (enc *arrEncoder) AppendFloat64(value float64) {
+	enc.values = append(enc.values, &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value}})
+}
+
+func (enc *arrEncoder) AppendUint64(value uint64) {
+	if value > 1<<63-1 {
+		enc.values = append(enc.values, stringValue(fmt.Sprint(value)))
+		return
+	}
+	enc.AppendInt64(int64(value))
+}
+
+func (enc *arrEncoder) AppendTime(value time.Time) {
+	enc.values = append(enc.values, stringValue(value.Format(time.RFC3339Nano)))
+}
+
+func (enc *arrEncoder) AppendComplex64(value complex64) { enc.AppendComplex128(complex128(value)) }
+func (enc *arrEncoder) AppendFloat32(value float32)     { enc.AppendFloat64(float64(value)) }
+func (enc *arrEncoder) AppendInt(value int)             { enc.AppendInt64(int64(value)) }
+func (enc *arrEncoder) AppendInt64(value int64)         { enc.values = append(enc.values, intValue(value)) }
+func (enc *arrEncoder) AppendInt32(value int32)         { enc.AppendInt64(int64(value)) }
+func (enc *arrEncoder) AppendInt16(value int16)         { enc.AppendInt64(int64(value)) }
+func (enc *arrEncoder) AppendInt8(value int8)           { enc.AppendInt64(int64(value)) }
+func (enc *arrEncoder) AppendString(value string) {
+	enc.values = append(enc.values, stringValue(value))
+}
+func (enc *arrEncoder) AppendUint(value uint)       { enc.AppendUint64(uint64(value)) }
+func (enc *arrEncoder) AppendUint32(value uint32)   { enc.AppendInt64(int64(value)) }
+func (enc *arrEncoder) AppendUint16(value uint16)   { enc.AppendInt64(int64(value)) }
+func (enc *arrEncoder) AppendUint8(value uint8)     { enc.AppendInt64(int64(value)) }
+func (enc *arrEncoder) AppendUintptr(value uintptr) { enc.AppendUint64(uint64(value)) }
+
+// traceIDOf decodes trace and span ids carried as hex strings or raw bytes.
+func traceIDOf(field zapcore.Field, size int) ([]byte, bool) {
+	switch field.Type {
+	case zapcore.BinaryType, zapcore.ByteStringType:
+		if id, ok := field.Interface.([]byte); ok && len(id) == size {
+			return append([]byte(nil), id...), true
+		}
+	case zapcore.StringType:
+		if id, err := hex.DecodeString(field.String); err == nil && len(id) == size {
+			return id, true
+		}
+	}
+	return nil, false
+}
+
+// newRecord fills the fixed part of a log record from e.
+func newRecord(e zapcore.Entry) *logspb.LogRecord { // nolint:gocritic
+	return &logspb.LogRecord{
+		TimeUnixNano:         uint64(e.Time.UnixNano()),
+		ObservedTimeUnixNano: uint64(time.Now().UnixNano()),
+		SeverityNumber:       severityOf(e.Level),
+		SeverityText:         e.Level.CapitalString(),
+		Body:                 stringValue(e.Message),
+	}
+}
@@ -0,0 +1,208 @@
+package sink_otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	OTLPProtocolHTTPProtobuf = "http/protobuf"
+	OTLPProtocolHTTPJSON     = "http/json"
+	OTLPProtocolGRPC         = "grpc"
+	OTLPLogsPath             = "/v1/logs"
+)
+
+// exporter sends export requests to a collector, retryable tells
+// whether the same request may succeed later.
+type exporter interface {
+	export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (retryable bool, err error)
+	setHeaders(headers map[string]string)
+	close() error
+}
+
+type httpExporter struct {
+	target  string
+	json    bool
+	gzip    bool
+	headers map[string]string
+	client  *http.Client
+}
+
+func (e *httpExporter) export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (bool, error) {
+	var body []byte
+	var err error
+	var contentType string
+	if e.json {
+		body, err = marshalJSON(req)
+		contentType = "application/json"
+	} else {
+		body, err = proto.Marshal(req)
+		contentType = "application/x-protobuf"
+	}
+	if err != nil {
+		return false, err
+	}
+	if e.gzip {
+		var buf bytes.Buffer
+		var gz = gzip.NewWriter(&buf)
+		_, _ = gz.Write(body)
+		if err = gz.Close(); err != nil {
+			return false, err
+		}
+		body = buf.Bytes()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for key, value := range e.headers {
+		httpReq.Header.Set(key, value)
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	if e.gzip {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, fmt.Errorf("unexpected response status: %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected response status: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+}
+
+// marshalJSON follows OTLP/JSON where it differs from the protobuf JSON mapping:
+// trace and span ids are hex instead of base64 and enums are integers.
+func marshalJSON(req *collogspb.ExportLogsServiceRequest) ([]byte, error) {
+	body, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]interface{}
+	var decoder = json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err = decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	for _, resourceLogs := range jsonObjects(decoded["resourceLogs"]) {
+		for _, scopeLogs := range jsonObjects(resourceLogs["scopeLogs"]) {
+			for _, record := range jsonObjects(scopeLogs["logRecords"]) {
+				for _, key := range []string{"traceId", "spanId"} {
+					if id, ok := record[key].(string); ok {
+						raw, err := base64.StdEncoding.DecodeString(id)
+						if err != nil {
+							return nil, fmt.Errorf("cant decode `%s`: %w", key, err)
+						}
+						record[key] = hex.EncodeToString(raw)
+					}
+				}
+			}
+		}
+	}
+	return json.Marshal(decoded)
+}
+
+func jsonObjects(v interface{}) []map[string]interface{} {
+	var items, _ = v.([]interface{})
+	var objects = make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if object, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+func (e *httpExporter) setHeaders(headers map[string]string) {
+	e.headers = headers
+}
+
+func (e *httpExporter) close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+type grpcExporter struct {
+	conn    *grpc.ClientConn
+	client  collogspb.LogsServiceClient
+	headers metadata.MD
+	gzip    bool
+}
+
+func newGRPCExporter(target string, insecureConn bool, gzipped bool) (*grpcExporter, error) {
+	var creds = insecure.NewCredentials()
+	if !insecureConn {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	// dial lazily, the collector may start after the application
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcExporter{
+		conn:   conn,
+		client: collogspb.NewLogsServiceClient(conn),
+		gzip:   gzipped,
+	}, nil
+}
+
+func (e *grpcExporter) setHeaders(headers map[string]string) {
+	e.headers = metadata.New(headers)
+}
+
+func (e *grpcExporter) export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (bool, error) {
+	var opts []grpc.CallOption
+	if e.gzip {
+		opts = append(opts, grpc.UseCompressor("gzip"))
+	}
+	if len(e.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, e.headers)
+	}
+	if _, err := e.client.Export(ctx, req, opts...); err != nil {
+		switch status.Code(err) {
+		case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted,
+			codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+			return true, err
+		default:
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (e *grpcExporter) close() error {
+	return e.conn.Close()
+}
+
+// exportContext bounds a single export attempt.
+func exportContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), timeout)
+}
@@ -0,0 +1,584 @@
+package sink_otlp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	OTLPSchema                   = "otlp"
+	OTLPParamTarget              = "target"
+	OTLPParamProtocol            = "protocol"
+	OTLPParamInsecure            = "insecure"
+	OTLPParamCompression         = "compression"
+	OTLPParamResourceAttributes  = "resourceAttributes"
+	OTLPParamTraceIDField        = "traceIdField"
+	OTLPParamSpanIDField         = "spanIdField"
+	OTLPParamBatchCount          = "batchCount"
+	OTLPParamInterval            = "interval"
+	OTLPParamQueueSize           = "queueSize"
+	OTLPParamRetries             = "retries"
+	OTLPParamTimeout             = "timeout"
+	OTLPParamLevel               = "level"
+	OTLPConfigEndpoint           = "Endpoint"
+	OTLPConfigProtocol           = "Protocol"
+	OTLPConfigInsecure           = "Insecure"
+	OTLPConfigCompression        = "Compression"
+	OTLPConfigHeaders            = "Headers"
+	OTLPConfigResourceAttributes = "ResourceAttributes"
+	OTLPConfigServiceName        = "ServiceName"
+	OTLPConfigTraceIDField       = "TraceIDField"
+	OTLPConfigSpanIDField        = "SpanIDField"
+	OTLPConfigBatchCount         = "BatchCount"
+	OTLPConfigInterval           = "FlushInterval"
+	OTLPConfigQueueSize          = "QueueSize"
+	OTLPConfigRetries            = "Retries"
+	OTLPConfigTimeout            = "Timeout"
+	OTLPConfigLevel              = "Level"
+)
+
+const (
+	defaultTraceIDField = "trace_id"
+	defaultSpanIDField  = "span_id"
+	defaultBatchCount   = 512
+	defaultInterval     = time.Second
+	defaultQueueSize    = 8192
+	defaultRetries      = 3
+	defaultTimeout      = 10 * time.Second
+	retryBackoffBase    = 500 * time.Millisecond
+	retryBackoffMaximum = 30 * time.Second
+)
+
+type otlpCore struct {
+	sink    *otlpSink
+	attrs   *attrEncoder
+	traceID []byte
+	spanID  []byte
+}
+
+func (core *otlpCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= core.sink.level
+}
+
+// addFields adds fields to attrs, except trace and span ids which are returned.
+func (core *otlpCore) addFields(attrs *attrEncoder, fields []zapcore.Field) (traceID, spanID []byte) {
+	traceID, spanID = core.traceID, core.spanID
+	for _, field := range fields {
+		switch field.Key {
+		case core.sink.traceIDField:
+			if id, ok := traceIDOf(field, 16); ok {
+				traceID = id
+				continue
+			}
+		case core.sink.spanIDField:
+			if id, ok := traceIDOf(field, 8); ok {
+				spanID = id
+				continue
+			}
+		}
+		if field.Type == zapcore.ErrorType && field.Key == "error" {
+			if err, ok := field.Interface.(error); ok && err != nil {
+				attrs.addTop("exception.message", stringValue(err.Error()))
+				attrs.addTop("exception.type", stringValue(strings.TrimPrefix(fmt.Sprintf("%T", err), "*")))
+				continue
+			}
+		}
+		field.AddTo(attrs)
+	}
+	return traceID, spanID
+}
+
+func (core *otlpCore) With(fields []zapcore.Field) zapcore.Core {
+	var clone = &otlpCore{sink: core.sink, attrs: core.attrs.clone()}
+	clone.traceID, clone.spanID = core.addFields(clone.attrs, fields)
+	return clone
+}
+
+func (core *otlpCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry { // nolint:gocritic
+	if core.Enabled(ent.Level) {
+		return ce.AddCore(ent, core)
+	}
+	return ce
+}
+
+func (core *otlpCore) Write(e zapcore.Entry, fields []zapcore.Field) error { // nolint:gocritic
+	var record = newRecord(e)
+	var attrs = core.attrs.clone()
+	if e.Caller.Defined {
+		attrs.addTop("code.filepath", stringValue(e.Caller.File))
+		attrs.addTop("code.lineno", intValue(int64(e.Caller.Line)))
+		if e.Caller.Function != "" {
+			attrs.addTop("code.function", stringValue(e.Caller.Function))
+		}
+	}
+	if e.Stack != "" {
+		attrs.addTop("exception.stacktrace", stringValue(e.Stack))
+	}
+	record.TraceId, record.SpanId = core.addFields(attrs, fields)
+	record.Attributes = attrs.attrs
+	core.sink.enqueue(otlpRecord{scope: e.LoggerName, record: record})
+	return nil
+}
+
+func (core *otlpCore) Sync() error {
+	return core.sink.Sync()
+}
+
+type otlpRecord struct {
+	scope  string
+	record *logspb.LogRecord
+}
+
+// otlpSink batches log records and exports them from a single goroutine,
+// records are dropped when the queue is full, so logging never blocks on the collector.
+type otlpSink struct {
+	target       string
+	resource     *resourcepb.Resource
+	traceIDField string
+	spanIDField  string
+	batchCount   int
+	interval     time.Duration
+	retries      int
+	timeout      time.Duration
+	level        zapcore.Level
+	exporter     exporter
+	queue        chan otlpRecord
+	flush        chan chan struct{}
+	stop         chan struct{}
+	done         chan struct{}
+	closeOnce    sync.Once
+	dropped      uint64
+	reporter     atomic.Value
+}
+
+func (sink *otlpSink) HijackCore() zapcore.Core {
+	return &otlpCore{sink: sink, attrs: &attrEncoder{}}
+}
+
+func (sink *otlpSink) AcceptReporter(reporter *zap.Logger) {
+	sink.reporter.Store(reporter)
+}
+
+// AcceptConfig receives the headers, which usually carry credentials, so they never appear in the sink url.
+func (sink *otlpSink) AcceptConfig(_ interface{}, argStore func(string) (string, bool)) error {
+	headers, err := parseSecrets(argStore)
+	if err != nil {
+		return err
+	}
+	sink.exporter.setHeaders(headers)
+	return nil
+}
+
+func (sink *otlpSink) report(msg string, fields ...zap.Field) {
+	if reporter, ok := sink.reporter.Load().(*zap.Logger); ok {
+		reporter.Error(msg, append(fields, zap.String("target", sink.target))...)
+	}
+}
+
+func (sink *otlpSink) enqueue(record otlpRecord) {
+	select {
+	case sink.queue <- record:
+	default:
+		if dropped := atomic.AddUint64(&sink.dropped, 1); dropped&(dropped-1) == 0 {
+			sink.report("otlp queue full, records dropped", zap.Uint64("dropped", dropped))
+		}
+	}
+}
+
+func (sink *otlpSink) run() {
+	defer close(sink.done)
+	var ticker = time.NewTicker(sink.interval)
+	defer ticker.Stop()
+	var batch []otlpRecord
+	var send = func() {
+		if len(batch) > 0 {
+			sink.export(batch)
+			batch = nil
+		}
+	}
+	var drain = func() {
+		for {
+			select {
+			case record := <-sink.queue:
+				if batch = append(batch, record); len(batch) >= sink.batchCount {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case record := <-sink.queue:
+			if batch = append(batch, record); len(batch) >= sink.batchCount {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-sink.flush:
+			drain()
+			close(flushed)
+		case <-sink.stop:
+			drain()
+			return
+		}
+	}
+}
+
+// request groups batch by logger name, each logger is an instrumentation scope.
+func (sink *otlpSink) request(batch []otlpRecord) *collogspb.ExportLogsServiceRequest {
+	var scopes []*logspb.ScopeLogs
+	var byName = make(map[string]*logspb.ScopeLogs)
+	for _, item := range batch {
+		var scope, exist = byName[item.scope]
+		if !exist {
+			scope = &logspb.ScopeLogs{Scope: &commonpb.InstrumentationScope{Name: item.scope}}
+			byName[item.scope] = scope
+			scopes = append(scopes, scope)
+		}
+		scope.LogRecords = append(scope.LogRecords, item.record)
+	}
+	return &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{Resource: sink.resource, ScopeLogs: scopes}},
+	}
+}
+
+// export sends a batch, retrying retryable failures with exponential backoff.
+func (sink *otlpSink) export(batch []otlpRecord) {
+	var req = sink.request(batch)
+	var err error
+	var backoff = retryBackoffBase
+	for attempt := 0; ; attempt++ {
+		var retryable bool
+		var ctx, cancel = exportContext(sink.timeout)
+		retryable, err = sink.exporter.export(ctx, req)
+		cancel()
+		if err == nil {
+			return
+		}
+		if !retryable || attempt >= sink.retries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-sink.stop:
+			// closing, use the remaining attempts without waiting
+		}
+		if backoff *= 2; backoff > retryBackoffMaximum {
+			backoff = retryBackoffMaximum
+		}
+	}
+	sink.report("otlp export failed", zap.Int("records", len(batch)), zap.Error(err))
+}
+
+func (sink *otlpSink) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("use *otlpCore instead")
+}
+
+// Sync waits until the records queued so far are exported.
+func (sink *otlpSink) Sync() error {
+	var flushed = make(chan struct{})
+	select {
+	case sink.flush <- flushed:
+		<-flushed
+	case <-sink.done:
+	}
+	return nil
+}
+
+func (sink *otlpSink) Close() error {
+	var err error
+	sink.closeOnce.Do(func() {
+		close(sink.stop)
+		<-sink.done
+		err = sink.exporter.close()
+	})
+	return err
+}
+
+// parsePairs parses `key=value,key2=value2` as used by OTEL_RESOURCE_ATTRIBUTES
+// and OTEL_EXPORTER_OTLP_HEADERS, values are url decoded.
+func parsePairs(text string) (map[string]string, error) {
+	var pairs = make(map[string]string)
+	for _, pair := range strings.Split(text, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		var kv = strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("cant parse `%s`: expect key=value", pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("cant parse `%s`: %w", pair, err)
+		}
+		pairs[strings.TrimSpace(kv[0])] = value
+	}
+	return pairs, nil
+}
+
+func parseCount(params url.Values, key string, def, min int) (int, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := strconv.Atoi(text)
+	if err != nil || val < min {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+func parseDuration(params url.Values, key string, def time.Duration) (time.Duration, error) {
+	var text = params.Get(key)
+	if text == "" {
+		return def, nil
+	}
+	val, err := time.ParseDuration(text)
+	if err != nil || val <= 0 {
+		return 0, fmt.Errorf("invalid arg `%s`: %s", key, text)
+	}
+	return val, nil
+}
+
+// parseOptions parses the url params shared by urlGenerator.Generate and register.
+func parseOptions(params url.Values, sink *otlpSink) (queueSize int, err error) {
+	var attributes map[string]string
+	if attributes, err = parsePairs(params.Get(OTLPParamResourceAttributes)); err != nil {
+		return 0, err
+	}
+	var keys = make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sink.resource = &resourcepb.Resource{}
+	for _, key := range keys {
+		sink.resource.Attributes = append(sink.resource.Attributes, &commonpb.KeyValue{Key: key, Value: stringValue(attributes[key])})
+	}
+	if sink.traceIDField = params.Get(OTLPParamTraceIDField); sink.traceIDField == "" {
+		sink.traceIDField = defaultTraceIDField
+	}
+	if sink.spanIDField = params.Get(OTLPParamSpanIDField); sink.spanIDField == "" {
+		sink.spanIDField = defaultSpanIDField
+	}
+	if sink.batchCount, err = parseCount(params, OTLPParamBatchCount, defaultBatchCount, 1); err != nil {
+		return 0, err
+	}
+	if queueSize, err = parseCount(params, OTLPParamQueueSize, defaultQueueSize, 1); err != nil {
+		return 0, err
+	}
+	if sink.retries, err = parseCount(params, OTLPParamRetries, defaultRetries, 0); err != nil {
+		return 0, err
+	}
+	if sink.interval, err = parseDuration(params, OTLPParamInterval, defaultInterval); err != nil {
+		return 0, err
+	}
+	if sink.timeout, err = parseDuration(params, OTLPParamTimeout, defaultTimeout); err != nil {
+		return 0, err
+	}
+	sink.level = zapcore.DebugLevel
+	if levelVal := params.Get(OTLPParamLevel); levelVal != "" {
+		if err = sink.level.UnmarshalText([]byte(levelVal)); err != nil {
+			return 0, fmt.Errorf("cant parse arg `%s`: %w", OTLPParamLevel, err)
+		}
+	}
+	return queueSize, nil
+}
+
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var params = logPath.Query()
+	var _sink = &otlpSink{
+		target: params.Get(OTLPParamTarget),
+		flush:  make(chan chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if _sink.target == "" {
+		return nil, fmt.Errorf("undefined arg `%s`", OTLPParamTarget)
+	}
+	var queueSize int
+	if queueSize, err = parseOptions(params, _sink); err != nil {
+		return nil, err
+	}
+	var gzipped = params.Get(OTLPParamCompression) == "gzip"
+	switch protocol := params.Get(OTLPParamProtocol); protocol {
+	case "", OTLPProtocolHTTPProtobuf, OTLPProtocolHTTPJSON:
+		_sink.exporter = &httpExporter{
+			target: _sink.target,
+			json:   protocol == OTLPProtocolHTTPJSON,
+			gzip:   gzipped,
+			client: &http.Client{},
+		}
+	case OTLPProtocolGRPC:
+		if _sink.exporter, err = newGRPCExporter(_sink.target, params.Get(OTLPParamInsecure) == "true", gzipped); err != nil {
+			return nil, fmt.Errorf("cant dial `%s`: %w", _sink.target, err)
+		}
+	default:
+		return nil, fmt.Errorf("cant parse arg `%s`: %s", OTLPParamProtocol, protocol)
+	}
+	_sink.queue = make(chan otlpRecord, queueSize)
+	go _sink.run()
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(OTLPSchema, register); err != nil {
+		panic(fmt.Errorf("cant register otlp sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic   string
+	service string
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic:   topic,
+		service: g.service,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return OTLPSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var ok bool
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: OTLPSchema, Host: "localhost"}
+	var protocol = OTLPProtocolHTTPProtobuf
+	if protocolVal, exist := argStore(OTLPConfigProtocol); exist && protocolVal != "" {
+		switch protocol = strings.ToLower(strings.TrimSpace(protocolVal)); protocol {
+		case OTLPProtocolHTTPProtobuf, OTLPProtocolHTTPJSON, OTLPProtocolGRPC:
+			outputQuery.Set(OTLPParamProtocol, protocol)
+		default:
+			return "", fmt.Errorf("unsupported `%s`: %s", OTLPConfigProtocol, protocolVal)
+		}
+	}
+	var insecureConn bool
+	if insecureVal, exist := argStore(OTLPConfigInsecure); exist {
+		var err error
+		if insecureConn, err = strconv.ParseBool(strings.TrimSpace(insecureVal)); err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", OTLPConfigInsecure, err)
+		}
+	}
+	{
+		var endpoint string
+		if endpoint, ok = argStore(OTLPConfigEndpoint); !ok || endpoint == "" {
+			return "", fmt.Errorf("`%s` not optional", OTLPConfigEndpoint)
+		}
+		if protocol == OTLPProtocolGRPC {
+			// grpc endpoints are host:port, a http scheme only selects plaintext
+			if strings.HasPrefix(endpoint, "http://") {
+				insecureConn = true
+			}
+			endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
+			outputQuery.Set(OTLPParamTarget, strings.TrimSuffix(endpoint, "/"))
+		} else {
+			targetURL, err := url.Parse(endpoint)
+			if err != nil {
+				return "", fmt.Errorf("cant parse `%s`: %w", OTLPConfigEndpoint, err)
+			} else if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+				return "", fmt.Errorf("unsupported `%s` scheme: %s", OTLPConfigEndpoint, targetURL.Scheme)
+			}
+			if !strings.HasSuffix(targetURL.Path, OTLPLogsPath) {
+				targetURL.Path = strings.TrimSuffix(targetURL.Path, "/") + OTLPLogsPath
+			}
+			outputQuery.Set(OTLPParamTarget, targetURL.String())
+		}
+	}
+	if insecureConn {
+		outputQuery.Set(OTLPParamInsecure, "true")
+	}
+	if compression, exist := argStore(OTLPConfigCompression); exist && compression != "" {
+		switch compression = strings.ToLower(strings.TrimSpace(compression)); compression {
+		case "gzip":
+			outputQuery.Set(OTLPParamCompression, compression)
+		case "none":
+		default:
+			return "", fmt.Errorf("unsupported `%s`: %s", OTLPConfigCompression, compression)
+		}
+	}
+	{
+		var attributes = map[string]string{}
+		if text, exist := argStore(OTLPConfigResourceAttributes); exist && text != "" {
+			var err error
+			if attributes, err = parsePairs(text); err != nil {
+				return "", fmt.Errorf("cant parse `%s`: %w", OTLPConfigResourceAttributes, err)
+			}
+		}
+		if service, exist := argStore(OTLPConfigServiceName); exist && service != "" {
+			attributes["service.name"] = service
+		} else if _, exist = attributes["service.name"]; !exist {
+			if g.service != "" {
+				attributes["service.name"] = g.service
+			} else {
+				attributes["service.name"] = "unknown_service:" + filepath.Base(os.Args[0])
+			}
+		}
+		var pairs = make([]string, 0, len(attributes))
+		for key, value := range attributes {
+			pairs = append(pairs, key+"="+url.QueryEscape(value))
+		}
+		outputQuery.Set(OTLPParamResourceAttributes, strings.Join(pairs, ","))
+	}
+	for config, param := range map[string]string{
+		OTLPConfigTraceIDField: OTLPParamTraceIDField,
+		OTLPConfigSpanIDField:  OTLPParamSpanIDField,
+		OTLPConfigBatchCount:   OTLPParamBatchCount,
+		OTLPConfigInterval:     OTLPParamInterval,
+		OTLPConfigQueueSize:    OTLPParamQueueSize,
+		OTLPConfigRetries:      OTLPParamRetries,
+		OTLPConfigTimeout:      OTLPParamTimeout,
+		OTLPConfigLevel:        OTLPParamLevel,
+	} {
+		if val, exist := argStore(config); exist && val != "" {
+			outputQuery.Set(param, strings.TrimSpace(val))
+		}
+	}
+	if _, err := parseOptions(outputQuery, &otlpSink{}); err != nil {
+		return "", err
+	}
+	if _, err := parseSecrets(argStore); err != nil {
+		return "", err
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+// parseSecrets parses the headers sent with every export.
+func parseSecrets(argStore func(string) (string, bool)) (headers map[string]string, err error) {
+	if text, exist := argStore(OTLPConfigHeaders); exist && text != "" {
+		if headers, err = parsePairs(text); err != nil {
+			return nil, fmt.Errorf("cant parse `%s`: %w", OTLPConfigHeaders, err)
+		}
+	}
+	return headers, nil
+}
+
+func NewURLGenerator(service string) *urlGenerator {
+	return &urlGenerator{service: service}
+}
@@ -0,0 +1,191 @@
+package sink_otlp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/lipence/log-zap/sink/otlp/otlptest"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	testTraceID = "0102030405060708090a0b0c0d0e0f10"
+	testSpanID  = "0102030405060708"
+)
+
+// openSink opens a sink of the service demo exporting to endpoint, keys set the
+// other topic config.
+func openSink(t *testing.T, endpoint string, keys map[string]string) (*zap.Logger, *otlpSink, *observer.ObservedLogs) {
+	t.Helper()
+	var argStore = func(key string) (string, bool) {
+		if key == OTLPConfigEndpoint {
+			return endpoint, true
+		}
+		val, ok := keys[key]
+		return val, ok
+	}
+	var g = NewURLGenerator("demo")
+	rawURL, err := g.Generate(argStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_sink, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink = _sink.(*otlpSink)
+	var reporterCore, reports = observer.New(zapcore.DebugLevel)
+	sink.AcceptReporter(zap.New(reporterCore))
+	if err = sink.AcceptConfig(g, argStore); err != nil {
+		t.Fatal(err)
+	}
+	return zap.New(sink.HijackCore()), sink, reports
+}
+
+func newCollector(t *testing.T) *otlptest.Collector {
+	t.Helper()
+	var collector, err = otlptest.NewCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collector
+}
+
+func TestExportProtocols(t *testing.T) {
+	var collector = newCollector(t)
+	defer collector.Close()
+	for _, protocol := range []string{OTLPProtocolHTTPProtobuf, OTLPProtocolHTTPJSON, OTLPProtocolGRPC} {
+		var endpoint = collector.HTTPEndpoint()
+		if protocol == OTLPProtocolGRPC {
+			endpoint = collector.GRPCEndpoint()
+		}
+		var before = len(collector.Records())
+		var log, sink, _ = openSink(t, endpoint, map[string]string{
+			OTLPConfigProtocol:           protocol,
+			OTLPConfigCompression:        "gzip",
+			OTLPConfigHeaders:            "x-api-key=s3cr%20t",
+			OTLPConfigResourceAttributes: "deployment.environment=dev",
+		})
+		log.Named("svc").With(zap.String("trace_id", testTraceID)).
+			Warn("hello", zap.String("span_id", testSpanID), zap.Int("n", 1))
+		log.Error("failed", zap.Error(errors.New("boom")))
+		_ = sink.Sync()
+		_ = sink.Close()
+
+		var records = collector.Records()[before:]
+		if len(records) != 2 {
+			t.Fatalf("%s: got %d records, want 2", protocol, len(records))
+		}
+		var warn, failed = records[0], records[1]
+		if warn.GetSeverityNumber() != logspb.SeverityNumber_SEVERITY_NUMBER_WARN || warn.GetSeverityText() != "WARN" ||
+			warn.GetBody().GetStringValue() != "hello" {
+			t.Errorf("%s: unexpected record %v", protocol, warn)
+		}
+		if hex.EncodeToString(warn.GetTraceId()) != testTraceID || hex.EncodeToString(warn.GetSpanId()) != testSpanID {
+			t.Errorf("%s: trace id %x, span id %x", protocol, warn.GetTraceId(), warn.GetSpanId())
+		}
+		if attrs := attributes(warn); attrs["n"] != "1" || attrs["trace_id"] != "" {
+			t.Errorf("%s: unexpected attributes %v", protocol, attrs)
+		}
+		if attrs := attributes(failed); attrs["exception.message"] != "boom" {
+			t.Errorf("%s: unexpected error attributes %v", protocol, attrs)
+		}
+		var resourceLogs = collector.ResourceLogs()
+		var resource = map[string]string{}
+		for _, kv := range resourceLogs[len(resourceLogs)-1].GetResource().GetAttributes() {
+			resource[kv.GetKey()] = kv.GetValue().GetStringValue()
+		}
+		if resource["service.name"] != "demo" || resource["deployment.environment"] != "dev" {
+			t.Errorf("%s: unexpected resource %v", protocol, resource)
+		}
+		var headers = collector.Headers()
+		var last = headers[len(headers)-1]
+		if last["x-api-key"] != "s3cr t" && last["X-Api-Key"] != "s3cr t" {
+			t.Errorf("%s: headers %v lack x-api-key", protocol, last)
+		}
+	}
+}
+
+// attributes renders string and int attribute values of record.
+func attributes(record *logspb.LogRecord) map[string]string {
+	var attrs = map[string]string{}
+	for _, kv := range record.GetAttributes() {
+		switch value := kv.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			attrs[kv.GetKey()] = value.StringValue
+		case *commonpb.AnyValue_IntValue:
+			attrs[kv.GetKey()] = strconv.FormatInt(value.IntValue, 10)
+		}
+	}
+	return attrs
+}
+
+// TestJSONBodyShape checks the OTLP/JSON specifics: hex ids and integer enums.
+func TestJSONBodyShape(t *testing.T) {
+	var collector = newCollector(t)
+	defer collector.Close()
+	var log, sink, _ = openSink(t, collector.HTTPEndpoint(), map[string]string{
+		OTLPConfigProtocol: OTLPProtocolHTTPJSON,
+	})
+	log.Warn("hello", zap.String("trace_id", testTraceID), zap.String("span_id", testSpanID))
+	_ = sink.Close()
+	var bodies = collector.JSONBodies()
+	if len(bodies) != 1 {
+		t.Fatalf("got %d json bodies, want 1", len(bodies))
+	}
+	var body struct {
+		ResourceLogs []struct {
+			ScopeLogs []struct {
+				LogRecords []map[string]json.RawMessage `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	if err := json.Unmarshal(bodies[0], &body); err != nil {
+		t.Fatal(err)
+	}
+	var record = body.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	for key, want := range map[string]string{
+		"traceId":        `"` + testTraceID + `"`,
+		"spanId":         `"` + testSpanID + `"`,
+		"severityNumber": "13",
+		"severityText":   `"WARN"`,
+		"body":           `{"stringValue":"hello"}`,
+	} {
+		if got := string(bytes.TrimSpace(record[key])); got != want {
+			t.Errorf("%s = %s, want %s", key, got, want)
+		}
+	}
+}
+
+func TestExportFailureReported(t *testing.T) {
+	var collector = newCollector(t)
+	defer collector.Close()
+	collector.Fail(codes.InvalidArgument)
+	var log, sink, reports = openSink(t, collector.HTTPEndpoint(), nil)
+	log.Info("rejected")
+	_ = sink.Close()
+	var failed = reports.FilterMessage("otlp export failed").AllUntimed()
+	if len(failed) != 1 {
+		t.Fatalf("got %d failure reports, want 1", len(failed))
+	}
+	if fields := failed[0].ContextMap(); fields["records"] != int64(1) {
+		t.Errorf("unexpected failure report %v", fields)
+	}
+	if records := collector.Records(); len(records) != 0 {
+		t.Errorf("collector accepted %d records while failing", len(records))
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/lipence/log-zap/sink/ring
+
+go 1.17
+
+require go.uber.org/zap v1.21.0
+
+require (
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+)
@@ -0,0 +1,154 @@
+package sink_ring
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Entry is a log entry kept by Buffer.
+type Entry struct {
+	Seq     uint64                 `json:"seq"`
+	Time    time.Time              `json:"time"`
+	Level   zapcore.Level          `json:"level"`
+	Logger  string                 `json:"logger,omitempty"`
+	Caller  string                 `json:"caller,omitempty"`
+	Message string                 `json:"msg"`
+	Stack   string                 `json:"stacktrace,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Query selects entries of Buffer, zero values match everything.
+type Query struct {
+	// Level matches entries enabled by it.
+	Level zapcore.LevelEnabler
+	// Logger matches the named logger and its children.
+	Logger string
+	// Since and Until bound the entry time, both inclusive.
+	Since time.Time
+	Until time.Time
+	// Fields matches entries whose fields print as the given values.
+	Fields map[string]string
+	// After matches entries with a greater Seq, to resume a previous query.
+	After uint64
+	// Limit keeps the newest entries only.
+	Limit int
+}
+
+// Match reports whether e is selected by q.
+func (q *Query) Match(e *Entry) bool {
+	if q.Level != nil && !q.Level.Enabled(e.Level) {
+		return false
+	}
+	if q.Logger != "" && e.Logger != q.Logger && !strings.HasPrefix(e.Logger, q.Logger+".") {
+		return false
+	}
+	if e.Seq <= q.After ||
+		(!q.Since.IsZero() && e.Time.Before(q.Since)) ||
+		(!q.Until.IsZero() && e.Time.After(q.Until)) {
+		return false
+	}
+	for key, val := range q.Fields {
+		if field, exist := e.Fields[key]; !exist || fmt.Sprint(field) != val {
+			return false
+		}
+	}
+	return true
+}
+
+// Buffer keeps the last entries written by ring topics in memory,
+// the oldest entry is overwritten once the buffer is full.
+type Buffer struct {
+	mu          sync.RWMutex
+	entries     []Entry
+	next        int
+	full        bool
+	seq         uint64
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	query   Query
+	entries chan Entry
+}
+
+func NewBuffer(size int) *Buffer {
+	if size <= 0 {
+		panic(fmt.Errorf("invalid ring buffer size: %d", size))
+	}
+	return &Buffer{
+		entries:     make([]Entry, size),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+func (b *Buffer) add(e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	e.Seq = b.seq
+	b.entries[b.next] = e
+	if b.next++; b.next == len(b.entries) {
+		b.next, b.full = 0, true
+	}
+	for s := range b.subscribers {
+		if s.query.Match(&e) {
+			select {
+			case s.entries <- e:
+			default:
+				// slow subscribers miss entries instead of blocking the logger
+			}
+		}
+	}
+}
+
+// Query returns the entries selected by q, oldest first.
+func (b *Buffer) Query(q Query) []Entry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var entries []Entry
+	var start, count = 0, b.next
+	if b.full {
+		start, count = b.next, len(b.entries)
+	}
+	for i := 0; i < count; i++ {
+		if e := &b.entries[(start+i)%len(b.entries)]; q.Match(e) {
+			entries = append(entries, *e)
+		}
+	}
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries
+}
+
+// Subscribe streams entries selected by q as they are written, entries are
+// dropped while the channel (of the given capacity) is full. cancel closes it.
+func (b *Buffer) Subscribe(q Query, capacity int) (entries <-chan Entry, cancel func()) {
+	var s = &subscriber{query: q, entries: make(chan Entry, capacity)}
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return s.entries, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, s)
+			b.mu.Unlock()
+			close(s.entries)
+		})
+	}
+}
+
+// Len returns the count of entries kept.
+func (b *Buffer) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.full {
+		return len(b.entries)
+	}
+	return b.next
+}
@@ -0,0 +1,234 @@
+package sink_ring
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	RingSchema      = "ring"
+	RingParamLevel  = "level"
+	RingConfigLevel = "Level"
+)
+
+type ringCore struct {
+	sink    *ringSink
+	context map[string]interface{}
+}
+
+func (core *ringCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= core.sink.level
+}
+
+func (core *ringCore) With(fields []zapcore.Field) zapcore.Core {
+	return &ringCore{sink: core.sink, context: core.encode(fields)}
+}
+
+func (core *ringCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry { // nolint:gocritic
+	if core.Enabled(ent.Level) {
+		return ce.AddCore(ent, core)
+	}
+	return ce
+}
+
+// encode adds fields to a copy of the context, so siblings never share it.
+// Values are detached from the caller and made json safe as they are written,
+// entries are read by handlers long after the log call returned.
+func (core *ringCore) encode(fields []zapcore.Field) map[string]interface{} {
+	if len(fields) == 0 {
+		return core.context
+	}
+	var enc = zapcore.NewMapObjectEncoder()
+	for i := range fields {
+		fields[i].AddTo(enc)
+	}
+	var context = make(map[string]interface{}, len(core.context)+len(enc.Fields))
+	for key, val := range core.context {
+		context[key] = val
+	}
+	for key, val := range enc.Fields {
+		context[key] = jsonSafe(val)
+	}
+	return context
+}
+
+// jsonSafe copies the values collected by zapcore.MapObjectEncoder, printing
+// the floats and complex numbers json cant hold, and decoding reflected values
+// from their json, so nothing refers to the data of the caller.
+func jsonSafe(val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr,
+		time.Time, time.Duration:
+		return v
+	case float64:
+		return jsonSafeFloat(v, 64)
+	case float32:
+		return jsonSafeFloat(float64(v), 32)
+	case complex128:
+		return strconv.FormatComplex(v, 'g', -1, 128)
+	case complex64:
+		return strconv.FormatComplex(complex128(v), 'g', -1, 64)
+	case []byte:
+		return append([]byte(nil), v...)
+	case map[string]interface{}:
+		var copied = make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			copied[key] = jsonSafe(elem)
+		}
+		return copied
+	case []interface{}:
+		var copied = make([]interface{}, len(v))
+		for i, elem := range v {
+			copied[i] = jsonSafe(elem)
+		}
+		return copied
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("cant encode %T: %v", v, err)
+		}
+		var decoder = json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var decoded interface{}
+		if err = decoder.Decode(&decoded); err != nil {
+			return fmt.Sprintf("cant encode %T: %v", v, err)
+		}
+		return decoded
+	}
+}
+
+func jsonSafeFloat(f float64, bitSize int) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, bitSize)
+	}
+	if bitSize == 32 {
+		return float32(f)
+	}
+	return f
+}
+
+func (core *ringCore) Write(e zapcore.Entry, fields []zapcore.Field) error { // nolint:gocritic
+	var entry = Entry{
+		Time:    e.Time,
+		Level:   e.Level,
+		Logger:  e.LoggerName,
+		Message: e.Message,
+		Stack:   e.Stack,
+		Fields:  core.encode(fields),
+	}
+	if e.Caller.Defined {
+		entry.Caller = e.Caller.TrimmedPath()
+	}
+	if core.sink.buffer == nil {
+		return fmt.Errorf("undefined ring buffer")
+	}
+	core.sink.buffer.add(entry)
+	return nil
+}
+
+func (core *ringCore) Sync() error {
+	return nil
+}
+
+type ringSink struct {
+	buffer *Buffer
+	level  zapcore.Level
+}
+
+func (sink *ringSink) HijackCore() zapcore.Core {
+	return &ringCore{sink: sink}
+}
+
+// AcceptConfig receives the buffer of urlGenerator.
+func (sink *ringSink) AcceptConfig(generator interface{}, _ func(string) (string, bool)) error {
+	if g, ok := generator.(*urlGenerator); ok {
+		sink.buffer = g.buffer
+	}
+	if sink.buffer == nil {
+		return fmt.Errorf("undefined ring buffer")
+	}
+	return nil
+}
+
+func (sink *ringSink) Write(_ []byte) (int, error) {
+	return 0, fmt.Errorf("use *ringCore instead")
+}
+
+func (sink *ringSink) Sync() error {
+	return nil
+}
+
+// Close keeps the buffer, entries stay queryable after the logger is closed.
+func (sink *ringSink) Close() error {
+	return nil
+}
+
+func register(logPath *url.URL) (zap.Sink, error) {
+	var params = logPath.Query()
+	var sink = &ringSink{level: zapcore.DebugLevel}
+	if levelVal := params.Get(RingParamLevel); levelVal != "" {
+		if err := sink.level.UnmarshalText([]byte(levelVal)); err != nil {
+			return nil, fmt.Errorf("cant parse arg `%s`: %w", RingParamLevel, err)
+		}
+	}
+	return sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(RingSchema, register); err != nil {
+		panic(fmt.Errorf("cant register ring sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic  string
+	buffer *Buffer
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic:  topic,
+		buffer: g.buffer,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return RingSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: RingSchema, Host: "localhost"}
+	if g.buffer == nil {
+		return "", fmt.Errorf("unspecificed ring buffer")
+	}
+	if levelVal, ok := argStore(RingConfigLevel); ok && levelVal != "" {
+		var lvl zapcore.Level
+		if err := lvl.UnmarshalText([]byte(strings.TrimSpace(levelVal))); err != nil {
+			return "", fmt.Errorf("cant parse `%s`: %w", RingConfigLevel, err)
+		}
+		outputQuery.Set(RingParamLevel, lvl.String())
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+// NewURLGenerator creates the generator of topics writing to buffer,
+// topics may share a buffer.
+func NewURLGenerator(buffer *Buffer) *urlGenerator {
+	return &urlGenerator{
+		buffer: buffer,
+	}
+}
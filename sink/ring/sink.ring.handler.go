@@ -0,0 +1,160 @@
+package sink_ring
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	RingQueryLevel   = "level"
+	RingQueryLogger  = "logger"
+	RingQuerySince   = "since"
+	RingQueryUntil   = "until"
+	RingQueryField   = "field"
+	RingQueryAfter   = "after"
+	RingQueryLimit   = "limit"
+	RingQueryFormat  = "format"
+	RingQueryFollow  = "follow"
+	RingFormatJSON   = "json"
+	RingFormatNDJSON = "ndjson"
+	ringTailCapacity = 256
+	ringFieldsError  = "fieldsError"
+)
+
+// ServeHTTP serves the entries selected by the request query:
+//
+//	level=warn                 entries at warn level or above
+//	logger=svc                 entries of logger svc and its children
+//	since=5m, until=<rfc3339>  time range, durations are relative to now
+//	field=key:value            repeatable field match
+//	after=<seq>, limit=100     paging
+//	format=json|ndjson         a json array (default) or one entry per line
+//	follow=true                keeps streaming new entries as ndjson
+func (b *Buffer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var q, err = parseQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var params = r.URL.Query()
+	var follow = params.Get(RingQueryFollow) == "true"
+	var format = params.Get(RingQueryFormat)
+	switch {
+	case format == "" && !follow, format == RingFormatJSON && !follow:
+		w.Header().Set("Content-Type", "application/json")
+		var body = []byte{'['}
+		for i, e := range b.Query(q) {
+			if i > 0 {
+				body = append(body, ',')
+			}
+			body = append(body, marshalEntry(e)...)
+		}
+		_, _ = w.Write(append(body, ']', '\n'))
+		return
+	case format == "", format == RingFormatNDJSON:
+	default:
+		http.Error(w, fmt.Sprintf("unsupported %s: %s", RingQueryFormat, format), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	var tail <-chan Entry
+	if follow {
+		// subscribe before querying, so entries between both are not missed
+		var cancel func()
+		tail, cancel = b.Subscribe(Query{Level: q.Level, Logger: q.Logger, Fields: q.Fields}, ringTailCapacity)
+		defer cancel()
+	}
+	for _, e := range b.Query(q) {
+		if _, err = w.Write(append(marshalEntry(e), '\n')); err != nil {
+			return
+		}
+		q.After = e.Seq
+	}
+	if !follow {
+		return
+	}
+	var flusher, _ = w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case e := <-tail:
+			if q.Match(&e) {
+				if _, err = w.Write(append(marshalEntry(e), '\n')); err != nil {
+					return
+				}
+				q.After = e.Seq
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// marshalEntry encodes e, fields which cant be encoded are replaced by the
+// error, so a single entry neither fails the response nor ends a stream.
+func marshalEntry(e Entry) []byte {
+	data, err := json.Marshal(e)
+	if err != nil {
+		e.Fields = map[string]interface{}{ringFieldsError: err.Error()}
+		data, _ = json.Marshal(e)
+	}
+	return data
+}
+
+func parseQuery(r *http.Request) (q Query, err error) {
+	var params = r.URL.Query()
+	if levelVal := params.Get(RingQueryLevel); levelVal != "" {
+		var lvl zapcore.Level
+		if err = lvl.UnmarshalText([]byte(levelVal)); err != nil {
+			return q, fmt.Errorf("invalid %s: %s", RingQueryLevel, levelVal)
+		}
+		q.Level = lvl
+	}
+	q.Logger = params.Get(RingQueryLogger)
+	if q.Since, err = parseTime(params.Get(RingQuerySince)); err != nil {
+		return q, fmt.Errorf("invalid %s: %w", RingQuerySince, err)
+	}
+	if q.Until, err = parseTime(params.Get(RingQueryUntil)); err != nil {
+		return q, fmt.Errorf("invalid %s: %w", RingQueryUntil, err)
+	}
+	for _, field := range params[RingQueryField] {
+		var sep = strings.IndexByte(field, ':')
+		if sep <= 0 {
+			return q, fmt.Errorf("invalid %s: %s", RingQueryField, field)
+		}
+		if q.Fields == nil {
+			q.Fields = make(map[string]string)
+		}
+		q.Fields[field[:sep]] = field[sep+1:]
+	}
+	if afterVal := params.Get(RingQueryAfter); afterVal != "" {
+		if q.After, err = strconv.ParseUint(afterVal, 10, 64); err != nil {
+			return q, fmt.Errorf("invalid %s: %s", RingQueryAfter, afterVal)
+		}
+	}
+	if limitVal := params.Get(RingQueryLimit); limitVal != "" {
+		if q.Limit, err = strconv.Atoi(limitVal); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid %s: %s", RingQueryLimit, limitVal)
+		}
+	}
+	return q, nil
+}
+
+// parseTime accepts RFC 3339 times, and durations meaning that long ago.
+func parseTime(text string) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(text); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339Nano, text)
+}
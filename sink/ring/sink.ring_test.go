@@ -0,0 +1,301 @@
+package sink_ring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func newLogger(t *testing.T, buffer *Buffer, level string) *zap.Logger {
+	t.Helper()
+	var g = NewURLGenerator(buffer).WithTopic("ring")
+	rawURL, err := g.Generate(func(key string) (string, bool) {
+		return level, key == RingConfigLevel && level != ""
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.(*ringSink).AcceptConfig(g, nil); err != nil {
+		t.Fatal(err)
+	}
+	return zap.New(sink.(*ringSink).HijackCore())
+}
+
+func messages(entries []Entry) []string {
+	var msgs = []string{}
+	for _, e := range entries {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
+func TestBufferWrapsAround(t *testing.T) {
+	var buffer = NewBuffer(3)
+	var log = newLogger(t, buffer, "info")
+	log.Debug("dropped")
+	for _, msg := range []string{"a", "b", "c", "d", "e"} {
+		log.Info(msg)
+	}
+	var entries = buffer.Query(Query{})
+	if got := messages(entries); !reflect.DeepEqual(got, []string{"c", "d", "e"}) || buffer.Len() != 3 {
+		t.Fatalf("kept %q of %d entries, want the last 3", got, buffer.Len())
+	}
+	if entries[0].Seq != 3 || entries[2].Seq != 5 {
+		t.Errorf("unexpected seq %d..%d", entries[0].Seq, entries[2].Seq)
+	}
+	if got := messages(buffer.Query(Query{Limit: 2})); !reflect.DeepEqual(got, []string{"d", "e"}) {
+		t.Errorf("limit kept %q", got)
+	}
+	if got := messages(buffer.Query(Query{After: 4})); !reflect.DeepEqual(got, []string{"e"}) {
+		t.Errorf("after kept %q", got)
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	var buffer = NewBuffer(10)
+	var log = newLogger(t, buffer, "")
+	log.Named("db").Info("query", zap.String("table", "users"), zap.Int("rows", 2))
+	log.Named("db").Named("pool").Warn("exhausted")
+	log.Named("dbx").Error("failed", zap.String("table", "orders"))
+	var entries = buffer.Query(Query{})
+	var middle = entries[1].Time
+	for name, c := range map[string]struct {
+		query Query
+		want  []string
+	}{
+		"all":    {Query{}, []string{"query", "exhausted", "failed"}},
+		"level":  {Query{Level: zapcore.WarnLevel}, []string{"exhausted", "failed"}},
+		"logger": {Query{Logger: "db"}, []string{"query", "exhausted"}},
+		"fields": {Query{Fields: map[string]string{"table": "users", "rows": "2"}}, []string{"query"}},
+		"since":  {Query{Since: middle}, []string{"exhausted", "failed"}},
+		"until":  {Query{Until: middle}, []string{"query", "exhausted"}},
+		"none":   {Query{Fields: map[string]string{"table": "accounts"}}, []string{}},
+	} {
+		if got := messages(buffer.Query(c.query)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %q, want %q", name, got, c.want)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	var buffer = NewBuffer(10)
+	var log = newLogger(t, buffer, "")
+	var entries, cancel = buffer.Subscribe(Query{Level: zapcore.WarnLevel}, 1)
+	log.Info("filtered")
+	log.Warn("first")
+	log.Warn("dropped while full")
+	if e := <-entries; e.Message != "first" {
+		t.Errorf("received %q, want first", e.Message)
+	}
+	cancel()
+	cancel()
+	if _, open := <-entries; open {
+		t.Error("entries not closed by cancel")
+	}
+	log.Warn("after cancel")
+}
+
+type reflected struct {
+	Name  string   `json:"name"`
+	Tags  []string `json:"tags"`
+	Ratio float64  `json:"ratio"`
+}
+
+// TestCoreJSONSafe checks that entries hold no value json cant encode,
+// and no data the caller may change after logging.
+func TestCoreJSONSafe(t *testing.T) {
+	var buffer = NewBuffer(10)
+	var log = newLogger(t, buffer, "")
+	var obj = &reflected{Name: "before", Tags: []string{"a"}, Ratio: 0.5}
+	var raw = []byte("raw")
+	log.With(zap.Float64("ctxNaN", math.NaN())).Info("values",
+		zap.Float64("nan", math.NaN()),
+		zap.Float64("inf", math.Inf(-1)),
+		zap.Float32("inf32", float32(math.Inf(1))),
+		zap.Complex128("complex", complex(1, 2)),
+		zap.Reflect("obj", obj),
+		zap.Reflect("unencodable", reflected{Ratio: math.NaN()}),
+		zap.Binary("raw", raw),
+		zap.Namespace("ns"),
+		zap.Float64("nested", math.Inf(1)),
+	)
+	obj.Name, obj.Tags[0] = "after", "b"
+	raw[0] = 'R'
+
+	var fields = buffer.Query(Query{})[0].Fields
+	for key, want := range map[string]interface{}{
+		"ctxNaN":  "NaN",
+		"nan":     "NaN",
+		"inf":     "-Inf",
+		"inf32":   "+Inf",
+		"complex": "(1+2i)",
+		"obj":     map[string]interface{}{"name": "before", "tags": []interface{}{"a"}, "ratio": json.Number("0.5")},
+		"raw":     []byte("raw"),
+		"ns":      map[string]interface{}{"nested": "+Inf"},
+	} {
+		if !reflect.DeepEqual(fields[key], want) {
+			t.Errorf("field %s = %#v, want %#v", key, fields[key], want)
+		}
+	}
+	if _, ok := fields["unencodable"].(string); !ok {
+		t.Errorf("unencodable value kept as %#v", fields["unencodable"])
+	}
+	if _, err := json.Marshal(fields); err != nil {
+		t.Errorf("fields not json safe: %v", err)
+	}
+}
+
+func get(t *testing.T, server *httptest.Server, query string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Get(server.URL + "?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestHandler(t *testing.T) {
+	var buffer = NewBuffer(10)
+	var server = httptest.NewServer(buffer)
+	defer server.Close()
+	var log = newLogger(t, buffer, "")
+	log.Named("api").Info("hello", zap.String("user", "u1"))
+	log.Named("api").Warn("slow", zap.String("user", "u2"))
+	log.Named("db").Error("failed", zap.Float64("ratio", math.NaN()))
+
+	resp, body := get(t, server, "")
+	var entries []Entry
+	if err := json.Unmarshal(body, &entries); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, body %q: %v", resp.StatusCode, body, err)
+	}
+	if got := messages(entries); !reflect.DeepEqual(got, []string{"hello", "slow", "failed"}) {
+		t.Errorf("got %q", got)
+	}
+	if entries[2].Fields["ratio"] != "NaN" {
+		t.Errorf("unexpected fields %v", entries[2].Fields)
+	}
+
+	for query, want := range map[string][]string{
+		"logger=api&level=warn":                      {"slow"},
+		"field=user:u1":                              {"hello"},
+		"since=1h&limit=1":                           {"failed"},
+		"after=1&until=2100-01-01T00:00:00Z&limit=5": {"slow", "failed"},
+		"logger=none":                                {},
+	} {
+		if _, body = get(t, server, query); json.Unmarshal(body, &entries) != nil {
+			t.Errorf("%s: body %q", query, body)
+		} else if got := messages(entries); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", query, got, want)
+		}
+	}
+
+	resp, body = get(t, server, "format=ndjson&level=warn")
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("ndjson served as %s", resp.Header.Get("Content-Type"))
+	}
+	var lines []string
+	for _, line := range bytes.Split(bytes.TrimSpace(body), []byte{'\n'}) {
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Errorf("line %q: %v", line, err)
+		}
+		lines = append(lines, e.Message)
+	}
+	if !reflect.DeepEqual(lines, []string{"slow", "failed"}) {
+		t.Errorf("ndjson lines %q", lines)
+	}
+
+	for _, query := range []string{"level=loud", "field=user", "after=-1", "limit=x", "since=yesterday", "format=xml"} {
+		if resp, _ = get(t, server, query); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestHandlerFollow(t *testing.T) {
+	var buffer = NewBuffer(10)
+	var server = httptest.NewServer(buffer)
+	defer server.Close()
+	var log = newLogger(t, buffer, "")
+	log.Info("kept")
+	log.Debug("filtered")
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?follow=true&level=info", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var lines = make(chan Entry)
+	go func() {
+		defer close(lines)
+		var decoder = json.NewDecoder(resp.Body)
+		for {
+			var e Entry
+			if decoder.Decode(&e) != nil {
+				return
+			}
+			lines <- e
+		}
+	}()
+	var next = func() Entry {
+		t.Helper()
+		select {
+		case e := <-lines:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("entry not streamed")
+			return Entry{}
+		}
+	}
+	if e := next(); e.Message != "kept" {
+		t.Errorf("streamed %q, want kept", e.Message)
+	}
+	log.Debug("filtered again")
+	log.Warn("followed", zap.Complex64("c", complex64(complex(0, 1))))
+	if e := next(); e.Message != "followed" || e.Fields["c"] != "(0+1i)" {
+		t.Errorf("streamed %q with %v, want followed", e.Message, e.Fields)
+	}
+	cancel()
+	for range lines {
+	}
+}
+
+func TestMarshalEntry(t *testing.T) {
+	var e Entry
+	if err := json.Unmarshal(marshalEntry(Entry{Message: "m", Fields: map[string]interface{}{"ch": make(chan int)}}), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Message != "m" || e.Fields[ringFieldsError] == nil || len(e.Fields) != 1 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
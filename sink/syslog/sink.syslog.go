@@ -0,0 +1,477 @@
+package sink_syslog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	SyslogSchema             = "syslog"
+	SyslogParamAddress       = "address"
+	SyslogParamNetwork       = "network"
+	SyslogParamFacility      = "facility"
+	SyslogParamAppName       = "appName"
+	SyslogParamFormat        = "format"
+	SyslogParamFraming       = "framing"
+	SyslogParamLevel         = "level"
+	SyslogParamTLSCAFile     = "tlsCAFile"
+	SyslogParamTLSInsecure   = "tlsInsecure"
+	SyslogConfigAddress      = "Address"
+	SyslogConfigNetwork      = "Network"
+	SyslogConfigFacility     = "Facility"
+	SyslogConfigAppName      = "AppName"
+	SyslogConfigFormat       = "Format"
+	SyslogConfigFraming      = "Framing"
+	SyslogConfigLevel        = "Level"
+	SyslogConfigTLSCAFile    = "TLSCAFile"
+	SyslogConfigTLSInsecure  = "TLSInsecureSkipVerify"
+	SyslogFormatRFC5424      = "rfc5424"
+	SyslogFormatRFC3164      = "rfc3164"
+	SyslogFramingOctet       = "octet"
+	SyslogFramingNewline     = "newline"
+	SyslogNetworkTLS         = "tls"
+	syslogDefaultNetwork     = "udp"
+	syslogDefaultFacility    = "user"
+	syslogRFC5424TimeLayout  = "2006-01-02T15:04:05.000000Z07:00"
+	syslogRFC3164TimeLayout  = time.Stamp
+	syslogMaxReconnectTrials = 1
+	syslogDialTimeout        = 10 * time.Second
+	syslogMinBackoff         = 100 * time.Millisecond
+	syslogMaxBackoff         = 30 * time.Second
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// severity maps zap levels to syslog severities.
+func severity(lvl zapcore.Level) int {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel:
+		return 2
+	case zapcore.PanicLevel:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func parseFacility(text string) (int, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if facility, ok := syslogFacilities[text]; ok {
+		return facility, nil
+	}
+	if facility, err := strconv.Atoi(text); err == nil && facility >= 0 && facility <= 23 {
+		return facility, nil
+	}
+	return 0, fmt.Errorf("unknown syslog facility `%s`", text)
+}
+
+// messageEncoder encodes the MSG part, time and level are carried by the header.
+func messageEncoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		TimeKey:        zapcore.OmitKey,
+		LevelKey:       zapcore.OmitKey,
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     "msg",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
+		EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	})
+}
+
+type syslogCore struct {
+	sink *syslogSink
+	enc  zapcore.Encoder
+}
+
+func (core *syslogCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= core.sink.level
+}
+
+func (core *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	var enc = core.enc.Clone()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	return &syslogCore{sink: core.sink, enc: enc}
+}
+
+func (core *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry { // nolint:gocritic
+	if core.Enabled(ent.Level) {
+		return ce.AddCore(ent, core)
+	}
+	return ce
+}
+
+func (core *syslogCore) Write(e zapcore.Entry, fields []zapcore.Field) error { // nolint:gocritic
+	msg, err := core.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	defer msg.Free()
+	return core.sink.send(e, msg)
+}
+
+func (core *syslogCore) Sync() error {
+	return nil
+}
+
+type syslogSink struct {
+	mu        sync.Mutex
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	facility  int
+	appName   string
+	hostname  string
+	pid       string
+	format    string
+	framing   string
+	level     zapcore.Level
+	conn      net.Conn
+	retryAt   time.Time
+	backoff   time.Duration
+	pool      buffer.Pool
+}
+
+func (sink *syslogSink) HijackCore() zapcore.Core {
+	return &syslogCore{sink: sink, enc: messageEncoder()}
+}
+
+// header formats the syslog header of an entry, the message follows it directly.
+func (sink *syslogSink) header(buf *buffer.Buffer, e zapcore.Entry) {
+	buf.AppendByte('<')
+	buf.AppendInt(int64(sink.facility*8 + severity(e.Level)))
+	buf.AppendByte('>')
+	if sink.format == SyslogFormatRFC3164 {
+		buf.AppendString(e.Time.Format(syslogRFC3164TimeLayout))
+		buf.AppendByte(' ')
+		buf.AppendString(sink.hostname)
+		buf.AppendByte(' ')
+		buf.AppendString(sink.appName)
+		buf.AppendByte('[')
+		buf.AppendString(sink.pid)
+		buf.AppendString("]: ")
+		return
+	}
+	buf.AppendString("1 ")
+	buf.AppendString(e.Time.Format(syslogRFC5424TimeLayout))
+	buf.AppendByte(' ')
+	buf.AppendString(sink.hostname)
+	buf.AppendByte(' ')
+	buf.AppendString(sink.appName)
+	buf.AppendByte(' ')
+	buf.AppendString(sink.pid)
+	buf.AppendByte(' ')
+	if msgID := headerField(e.LoggerName, 32); msgID != "" {
+		buf.AppendString(msgID)
+	} else {
+		buf.AppendByte('-')
+	}
+	buf.AppendString(" - ")
+}
+
+// headerField keeps printable ascii without spaces, as required by RFC 5424 header fields.
+func headerField(text string, maxLen int) string {
+	var out = make([]byte, 0, len(text))
+	for i := 0; i < len(text) && len(out) < maxLen; i++ {
+		if c := text[i]; c > 32 && c < 127 {
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+func (sink *syslogSink) send(e zapcore.Entry, msg *buffer.Buffer) error {
+	var frame = sink.pool.Get()
+	defer frame.Free()
+	var line = sink.pool.Get()
+	defer line.Free()
+	sink.header(line, e)
+	_, _ = line.Write(bytes.TrimSuffix(msg.Bytes(), []byte(zapcore.DefaultLineEnding)))
+	switch sink.framing {
+	case SyslogFramingOctet:
+		frame.AppendInt(int64(line.Len()))
+		frame.AppendByte(' ')
+		_, _ = frame.Write(line.Bytes())
+	case SyslogFramingNewline:
+		_, _ = frame.Write(line.Bytes())
+		frame.AppendByte('\n')
+	default:
+		_, _ = frame.Write(line.Bytes())
+	}
+	_, err := sink.Write(frame.Bytes())
+	return err
+}
+
+func (sink *syslogSink) dial() (net.Conn, error) {
+	if sink.network == SyslogNetworkTLS {
+		return tls.DialWithDialer(&net.Dialer{Timeout: syslogDialTimeout}, "tcp", sink.address, sink.tlsConfig)
+	}
+	return net.DialTimeout(sink.network, sink.address, syslogDialTimeout)
+}
+
+// redial connects unless the last dial failed within the current backoff, so
+// writes while the server is down are dropped at once instead of each waiting
+// for the dial timeout. The backoff doubles on each failed dial.
+func (sink *syslogSink) redial() (net.Conn, error) {
+	if wait := time.Until(sink.retryAt); wait > 0 {
+		return nil, fmt.Errorf("message dropped, reconnecting in %s", wait.Round(time.Millisecond))
+	}
+	conn, err := sink.dial()
+	if err != nil {
+		if sink.backoff *= 2; sink.backoff < syslogMinBackoff {
+			sink.backoff = syslogMinBackoff
+		} else if sink.backoff > syslogMaxBackoff {
+			sink.backoff = syslogMaxBackoff
+		}
+		sink.retryAt = time.Now().Add(sink.backoff)
+		return nil, err
+	}
+	sink.backoff, sink.retryAt = 0, time.Time{}
+	return conn, nil
+}
+
+// Write sends one framed message, reconnecting once if the connection was lost.
+func (sink *syslogSink) Write(p []byte) (n int, err error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	for trial := 0; trial <= syslogMaxReconnectTrials; trial++ {
+		if sink.conn == nil {
+			if sink.conn, err = sink.redial(); err != nil {
+				break
+			}
+		}
+		if n, err = sink.conn.Write(p); err == nil {
+			return n, nil
+		}
+		_ = sink.conn.Close()
+		sink.conn = nil
+	}
+	return 0, fmt.Errorf("cant write syslog message to %s://%s: %w", sink.network, sink.address, err)
+}
+
+func (sink *syslogSink) Sync() error {
+	return nil
+}
+
+func (sink *syslogSink) Close() error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.conn == nil {
+		return nil
+	}
+	var err = sink.conn.Close()
+	sink.conn = nil
+	return err
+}
+
+func loadTLSConfig(caFile string, insecure bool) (*tls.Config, error) {
+	var config = &tls.Config{InsecureSkipVerify: insecure} // #nosec G402
+	if caFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cant read syslog tls ca file: %w", err)
+	}
+	config.RootCAs = x509.NewCertPool()
+	if !config.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in syslog tls ca file `%s`", caFile)
+	}
+	return config, nil
+}
+
+func register(logPath *url.URL) (sink zap.Sink, err error) {
+	var params = logPath.Query()
+	var _sink = &syslogSink{
+		network: params.Get(SyslogParamNetwork),
+		address: params.Get(SyslogParamAddress),
+		appName: headerField(params.Get(SyslogParamAppName), 48),
+		format:  params.Get(SyslogParamFormat),
+		framing: params.Get(SyslogParamFraming),
+		pid:     strconv.Itoa(os.Getpid()),
+		pool:    buffer.NewPool(),
+	}
+	if _sink.address == "" {
+		return nil, fmt.Errorf("undefined arg `%s`", SyslogParamAddress)
+	}
+	if _sink.network == "" {
+		_sink.network = syslogDefaultNetwork
+	}
+	if _sink.appName == "" {
+		_sink.appName = headerField(filepath.Base(os.Args[0]), 48)
+	}
+	if _sink.facility, err = parseFacility(params.Get(SyslogParamFacility)); err != nil {
+		return nil, err
+	}
+	if levelVal := params.Get(SyslogParamLevel); levelVal != "" {
+		if err = _sink.level.UnmarshalText([]byte(levelVal)); err != nil {
+			return nil, fmt.Errorf("cant parse arg `%s`: %w", SyslogParamLevel, err)
+		}
+	} else {
+		_sink.level = zapcore.DebugLevel
+	}
+	if _sink.network == SyslogNetworkTLS {
+		if _sink.tlsConfig, err = loadTLSConfig(
+			params.Get(SyslogParamTLSCAFile), params.Get(SyslogParamTLSInsecure) == "true",
+		); err != nil {
+			return nil, err
+		}
+	}
+	if _sink.hostname, err = os.Hostname(); err != nil || _sink.hostname == "" {
+		_sink.hostname = "-"
+	}
+	_sink.hostname = headerField(_sink.hostname, 255)
+	return _sink, nil
+}
+
+func init() {
+	if err := zap.RegisterSink(SyslogSchema, register); err != nil {
+		panic(fmt.Errorf("cant register syslog sink: %w", err))
+	}
+}
+
+type urlGenerator struct {
+	topic   string
+	appName string
+}
+
+func (g *urlGenerator) WithTopic(topic string) *urlGenerator {
+	return &urlGenerator{
+		topic:   topic,
+		appName: g.appName,
+	}
+}
+
+func (g *urlGenerator) Provider() string {
+	if g.topic != "" {
+		return g.topic
+	}
+	return SyslogSchema
+}
+
+func (g *urlGenerator) Generate(argStore func(string) (string, bool)) (string, error) {
+	var ok bool
+	var outputQuery = url.Values{}
+	var outputPath = &url.URL{Scheme: SyslogSchema, Host: "localhost"}
+	{
+		var address, network string
+		if address, ok = argStore(SyslogConfigAddress); !ok || address == "" {
+			return "", fmt.Errorf("`%s` not optional", SyslogConfigAddress)
+		}
+		if network, ok = argStore(SyslogConfigNetwork); !ok || network == "" {
+			network = syslogDefaultNetwork
+		}
+		switch network = strings.ToLower(strings.TrimSpace(network)); network {
+		case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix", "unixgram", SyslogNetworkTLS:
+		default:
+			return "", fmt.Errorf("unsupported `%s`: %s", SyslogConfigNetwork, network)
+		}
+		outputQuery.Set(SyslogParamAddress, address)
+		outputQuery.Set(SyslogParamNetwork, network)
+	}
+	{
+		var facility, appName, format, framing string
+		if facility, ok = argStore(SyslogConfigFacility); !ok || facility == "" {
+			facility = syslogDefaultFacility
+		}
+		if _, err := parseFacility(facility); err != nil {
+			return "", err
+		}
+		outputQuery.Set(SyslogParamFacility, strings.ToLower(strings.TrimSpace(facility)))
+		if appName, ok = argStore(SyslogConfigAppName); !ok || appName == "" {
+			appName = g.appName
+		}
+		if appName != "" {
+			outputQuery.Set(SyslogParamAppName, appName)
+		}
+		if format, ok = argStore(SyslogConfigFormat); !ok || format == "" {
+			format = SyslogFormatRFC5424
+		}
+		if format = strings.ToLower(strings.TrimSpace(format)); format != SyslogFormatRFC5424 &&
+			format != SyslogFormatRFC3164 {
+			return "", fmt.Errorf("unsupported `%s`: %s", SyslogConfigFormat, format)
+		}
+		outputQuery.Set(SyslogParamFormat, format)
+		if framing, ok = argStore(SyslogConfigFraming); !ok || framing == "" {
+			framing = defaultFraming(outputQuery.Get(SyslogParamNetwork))
+		}
+		switch framing = strings.ToLower(strings.TrimSpace(framing)); framing {
+		case "", "none", SyslogFramingOctet, SyslogFramingNewline:
+		default:
+			return "", fmt.Errorf("unsupported `%s`: %s", SyslogConfigFraming, framing)
+		}
+		if framing != "" && framing != "none" {
+			outputQuery.Set(SyslogParamFraming, framing)
+		}
+	}
+	if level, ok := argStore(SyslogConfigLevel); ok && level != "" {
+		var lvl zapcore.Level
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return "", fmt.Errorf("invalid `%s`: %w", SyslogConfigLevel, err)
+		}
+		outputQuery.Set(SyslogParamLevel, lvl.String())
+	}
+	if outputQuery.Get(SyslogParamNetwork) == SyslogNetworkTLS {
+		if caFile, ok := argStore(SyslogConfigTLSCAFile); ok && caFile != "" {
+			outputQuery.Set(SyslogParamTLSCAFile, caFile)
+		}
+		if insecure, ok := argStore(SyslogConfigTLSInsecure); ok && insecure != "" {
+			if enabled, err := strconv.ParseBool(strings.TrimSpace(insecure)); err != nil {
+				return "", fmt.Errorf("cant parse `%s`: %w", SyslogConfigTLSInsecure, err)
+			} else if enabled {
+				outputQuery.Set(SyslogParamTLSInsecure, "true")
+			}
+		}
+	}
+	outputPath.RawQuery = outputQuery.Encode()
+	return outputPath.String(), nil
+}
+
+// defaultFraming uses octet counting (RFC 6587) on stream transports,
+// datagrams carry one message each.
+func defaultFraming(network string) string {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", SyslogNetworkTLS:
+		return SyslogFramingOctet
+	default:
+		return ""
+	}
+}
+
+func NewURLGenerator(appName string) *urlGenerator {
+	return &urlGenerator{
+		appName: appName,
+	}
+}
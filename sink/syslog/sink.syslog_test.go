@@ -0,0 +1,256 @@
+package sink_syslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// openSink opens the sink of app sending to address, keys set the other topic config.
+func openSink(t *testing.T, address string, keys map[string]string) (*zap.Logger, *syslogSink) {
+	t.Helper()
+	rawURL, err := NewURLGenerator("app").Generate(func(key string) (string, bool) {
+		if key == SyslogConfigAddress {
+			return address, true
+		}
+		val, ok := keys[key]
+		return val, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinkURL, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_sink, err := register(sinkURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink = _sink.(*syslogSink)
+	t.Cleanup(func() { _ = sink.Close() })
+	return zap.New(sink.HijackCore()), sink
+}
+
+// acceptStream serves the first connection on listener, read parses its messages.
+func acceptStream(t *testing.T, listener net.Listener, read func(*bufio.Reader) (string, error)) <-chan string {
+	t.Helper()
+	var messages = make(chan string, 10)
+	go func() {
+		defer close(messages)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var reader = bufio.NewReader(conn)
+		for {
+			msg, err := read(reader)
+			if err != nil {
+				return
+			}
+			messages <- msg
+		}
+	}()
+	return messages
+}
+
+// readOctetCounted reads one RFC 6587 octet counted frame.
+func readOctetCounted(reader *bufio.Reader) (string, error) {
+	var size, err = reader.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+	if err != nil {
+		return "", err
+	}
+	var msg = make([]byte, length)
+	if _, err = io.ReadFull(reader, msg); err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+func receive(t *testing.T, messages <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+		return ""
+	}
+}
+
+// parseRFC5424 splits a message into its header fields and the JSON payload.
+func parseRFC5424(t *testing.T, msg string) (header []string, payload map[string]interface{}) {
+	t.Helper()
+	var parts = strings.SplitN(msg, " ", 8)
+	if len(parts) != 8 {
+		t.Fatalf("malformed message %q", msg)
+	}
+	if err := json.Unmarshal([]byte(parts[7]), &payload); err != nil {
+		t.Fatalf("payload of %q: %v", msg, err)
+	}
+	return parts[:7], payload
+}
+
+func TestSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var log, sink = openSink(t, conn.LocalAddr().String(), map[string]string{
+		SyslogConfigFacility: "local3",
+		SyslogConfigLevel:    "info",
+	})
+	log.Debug("dropped")
+	log.Named("svc").With(zap.Int("k", 1)).Warn("hello")
+	var buf = make([]byte, 2048)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header, payload = parseRFC5424(t, string(buf[:n]))
+	// local3 * 8 + warning
+	if header[0] != "<156>1" || header[2] != sink.hostname || header[3] != "app" ||
+		header[4] != strconv.Itoa(os.Getpid()) || header[5] != "svc" || header[6] != "-" {
+		t.Errorf("unexpected header %q", header)
+	}
+	if _, err = time.Parse(syslogRFC5424TimeLayout, header[1]); err != nil {
+		t.Errorf("unexpected timestamp %q: %v", header[1], err)
+	}
+	if payload["msg"] != "hello" || payload["k"] != float64(1) || payload["logger"] != "svc" {
+		t.Errorf("unexpected payload %v", payload)
+	}
+}
+
+func TestSinkTCPOctetFraming(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var messages = acceptStream(t, listener, readOctetCounted)
+	var log, _ = openSink(t, listener.Addr().String(), map[string]string{SyslogConfigNetwork: "tcp"})
+	log.Error("first\nwith newline")
+	log.Info("second")
+	for i, want := range []struct{ pri, msg string }{
+		{pri: "<11>1", msg: "first\nwith newline"},
+		{pri: "<14>1", msg: "second"},
+	} {
+		var header, payload = parseRFC5424(t, receive(t, messages))
+		if header[0] != want.pri || header[5] != "-" || payload["msg"] != want.msg {
+			t.Errorf("message %d: header %q, payload %v", i, header, payload)
+		}
+	}
+}
+
+func TestSinkRFC3164NewlineFraming(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var messages = acceptStream(t, listener, func(reader *bufio.Reader) (string, error) {
+		var line, err = reader.ReadString('\n')
+		return strings.TrimSuffix(line, "\n"), err
+	})
+	var log, sink = openSink(t, listener.Addr().String(), map[string]string{
+		SyslogConfigNetwork: "tcp",
+		SyslogConfigFormat:  SyslogFormatRFC3164,
+		SyslogConfigFraming: SyslogFramingNewline,
+	})
+	log.Info("hello")
+	var msg = receive(t, messages)
+	if !strings.HasPrefix(msg, "<14>") || len(msg) < 4+len(syslogRFC3164TimeLayout) {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	msg = msg[4:]
+	if _, err = time.Parse(syslogRFC3164TimeLayout, msg[:len(syslogRFC3164TimeLayout)]); err != nil {
+		t.Errorf("unexpected timestamp in %q: %v", msg, err)
+	}
+	var tag = fmt.Sprintf(" %s app[%d]: ", sink.hostname, os.Getpid())
+	if msg = msg[len(syslogRFC3164TimeLayout):]; !strings.HasPrefix(msg, tag) {
+		t.Fatalf("message %q lacks %q", msg, tag)
+	}
+	var payload map[string]interface{}
+	if err = json.Unmarshal([]byte(strings.TrimPrefix(msg, tag)), &payload); err != nil || payload["msg"] != "hello" {
+		t.Errorf("payload of %q: %v", msg, err)
+	}
+}
+
+// TestSinkReconnectBackoff checks that writes after a failed dial are dropped
+// without dialing until the backoff passes, and that the backoff grows.
+func TestSinkReconnectBackoff(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var address = listener.Addr().String()
+	_ = listener.Close()
+	var _, sink = openSink(t, address, map[string]string{SyslogConfigNetwork: "tcp"})
+	if _, err = sink.Write([]byte("refused")); err == nil || strings.Contains(err.Error(), "dropped") {
+		t.Fatalf("got %v, want a dial error", err)
+	}
+	if sink.backoff != syslogMinBackoff {
+		t.Errorf("backoff %s, want %s", sink.backoff, syslogMinBackoff)
+	}
+	if _, err = sink.Write([]byte("dropped")); err == nil || !strings.Contains(err.Error(), "dropped") {
+		t.Fatalf("got %v, want the write dropped", err)
+	}
+	sink.retryAt = time.Time{}
+	_, _ = sink.Write([]byte("refused again"))
+	if sink.backoff != 2*syslogMinBackoff {
+		t.Errorf("backoff %s, want %s", sink.backoff, 2*syslogMinBackoff)
+	}
+
+	if listener, err = net.Listen("tcp", address); err != nil {
+		t.Skipf("cant listen on %s again: %v", address, err)
+	}
+	defer listener.Close()
+	var messages = acceptStream(t, listener, func(reader *bufio.Reader) (string, error) {
+		return reader.ReadString('\n')
+	})
+	sink.retryAt = time.Time{}
+	if _, err = sink.Write([]byte("reconnected\n")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, messages); msg != "reconnected\n" {
+		t.Errorf("got %q", msg)
+	}
+	if sink.backoff != 0 {
+		t.Errorf("backoff %s kept after reconnect", sink.backoff)
+	}
+}
+
+func TestGenerateRejects(t *testing.T) {
+	for name, config := range map[string]map[string]string{
+		"address":  {},
+		"network":  {SyslogConfigAddress: "localhost:514", SyslogConfigNetwork: "sctp"},
+		"facility": {SyslogConfigAddress: "localhost:514", SyslogConfigFacility: "local9"},
+		"format":   {SyslogConfigAddress: "localhost:514", SyslogConfigFormat: "rfc1"},
+		"framing":  {SyslogConfigAddress: "localhost:514", SyslogConfigFraming: "lines"},
+	} {
+		var config = config
+		if _, err := NewURLGenerator("app").Generate(func(key string) (string, bool) {
+			val, ok := config[key]
+			return val, ok
+		}); err == nil {
+			t.Errorf("invalid %s accepted", name)
+		}
+	}
+}